@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -8,9 +9,13 @@ import (
 	"os"
 
 	"github.com/go-logr/stdr"
-	"github.com/jodevsa/wireguard-operator/internal/iptables"
+	"github.com/jodevsa/wireguard-operator/internal/firewall"
+	"github.com/jodevsa/wireguard-operator/internal/metrics"
+	"github.com/jodevsa/wireguard-operator/internal/tc"
 	"github.com/jodevsa/wireguard-operator/pkg/agent"
 	"github.com/jodevsa/wireguard-operator/pkg/wireguard"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -20,12 +25,16 @@ func main() {
 	var wgUserspaceImplementationFallback string
 	var wireguardListenPort int
 	var wgUseUserspaceImpl bool
+	var firewallBackend string
+	var metricsAddress string
 	flag.StringVar(&configFilePath, "state", "./state.json", "The location of the file that states the desired state")
 	flag.StringVar(&iface, "wg-iface", "wg0", "the wg device name. Default is wg0")
 	flag.StringVar(&wgUserspaceImplementationFallback, "wg-userspace-implementation-fallback", "wireguard-go", "The userspace implementation of wireguard to fallback to")
 	flag.IntVar(&wireguardListenPort, "wg-listen-port", 51820, "the UDP port wireguard is listening on")
 	flag.IntVar(&verbosity, "v", 1, "the verbosity level")
 	flag.BoolVar(&wgUseUserspaceImpl, "wg-use-userspace-implementation", false, "Use userspace implementation")
+	flag.StringVar(&firewallBackend, "firewall-backend", firewall.BackendAuto, "The firewall backend to use: iptables, nftables or auto to detect it")
+	flag.StringVar(&metricsAddress, "metrics-address", ":9586", "The address the prometheus metrics endpoint binds to")
 	flag.Parse()
 
 	println(fmt.Sprintf(
@@ -39,8 +48,8 @@ func main() {
           .::::::::::::::::::::::..:!7Y#@@@@@#Y~:.::::::::::::::::::.     wg-listen-port: %d      
           .:::::::::::::::::::.:^!?JYYJ?JG&@@@@@#7::::::::::::::::::.     wg-use-userspace-implementation: %v      
           .:::::::::::::::::.^J#@@@@@@@@@&#B&@@@@@G:::::::::::::::::.     wg-userspace-implementation-fallback: %s           
-          .:::::::::::::::::J@@@@@@@@@@@@@@@&G@@@@@J.:::::::::::::::.           
-          .::::::::::::::::5@@@@@#?~~~7P@@@@@&B@@@@P.:::::::::::::::.           
+          .:::::::::::::::::J@@@@@@@@@@@@@@@&G@@@@@J.:::::::::::::::.     firewall-backend: %s      
+          .::::::::::::::::5@@@@@#?~~~7P@@@@@&B@@@@P.:::::::::::::::.     metrics-address: %s      
           .:::::::::::::::^@@@@@P..::::.~@@@@B&@@@@!::::::::::::::::.           
           .:::::::::::::::~@@@@@J.::::::^@@@#&@@@@P:::::::::::::::::.           
           .::::::::::::::::B@@@@@P!^:.:~G&&&@@@@@5::::::::::::::::::.           
@@ -62,7 +71,7 @@ func main() {
 	 \        /\    \_\  \    /    |    \\___  /\  ___/|   |  |  |   
 	  \__/\  /  \______  /    \____|__  /_____/  \___  |___|  |__|   
 		   \/          \/             \/             \/     \/
-`, iface, configFilePath, wireguardListenPort, wgUseUserspaceImpl, wgUserspaceImplementationFallback))
+`, iface, configFilePath, wireguardListenPort, wgUseUserspaceImpl, wgUserspaceImplementationFallback, firewallBackend, metricsAddress))
 
 	stdr.SetVerbosity(verbosity)
 	log := stdr.NewWithOptions(log.New(os.Stderr, "", log.LstdFlags), stdr.Options{LogCaller: stdr.All})
@@ -75,22 +84,49 @@ func main() {
 		WgUserspaceImplementationFallback: wgUserspaceImplementationFallback,
 		WgUseUserspaceImpl:                wgUseUserspaceImpl,
 	}
-	it := iptables.Iptables{
-		Logger: log.WithName("iptables"),
+	agentMetrics := metrics.NewAgent()
+	if err := agentMetrics.Register(prometheus.DefaultRegisterer); err != nil {
+		log.Error(err, "Error while registering the metrics")
+		os.Exit(1)
+	}
+
+	peerCollector := &metrics.PeerCollector{Stats: wg.Stats}
+	if err := prometheus.Register(peerCollector); err != nil {
+		log.Error(err, "Error while registering the metrics")
+		os.Exit(1)
+	}
+
+	fw, err := firewall.New(firewallBackend, log.WithName("firewall"), agentMetrics.FirewallApplyFailures)
+	if err != nil {
+		log.Error(err, "Error while creating the firewall")
+		os.Exit(1)
+	}
+
+	shaper := tc.Tc{
+		Logger: log.WithName("tc"),
+		Iface:  iface,
 	}
 
 	close, err := agent.OnStateChange(configFilePath, log.WithName("onStateChange"), func(state agent.State) {
 		log.Info("Received a new state")
-		err := wg.Sync(state)
+		agentMetrics.StateChanges.Inc()
+		peerCollector.SetState(state)
+
+		err := agentMetrics.ObserveSync(metrics.ComponentWireguard, func() error { return wg.Sync(state) })
 		if err != nil {
 			log.Error(err, "Error while sycncing wireguard")
 		}
 
-		err = it.Sync(state)
+		err = agentMetrics.ObserveSync(metrics.ComponentFirewall, func() error { return fw.Sync(state) })
 		if err != nil {
 			log.Error(err, "Error while syncing network policies")
 		}
 
+		err = agentMetrics.ObserveSync(metrics.ComponentTrafficShaping, func() error { return shaper.Sync(state) })
+		if err != nil {
+			log.Error(err, "Error while syncing traffic shaping")
+		}
+
 	})
 
 	if err != nil {
@@ -102,6 +138,15 @@ func main() {
 
 	httpLog := log.WithName("http")
 
+	go func() {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", promhttp.Handler())
+		if err := http.ListenAndServe(metricsAddress, metricsMux); err != nil {
+			httpLog.Error(err, "Error while serving the metrics")
+			os.Exit(1)
+		}
+	}()
+
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		state, _, err := agent.GetDesiredState(configFilePath)
 
@@ -131,5 +176,20 @@ func main() {
 
 		w.WriteHeader(http.StatusOK)
 	})
+
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := wg.Stats()
+		if err != nil {
+			httpLog.Error(err, "unable to collect the stats of the peers")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			httpLog.Error(err, "unable to write the stats of the peers")
+		}
+	})
+
 	http.ListenAndServe(":8080", nil)
 }
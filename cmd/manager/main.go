@@ -20,14 +20,20 @@ import (
 	"flag"
 	"fmt"
 	vpnv1alpha1 "github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	vpnv1beta1 "github.com/jodevsa/wireguard-operator/pkg/api/v1beta1"
 	"github.com/jodevsa/wireguard-operator/pkg/controllers"
+	"github.com/jodevsa/wireguard-operator/pkg/webhooks"
 	v1 "k8s.io/api/core/v1"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	// Embed the time zone database, so that the time zones of the access schedules of peers resolve in any image.
+	_ "time/tzdata"
+
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -46,6 +52,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(vpnv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(vpnv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -55,6 +62,7 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var wgImage string
+	var peerStatsInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&wgImage, "agent-image", "", "The image used for wireguard server")
@@ -62,6 +70,7 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&agentImagePullPolicy, "agent-image-pull-policy", "IfNotPresent", "Use userspace implementation")
+	flag.DurationVar(&peerStatsInterval, "peer-stats-interval", time.Minute, "The minimum interval between two updates of the handshake and traffic stats in the status of the peers of a Wireguard. 0 disables the stats.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -93,17 +102,41 @@ func main() {
 		Scheme:               mgr.GetScheme(),
 		AgentImage:           wgImage,
 		AgentImagePullPolicy: v1.PullPolicy(agentImagePullPolicy),
+		PeerStatsInterval:    peerStatsInterval,
+		Recorder:             mgr.GetEventRecorderFor("wireguard-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Wireguard")
 		os.Exit(1)
 	}
 	if err = (&controllers.WireguardPeerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("wireguardpeer-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WireguardPeer")
 		os.Exit(1)
 	}
+	if err = mgr.Add(&controllers.StorageVersionMigrator{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+	}); err != nil {
+		setupLog.Error(err, "unable to add the storage version migrator")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhooks.SetupWireguardWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Wireguard")
+			os.Exit(1)
+		}
+		if err = webhooks.SetupWireguardPeerWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WireguardPeer")
+			os.Exit(1)
+		}
+		if err = webhooks.SetupWireguardNetworkPolicyWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WireguardNetworkPolicy")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
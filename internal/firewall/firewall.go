@@ -0,0 +1,63 @@
+package firewall
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/go-logr/logr"
+	gonftables "github.com/google/nftables"
+	"github.com/jodevsa/wireguard-operator/internal/iptables"
+	"github.com/jodevsa/wireguard-operator/internal/nftables"
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	BackendAuto     = "auto"
+	BackendIptables = "iptables"
+	BackendNftables = "nftables"
+)
+
+// Firewall enforces the network policies of the peers and masquerades their traffic.
+type Firewall interface {
+	Sync(state agent.State) error
+}
+
+// New returns the firewall of the given backend. The backend is detected if it is BackendAuto. Failures to apply the
+// rules are counted in applyFailures, labelled by backend.
+func New(backend string, logger logr.Logger, applyFailures *prometheus.CounterVec) (Firewall, error) {
+	if backend == BackendAuto {
+		backend = Detect()
+		logger.Info("detected firewall backend", "backend", backend)
+	}
+
+	switch backend {
+	case BackendIptables:
+		return &iptables.Iptables{Logger: logger.WithName("iptables"), ApplyFailures: applyFailures.WithLabelValues(backend)}, nil
+	case BackendNftables:
+		return &nftables.Nftables{Logger: logger.WithName("nftables"), ApplyFailures: applyFailures.WithLabelValues(backend)}, nil
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %s", backend)
+	}
+}
+
+// Detect returns BackendNftables if the iptables binaries are missing or are the iptables-nft shim, and nftables is
+// supported by the kernel. It returns BackendIptables otherwise.
+func Detect() string {
+	version, err := exec.Command("iptables", "--version").Output()
+	if err == nil && !strings.Contains(string(version), "nf_tables") {
+		return BackendIptables
+	}
+
+	conn, err := gonftables.New()
+	if err != nil {
+		return BackendIptables
+	}
+
+	if _, err := conn.ListTables(); err != nil {
+		return BackendIptables
+	}
+
+	return BackendNftables
+}
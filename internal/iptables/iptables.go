@@ -1,23 +1,112 @@
 package iptables
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"os/exec"
 	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/jodevsa/wireguard-operator/pkg/agent"
 	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func ApplyRules(rules string) error {
-	cmd := exec.Command("iptables-restore")
+type ipFamily struct {
+	restoreCommand string
+	icmpProtocol   string
+	icmpTypeOption string
+	rejectWith     string
+	ipv6           bool
+}
+
+var ipv4 = ipFamily{restoreCommand: "iptables-restore", icmpProtocol: "icmp", icmpTypeOption: "--icmp-type", rejectWith: "icmp-port-unreachable"}
+var ipv6 = ipFamily{restoreCommand: "ip6tables-restore", icmpProtocol: "ipv6-icmp", icmpTypeOption: "--icmpv6-type", rejectWith: "icmp6-port-unreachable", ipv6: true}
+
+// matches returns false if address is an IP address of the other family. Hostnames match both families.
+func (f ipFamily) matches(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		if _, ipnet, err := net.ParseCIDR(address); err == nil {
+			ip = ipnet.IP
+		}
+	}
+
+	if ip == nil {
+		return true
+	}
+
+	return (ip.To4() == nil) == f.ipv6
+}
+
+// lookupIP resolves hostnames used as destinations. It is a variable to be replaced in tests.
+var lookupIP = net.LookupIP
+
+// resolveAll returns the IP addresses or CIDRs of the family for addresses. Hostnames are resolved, and those that do
+// not resolve are logged and skipped, so that they only leave out the rules matching them.
+func (f ipFamily) resolveAll(log logr.Logger, addresses []string) []string {
+	var resolved []string
+	for _, address := range addresses {
+		if address == "" || !f.matches(address) {
+			continue
+		}
+
+		if net.ParseIP(address) != nil {
+			resolved = append(resolved, address)
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(address); err == nil {
+			resolved = append(resolved, address)
+			continue
+		}
+
+		ips, err := lookupIP(address)
+		if err != nil {
+			log.Error(err, "Skipping the rules of an address that does not resolve", "address", address)
+			continue
+		}
+
+		for _, ip := range ips {
+			if f.matches(ip.String()) {
+				resolved = append(resolved, ip.String())
+			}
+		}
+	}
+
+	return resolved
+}
+
+func applyRules(family ipFamily, rules string) error {
+	cmd := exec.Command(family.restoreCommand)
 	cmd.Stdin = strings.NewReader(rules)
 	return cmd.Run()
 }
 
+func ApplyRules(rules string) error {
+	return applyRules(ipv4, rules)
+}
+
+func ApplyIp6Rules(rules string) error {
+	return applyRules(ipv6, rules)
+}
+
 type Iptables struct {
 	Logger logr.Logger
+	// ApplyFailures counts the failures to apply the rules. It is optional.
+	ApplyFailures prometheus.Counter
+}
+
+// applyRules applies rules with the restore command of family, counting failures in ApplyFailures.
+func (it *Iptables) applyRules(family ipFamily, rules string) error {
+	err := applyRules(family, rules)
+	if err != nil && it.ApplyFailures != nil {
+		it.ApplyFailures.Inc()
+	}
+
+	return err
 }
 
 func (it *Iptables) Sync(state agent.State) error {
@@ -26,19 +115,49 @@ func (it *Iptables) Sync(state agent.State) error {
 	dns := state.Server.Status.Dns
 	peers := state.Peers
 
-	cfg := GenerateIptableRulesFromPeers(wgHostName, dns, peers)
+	network, _, err := agent.GetNetwork(state.Server)
+	if err != nil {
+		return err
+	}
+
+	peerIsolation := state.Server.Spec.PeerIsolation
+
+	cfg := GenerateIptableRulesFromPeers(it.Logger, wgHostName, dns, network.String(), peers, peerIsolation)
 
-	err := ApplyRules(cfg)
+	err = it.applyRules(ipv4, cfg)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	ipv6Network, ipv6Gateway, err := agent.GetIpv6Network(state.Server)
+	if err != nil {
+		return err
+	}
+
+	if ipv6Network == nil {
+		return nil
+	}
+
+	ip6Cfg := GenerateIp6tableRulesFromPeers(it.Logger, ipv6Gateway.String(), dns, ipv6Network.String(), peers, peerIsolation)
+
+	return it.applyRules(ipv6, ip6Cfg)
+}
+
+// GenerateIptableRulesFromNetworkPolicies returns the rules of the chain of a peer. Hostnames are resolved, and those
+// that do not resolve are logged to log and skip only the rules matching them.
+func GenerateIptableRulesFromNetworkPolicies(log logr.Logger, policies v1alpha1.EgressNetworkPolicies, peerIp string, kubeDnsIp string, wgServerIp string) string {
+	return generateRulesFromNetworkPolicies(log, ipv4, policies, peerIp, kubeDnsIp, wgServerIp)
+}
+
+// GenerateIp6tableRulesFromNetworkPolicies is the ip6tables equivalent of GenerateIptableRulesFromNetworkPolicies.
+// Policies targeting IPv4 destinations are skipped.
+func GenerateIp6tableRulesFromNetworkPolicies(log logr.Logger, policies v1alpha1.EgressNetworkPolicies, peerIp string, kubeDnsIp string, wgServerIp string) string {
+	return generateRulesFromNetworkPolicies(log, ipv6, policies, peerIp, kubeDnsIp, wgServerIp)
 }
 
-func GenerateIptableRulesFromNetworkPolicies(policies v1alpha1.EgressNetworkPolicies, peerIp string, kubeDnsIp string, wgServerIp string) string {
-	peerChain := strings.ReplaceAll(peerIp, ".", "-")
+func generateRulesFromNetworkPolicies(log logr.Logger, family ipFamily, policies v1alpha1.EgressNetworkPolicies, peerIp string, kubeDnsIp string, wgServerIp string) string {
+	peerChain := peerChainName(peerIp)
 
 	rules := []string{
 		// add a comment
@@ -49,24 +168,28 @@ func GenerateIptableRulesFromNetworkPolicies(policies v1alpha1.EgressNetworkPoli
 
 		// associate peer chain to FORWARD chain
 		fmt.Sprintf("-A FORWARD -s %s -j %s", peerIp, peerChain),
+	}
 
-		// allow peer to ping (ICMP) wireguard server for debugging purposes
-		fmt.Sprintf("-A %s -d %s -p icmp -j ACCEPT", peerChain, wgServerIp),
+	// allow peer to ping (ICMP) wireguard server for debugging purposes
+	for _, ip := range family.resolveAll(log, []string{wgServerIp}) {
+		rules = append(rules, fmt.Sprintf("-A %s -d %s -p %s -j ACCEPT", peerChain, ip, family.icmpProtocol))
+	}
 
-		// allow peer to communicate with itself
-		fmt.Sprintf("-A %s -d %s -j ACCEPT", peerChain, peerIp),
+	// allow peer to communicate with itself
+	rules = append(rules, fmt.Sprintf("-A %s -d %s -j ACCEPT", peerChain, peerIp))
 
+	if family.matches(kubeDnsIp) {
 		// allow peer to communicate with kube-dns
-		fmt.Sprintf("-A %s -d %s -p UDP --dport 53 -j ACCEPT", peerChain, kubeDnsIp),
+		rules = append(rules, fmt.Sprintf("-A %s -d %s -p UDP --dport 53 -j ACCEPT", peerChain, kubeDnsIp))
 	}
 
-	for _, policy := range policies {
-		rules = append(rules, EgressNetworkPolicyToIpTableRules(policy, peerChain)...)
+	for i, policy := range policies {
+		rules = append(rules, egressNetworkPolicyToRules(log, family, policy, peerChain, fmt.Sprintf("%s-%d", peerChain, i))...)
 	}
 
 	// if policies are defined impose an implicit deny all
 	if len(policies) != 0 {
-		rules = append(rules, fmt.Sprintf("-A %s -j REJECT --reject-with icmp-port-unreachable", peerChain))
+		rules = append(rules, fmt.Sprintf("-A %s -j REJECT --reject-with %s", peerChain, family.rejectWith))
 	}
 
 	// add a comment
@@ -75,23 +198,162 @@ func GenerateIptableRulesFromNetworkPolicies(policies v1alpha1.EgressNetworkPoli
 	return strings.Join(rules, "\n")
 }
 
-func GenerateIptableRulesFromPeers(wgHostName string, dns string, peers []v1alpha1.WireguardPeer) string {
+// GenerateIptableIngressRulesFromNetworkPolicies returns the rules of the ingress chain of a peer. Traffic from
+// isolatedSources to the peer is rejected unless a policy accepts it. It returns an empty string if the peer has
+// neither policies nor isolated sources, as all traffic to the peer is allowed then.
+func GenerateIptableIngressRulesFromNetworkPolicies(log logr.Logger, policies v1alpha1.IngressNetworkPolicies, peerIp string, isolatedSources []string) string {
+	return generateIngressRulesFromNetworkPolicies(log, ipv4, policies, peerIp, isolatedSources)
+}
+
+func generateIngressRulesFromNetworkPolicies(log logr.Logger, family ipFamily, policies v1alpha1.IngressNetworkPolicies, peerIp string, isolatedSources []string) string {
+	isolatedSources = filterFamily(family, isolatedSources)
+	if len(policies) == 0 && len(isolatedSources) == 0 {
+		return ""
+	}
+
+	ingressChain := ingressChainName(peerIp)
+
+	rules := []string{
+		// add a comment
+		fmt.Sprintf("# start of ingress rules for peer %s", peerIp),
+
+		// create ingress chain for peer
+		fmt.Sprintf(":%s - [0:0]", ingressChain),
+
+		// associate ingress chain to FORWARD chain
+		fmt.Sprintf("-A FORWARD -d %s -j %s", peerIp, ingressChain),
+
+		// allow replies to the traffic sent by the peer
+		fmt.Sprintf("-A %s -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN", ingressChain),
+	}
+
+	for _, policy := range policies {
+		rules = append(rules, ingressNetworkPolicyToRules(log, family, policy, ingressChain)...)
+	}
+
+	for _, source := range isolatedSources {
+		rules = append(rules, fmt.Sprintf("-A %s -s %s -j REJECT --reject-with %s", ingressChain, source, family.rejectWith))
+	}
+
+	// if policies are defined impose an implicit deny all
+	if len(policies) != 0 {
+		rules = append(rules, fmt.Sprintf("-A %s -j REJECT --reject-with %s", ingressChain, family.rejectWith))
+	}
+
+	// add a comment
+	rules = append(rules, fmt.Sprintf("# end of ingress rules for peer %s", peerIp))
+
+	return strings.Join(rules, "\n")
+}
+
+// maxPeerChainNameLen is the longest name of a peer chain. iptables limits chain names to 28 characters, which leaves
+// room for the suffixes of the ingress and policy chains of the peer.
+const maxPeerChainNameLen = 20
+
+// peerChainName returns the name of the chain holding the rules of the peer with the given address. Addresses too
+// long to fit into a chain name, like most IPv6 addresses, are hashed.
+func peerChainName(peerIp string) string {
+	name := strings.NewReplacer(".", "-", ":", "-").Replace(peerIp)
+	if len(name) <= maxPeerChainNameLen {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(peerIp))
+	return "peer-" + hex.EncodeToString(sum[:])[:maxPeerChainNameLen-len("peer-")]
+}
+
+// ingressChainName returns the name of the chain holding the ingress rules of the peer with the given address.
+func ingressChainName(peerIp string) string {
+	return peerChainName(peerIp) + "-in"
+}
+
+// GenerateIptableRulesFromPeers returns the rules of the nat and filter tables for all peers. If peerIsolation is
+// set, traffic from the tunnel network and the routed subnets to a peer is rejected unless its policies accept it.
+func GenerateIptableRulesFromPeers(log logr.Logger, wgHostName string, dns string, network string, peers []v1alpha1.WireguardPeer, peerIsolation bool) string {
+	return generateRulesFromPeers(log, ipv4, wgHostName, dns, network, peers, peerIsolation)
+}
+
+// GenerateIp6tableRulesFromPeers is the ip6tables equivalent of GenerateIptableRulesFromPeers. Peers without an
+// IPv6 address are skipped.
+func GenerateIp6tableRulesFromPeers(log logr.Logger, wgServerIp string, dns string, network string, peers []v1alpha1.WireguardPeer, peerIsolation bool) string {
+	return generateRulesFromPeers(log, ipv6, wgServerIp, dns, network, peers, peerIsolation)
+}
+
+func generateRulesFromPeers(log logr.Logger, family ipFamily, wgHostName string, dns string, network string, peers []v1alpha1.WireguardPeer, peerIsolation bool) string {
 	var rules []string
 
-	var natTableRules = `
+	var natRules []string
+
+	var isolatedSources []string
+	if peerIsolation {
+		isolatedSources = append(isolatedSources, network)
+		for _, peer := range peers {
+			for _, subnet := range agent.GetRoutedSubnets(peer) {
+				isolatedSources = append(isolatedSources, subnet.String())
+			}
+		}
+	}
+
+	// ingress rules come first so that traffic between peers is subject to the ingress policies of the receiving peer
+	// before the egress policies of the sending peer accept it
+	for _, peer := range peers {
+		peerIp := peer.Spec.Address
+		if family.ipv6 {
+			peerIp = peer.Spec.Ipv6Address
+		}
+
+		if peerIp == "" {
+			continue
+		}
+
+		ingressRules := generateIngressRulesFromNetworkPolicies(log, family, peer.Spec.IngressNetworkPolicies, peerIp, isolatedSources)
+		if ingressRules == "" {
+			continue
+		}
+		rules = append(rules, ingressRules)
+
+		// traffic going to the subnets routed through the peer is subject to the peer ingress rules
+		for _, subnet := range agent.GetRoutedSubnets(peer) {
+			if family.matches(subnet.String()) {
+				rules = append(rules, fmt.Sprintf("-A FORWARD -d %s -j %s", subnet, ingressChainName(peerIp)))
+			}
+		}
+	}
+
+	for _, peer := range peers {
+		peerIp := peer.Spec.Address
+		if family.ipv6 {
+			peerIp = peer.Spec.Ipv6Address
+		}
+
+		if peerIp == "" {
+			continue
+		}
+
+		rules = append(rules, generateRulesFromNetworkPolicies(log, family, peer.Spec.EgressNetworkPolicies, peerIp, dns, wgHostName))
+
+		// traffic coming from the subnets routed through the peer is subject to the peer rules
+		peerChain := peerChainName(peerIp)
+		for _, subnet := range agent.GetRoutedSubnets(peer) {
+			if !family.matches(subnet.String()) {
+				continue
+			}
+			rules = append(rules, fmt.Sprintf("-A FORWARD -s %s -j %s", subnet, peerChain))
+			// the routed subnets keep their addresses, their traffic is not masqueraded
+			natRules = append(natRules, fmt.Sprintf("-A POSTROUTING -s %s -j RETURN", subnet))
+		}
+	}
+
+	natRules = append(natRules, fmt.Sprintf("-A POSTROUTING -s %s -o eth0 -j MASQUERADE", network))
+
+	var natTableRules = fmt.Sprintf(`
 *nat
 :PREROUTING ACCEPT [0:0]
 :INPUT ACCEPT [0:0]
 :OUTPUT ACCEPT [0:0]
 :POSTROUTING ACCEPT [0:0]
--A POSTROUTING -s 10.8.0.0/24 -o eth0 -j MASQUERADE
-COMMIT`
-
-	for _, peer := range peers {
-
-		//tc(peer.Spec.DownloadSpeed, peer.Spec.UploadSpeed)
-		rules = append(rules, GenerateIptableRulesFromNetworkPolicies(peer.Spec.EgressNetworkPolicies, peer.Spec.Address, dns, wgHostName))
-	}
+%s
+COMMIT`, strings.Join(natRules, "\n"))
 
 	var filterTableRules = fmt.Sprintf(`
 *filter
@@ -105,50 +367,200 @@ COMMIT
 	return fmt.Sprintf("%s\n%s", natTableRules, filterTableRules)
 }
 
-func EgressNetworkPolicyToIpTableRules(policy v1alpha1.EgressNetworkPolicy, peerChain string) []string {
+// multiportMaxPorts is the maximum number of ports of a multiport match. Port ranges count as two ports.
+const multiportMaxPorts = 15
 
-	var rules []string
+// policyDirection describes how the network policies of one direction are translated into rules: which address of the
+// packets they match and which target accepts the traffic.
+type policyDirection struct {
+	addressOption string
+	acceptTarget  string
+}
 
-	if policy.Protocol == "" && policy.To.Port != 0 {
-		policy.Protocol = "TCP"
-		rules = append(rules, EgressNetworkPolicyToIpTableRules(policy, peerChain)[0])
-		policy.Protocol = "UDP"
-		rules = append(rules, EgressNetworkPolicyToIpTableRules(policy, peerChain)[0])
-		return rules
+// egress policies match the destination of the traffic sent by a peer.
+var egress = policyDirection{addressOption: "-d", acceptTarget: "ACCEPT"}
+
+// ingress policies match the source of the traffic sent to a peer. Accepted traffic returns to the FORWARD chain, where
+// it is still subject to the egress policies of its sender.
+var ingress = policyDirection{addressOption: "-s", acceptTarget: "RETURN"}
+
+// target returns the target of the rules of a policy with the given action. Policies without action reject the
+// traffic, and so do unknown actions, which the webhook does not admit.
+func (d policyDirection) target(action v1alpha1.EgressNetworkPolicyAction) string {
+	switch strings.ToUpper(string(action)) {
+	case "ACCEPT":
+		return "-j " + d.acceptTarget
+	case "", "REJECT":
+		return "-j REJECT"
+	default:
+		return "-j REJECT"
 	}
+}
+
+func EgressNetworkPolicyToIpTableRules(log logr.Logger, policy v1alpha1.EgressNetworkPolicy, peerChain string) []string {
+	return egressNetworkPolicyToRules(log, ipv4, policy, peerChain, peerChain+"-0")
+}
 
-	// customer rules
-	var rulePeerChain = "-A " + peerChain
-	var ruleAction = string("-j " + v1alpha1.EgressNetworkPolicyActionDeny)
-	var ruleProtocol = ""
-	var ruleDestIp = ""
-	var ruleDestPort = ""
+// egressNetworkPolicyToRules translates policy into rules of peerChain. Destinations of the other family or that do not
+// resolve are skipped, and so is the whole policy if none of its destinations is left. Policies with except CIDRs jump to their
+// own chain policyChain, which returns for the excluded destinations before applying the action.
+func egressNetworkPolicyToRules(log logr.Logger, family ipFamily, policy v1alpha1.EgressNetworkPolicy, peerChain string, policyChain string) []string {
+	return networkPolicyToRules(log, family, egress, policy, peerChain, policyChain)
+}
 
-	if policy.To.Ip != "" {
-		ruleDestIp = "-d " + policy.To.Ip
+// ingressNetworkPolicyToRules translates policy into rules of ingressChain. Sources of the other family are skipped,
+// and so is the whole policy if none of its sources belongs to family.
+func ingressNetworkPolicyToRules(log logr.Logger, family ipFamily, policy v1alpha1.IngressNetworkPolicy, ingressChain string) []string {
+	// ingress policies are egress policies matching the source of the traffic instead of its destination
+	return networkPolicyToRules(log, family, ingress, v1alpha1.EgressNetworkPolicy{
+		Action: policy.Action,
+		To: v1alpha1.EgressNetworkPolicyTo{
+			Ip:      policy.From.Ip,
+			Ips:     policy.From.Ips,
+			Port:    policy.To.Port,
+			EndPort: policy.To.EndPort,
+			Ports:   policy.To.Ports,
+		},
+		Protocol: policy.Protocol,
+		IcmpType: policy.IcmpType,
+	}, ingressChain, "")
+}
+
+func networkPolicyToRules(log logr.Logger, family ipFamily, dir policyDirection, policy v1alpha1.EgressNetworkPolicy, peerChain string, policyChain string) []string {
+	var rules []string
+
+	destinations := family.resolveAll(log, append([]string{policy.To.Ip}, policy.To.Ips...))
+	if len(destinations) == 0 {
+		if policy.To.Ip != "" || len(policy.To.Ips) != 0 {
+			return rules
+		}
+		destinations = []string{""}
 	}
 
-	if policy.Protocol != "" {
-		ruleProtocol = "-p " + strings.ToUpper(string(policy.Protocol))
+	var ruleAction = dir.target(policy.Action)
+
+	var ruleTarget = ruleAction
+	except := filterFamily(family, policy.To.Except)
+	if len(except) != 0 {
+		rules = append(rules, fmt.Sprintf(":%s - [0:0]", policyChain))
+		ruleTarget = "-j " + policyChain
 	}
 
-	if policy.To.Port != 0 {
-		ruleDestPort = "--dport " + fmt.Sprint(policy.To.Port)
+	ports := destinationPorts(policy.To)
+
+	protocols := []v1alpha1.EgressNetworkPolicyProtocol{policy.Protocol}
+	if policy.Protocol == "" && len(ports) != 0 {
+		protocols = []v1alpha1.EgressNetworkPolicyProtocol{v1alpha1.EgressNetworkPolicyProtocolTCP, v1alpha1.EgressNetworkPolicyProtocolUDP}
 	}
 
-	if policy.Action != "" {
-		ruleAction = "-j " + strings.ToUpper(string(policy.Action))
+	for _, destination := range destinations {
+		for _, protocol := range protocols {
+			// customer rules
+			var rulePeerChain = "-A " + peerChain
+			var ruleProtocol = ""
+			var ruleDestIp = ""
+
+			if destination != "" {
+				ruleDestIp = dir.addressOption + " " + destination
+			}
+
+			var ruleMatches []string
+			if protocol == v1alpha1.EgressNetworkPolicyProtocolICMP {
+				ruleProtocol = "-p " + family.icmpProtocol
+				if policy.IcmpType != "" {
+					ruleMatches = append(ruleMatches, family.icmpTypeOption+" "+policy.IcmpType)
+				}
+			} else if protocol != "" {
+				ruleProtocol = "-p " + strings.ToUpper(string(protocol))
+				ruleMatches = portMatches(ports)
+			}
+
+			if len(ruleMatches) == 0 {
+				ruleMatches = []string{""}
+			}
+
+			for _, ruleMatch := range ruleMatches {
+				var options = []string{rulePeerChain, ruleDestIp, ruleProtocol, ruleMatch, ruleTarget}
+				var filteredOptions []string
+				for _, option := range options {
+					if len(option) != 0 {
+						filteredOptions = append(filteredOptions, option)
+					}
+				}
+				rules = append(rules, strings.Join(filteredOptions, " "))
+			}
+		}
 	}
 
-	var options = []string{rulePeerChain, ruleDestIp, ruleProtocol, ruleDestPort, ruleAction}
-	var filteredOptions []string
-	for _, option := range options {
-		if len(option) != 0 {
-			filteredOptions = append(filteredOptions, option)
+	if len(except) != 0 {
+		for _, cidr := range except {
+			rules = append(rules, fmt.Sprintf("-A %s %s %s -j RETURN", policyChain, dir.addressOption, cidr))
 		}
+		rules = append(rules, fmt.Sprintf("-A %s %s", policyChain, ruleAction))
 	}
-	rules = append(rules, strings.Join(filteredOptions, " "))
 
 	return rules
+}
+
+// filterFamily returns the non-empty addresses of the given family.
+func filterFamily(family ipFamily, addresses []string) []string {
+	var filtered []string
+	for _, address := range addresses {
+		if address != "" && family.matches(address) {
+			filtered = append(filtered, address)
+		}
+	}
+
+	return filtered
+}
+
+// destinationPorts returns the destination ports of a policy in iptables syntax. Port ranges are written as first:last.
+func destinationPorts(to v1alpha1.EgressNetworkPolicyTo) []string {
+	var ports []string
+	if to.Port != 0 {
+		if to.EndPort > to.Port {
+			ports = append(ports, fmt.Sprintf("%d:%d", to.Port, to.EndPort))
+		} else {
+			ports = append(ports, fmt.Sprint(to.Port))
+		}
+	}
+
+	for _, port := range to.Ports {
+		ports = append(ports, fmt.Sprint(port))
+	}
+
+	return ports
+}
+
+// portMatches returns the matches for the destination ports. A single port or range uses --dport, several ports use
+// multiport matches, split into several matches if they exceed the multiport limit.
+func portMatches(ports []string) []string {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	if len(ports) == 1 {
+		return []string{"--dport " + ports[0]}
+	}
+
+	var matches []string
+	var group []string
+	size := 0
+	for _, port := range ports {
+		portSize := 1
+		if strings.Contains(port, ":") {
+			portSize = 2
+		}
+
+		if size+portSize > multiportMaxPorts {
+			matches = append(matches, "-m multiport --dports "+strings.Join(group, ","))
+			group = nil
+			size = 0
+		}
+
+		group = append(group, port)
+		size += portSize
+	}
 
+	return append(matches, "-m multiport --dports "+strings.Join(group, ","))
 }
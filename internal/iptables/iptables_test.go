@@ -1,8 +1,11 @@
 package iptables
 
 import (
-	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"net"
 	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
 )
 
 // test helpers
@@ -83,7 +86,7 @@ func TestIptableRules(t *testing.T) {
 -A 10-8-0-11 -d 10.7.0.1 -p icmp -j ACCEPT
 -A 10-8-0-11 -d 10.8.0.11 -j ACCEPT
 -A 10-8-0-11 -d 100.64.0.21 -p UDP --dport 53 -j ACCEPT
--A 10-8-0-11 -j Reject
+-A 10-8-0-11 -j REJECT
 -A 10-8-0-11 -j REJECT --reject-with icmp-port-unreachable
 # end of rules for peer 10.8.0.11`,
 		},
@@ -107,16 +110,428 @@ func TestIptableRules(t *testing.T) {
 -A 10-8-0-9 -j REJECT --reject-with icmp-port-unreachable
 # end of rules for peer 10.8.0.9`,
 		},
+		{
+			name:       "EgressNetworkPolicy with CIDR, except CIDR and port range",
+			peerIp:     "10.8.0.9",
+			kubeDnsIp:  "100.64.0.10",
+			wgServerIp: "10.8.0.1",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{v1alpha1.EgressNetworkPolicy{
+				Protocol: v1alpha1.EgressNetworkPolicyProtocolTCP,
+				Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+				To:       v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.0/8", Except: []string{"10.0.5.0/24"}, Port: 8000, EndPort: 8100},
+			}},
+			expectedIptableRules: `# start of rules for peer 10.8.0.9
+:10-8-0-9 - [0:0]
+-A FORWARD -s 10.8.0.9 -j 10-8-0-9
+-A 10-8-0-9 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-9 -d 10.8.0.9 -j ACCEPT
+-A 10-8-0-9 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+:10-8-0-9-0 - [0:0]
+-A 10-8-0-9 -d 10.0.0.0/8 -p TCP --dport 8000:8100 -j 10-8-0-9-0
+-A 10-8-0-9-0 -d 10.0.5.0/24 -j RETURN
+-A 10-8-0-9-0 -j ACCEPT
+-A 10-8-0-9 -j REJECT --reject-with icmp-port-unreachable
+# end of rules for peer 10.8.0.9`,
+		},
+		{
+			name:       "EgressNetworkPolicy with several destinations and ports without protocol",
+			peerIp:     "10.8.0.9",
+			kubeDnsIp:  "100.64.0.10",
+			wgServerIp: "10.8.0.1",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{v1alpha1.EgressNetworkPolicy{
+				Action: v1alpha1.EgressNetworkPolicyActionAccept,
+				To:     v1alpha1.EgressNetworkPolicyTo{Ips: []string{"192.168.0.0/16", "2001:db8::/32", "172.16.0.1"}, Port: 80, Ports: []int32{443, 8443}},
+			}},
+			expectedIptableRules: `# start of rules for peer 10.8.0.9
+:10-8-0-9 - [0:0]
+-A FORWARD -s 10.8.0.9 -j 10-8-0-9
+-A 10-8-0-9 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-9 -d 10.8.0.9 -j ACCEPT
+-A 10-8-0-9 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+-A 10-8-0-9 -d 192.168.0.0/16 -p TCP -m multiport --dports 80,443,8443 -j ACCEPT
+-A 10-8-0-9 -d 192.168.0.0/16 -p UDP -m multiport --dports 80,443,8443 -j ACCEPT
+-A 10-8-0-9 -d 172.16.0.1 -p TCP -m multiport --dports 80,443,8443 -j ACCEPT
+-A 10-8-0-9 -d 172.16.0.1 -p UDP -m multiport --dports 80,443,8443 -j ACCEPT
+-A 10-8-0-9 -j REJECT --reject-with icmp-port-unreachable
+# end of rules for peer 10.8.0.9`,
+		},
+		{
+			name:       "EgressNetworkPolicy with ICMP type",
+			peerIp:     "10.8.0.9",
+			kubeDnsIp:  "100.64.0.10",
+			wgServerIp: "10.8.0.1",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{v1alpha1.EgressNetworkPolicy{
+				Protocol: v1alpha1.EgressNetworkPolicyProtocolICMP,
+				IcmpType: "echo-request",
+				Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+				To:       v1alpha1.EgressNetworkPolicyTo{Ip: "8.8.8.8"},
+			}},
+			expectedIptableRules: `# start of rules for peer 10.8.0.9
+:10-8-0-9 - [0:0]
+-A FORWARD -s 10.8.0.9 -j 10-8-0-9
+-A 10-8-0-9 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-9 -d 10.8.0.9 -j ACCEPT
+-A 10-8-0-9 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+-A 10-8-0-9 -d 8.8.8.8 -p icmp --icmp-type echo-request -j ACCEPT
+-A 10-8-0-9 -j REJECT --reject-with icmp-port-unreachable
+# end of rules for peer 10.8.0.9`,
+		},
+		{
+			name:       "EgressNetworkPolicy with hostname destinations",
+			peerIp:     "10.8.0.9",
+			kubeDnsIp:  "100.64.0.10",
+			wgServerIp: "vpn.example.com",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{
+				{
+					Action: v1alpha1.EgressNetworkPolicyActionAccept,
+					To:     v1alpha1.EgressNetworkPolicyTo{Ip: "example.com", Ips: []string{"unknown.example.com", "10.0.0.1"}},
+				},
+				{
+					Action: v1alpha1.EgressNetworkPolicyActionAccept,
+					To:     v1alpha1.EgressNetworkPolicyTo{Ip: "unknown.example.com"},
+				},
+				{
+					Action: "Drop",
+					To:     v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.2"},
+				},
+			},
+			expectedIptableRules: `# start of rules for peer 10.8.0.9
+:10-8-0-9 - [0:0]
+-A FORWARD -s 10.8.0.9 -j 10-8-0-9
+-A 10-8-0-9 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-9 -d 10.8.0.9 -j ACCEPT
+-A 10-8-0-9 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+-A 10-8-0-9 -d 93.184.216.34 -j ACCEPT
+-A 10-8-0-9 -d 10.0.0.1 -j ACCEPT
+-A 10-8-0-9 -d 10.0.0.2 -j REJECT
+-A 10-8-0-9 -j REJECT --reject-with icmp-port-unreachable
+# end of rules for peer 10.8.0.9`,
+		},
+	}
+
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800:220:1::")}, nil
+		case "vpn.example.com":
+			return []net.IP{net.ParseIP("10.8.0.1")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
 	}
+	defer func() { lookupIP = net.LookupIP }()
 
 	for _, test := range tests {
 
 		t.Run(test.name, func(t *testing.T) {
 
-			rules := GenerateIptableRulesFromNetworkPolicies(test.networkPolicies, test.peerIp, test.kubeDnsIp, test.wgServerIp)
+			rules := GenerateIptableRulesFromNetworkPolicies(logr.Discard(), test.networkPolicies, test.peerIp, test.kubeDnsIp, test.wgServerIp)
 			if rules != test.expectedIptableRules {
 				t.Errorf("got %s, want %s", rules, test.expectedIptableRules)
 			}
 		})
 	}
 }
+
+func TestIp6tableRules(t *testing.T) {
+	tests := []struct {
+		name                  string
+		peerIp                string
+		kubeDnsIp             string
+		wgServerIp            string
+		networkPolicies       v1alpha1.EgressNetworkPolicies
+		expectedIp6tableRules string
+	}{
+		{
+			name:       "EgressNetworkPolicy with IPv4 and IPv6 destinations",
+			peerIp:     "fd00:8::2",
+			kubeDnsIp:  "100.64.0.10",
+			wgServerIp: "fd00:8::1",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{
+				v1alpha1.EgressNetworkPolicy{
+					Action: v1alpha1.EgressNetworkPolicyActionAccept,
+					To:     v1alpha1.EgressNetworkPolicyTo{Ip: "8.8.8.8"}},
+				v1alpha1.EgressNetworkPolicy{
+					Action: v1alpha1.EgressNetworkPolicyActionAccept,
+					To:     v1alpha1.EgressNetworkPolicyTo{Ip: "2001:4860:4860::8888"}},
+			},
+			expectedIp6tableRules: `# start of rules for peer fd00:8::2
+:fd00-8--2 - [0:0]
+-A FORWARD -s fd00:8::2 -j fd00-8--2
+-A fd00-8--2 -d fd00:8::1 -p ipv6-icmp -j ACCEPT
+-A fd00-8--2 -d fd00:8::2 -j ACCEPT
+-A fd00-8--2 -d 2001:4860:4860::8888 -j ACCEPT
+-A fd00-8--2 -j REJECT --reject-with icmp6-port-unreachable
+# end of rules for peer fd00:8::2`,
+		},
+		{
+			name:            "Empty networkPolicies with IPv6 kube-dns",
+			peerIp:          "fd00:8::3",
+			kubeDnsIp:       "fd00:10::a",
+			wgServerIp:      "fd00:8::1",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{},
+			expectedIp6tableRules: `# start of rules for peer fd00:8::3
+:fd00-8--3 - [0:0]
+-A FORWARD -s fd00:8::3 -j fd00-8--3
+-A fd00-8--3 -d fd00:8::1 -p ipv6-icmp -j ACCEPT
+-A fd00-8--3 -d fd00:8::3 -j ACCEPT
+-A fd00-8--3 -d fd00:10::a -p UDP --dport 53 -j ACCEPT
+# end of rules for peer fd00:8::3`,
+		},
+		{
+			name:       "EgressNetworkPolicy with ICMP type and IPv6 except CIDR",
+			peerIp:     "fd00:8::2",
+			kubeDnsIp:  "100.64.0.10",
+			wgServerIp: "fd00:8::1",
+			networkPolicies: v1alpha1.EgressNetworkPolicies{v1alpha1.EgressNetworkPolicy{
+				Protocol: v1alpha1.EgressNetworkPolicyProtocolICMP,
+				IcmpType: "echo-request",
+				Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+				To:       v1alpha1.EgressNetworkPolicyTo{Ips: []string{"10.0.0.0/8", "2001:db8::/32"}, Except: []string{"10.0.5.0/24", "2001:db8:5::/48"}},
+			}},
+			expectedIp6tableRules: `# start of rules for peer fd00:8::2
+:fd00-8--2 - [0:0]
+-A FORWARD -s fd00:8::2 -j fd00-8--2
+-A fd00-8--2 -d fd00:8::1 -p ipv6-icmp -j ACCEPT
+-A fd00-8--2 -d fd00:8::2 -j ACCEPT
+:fd00-8--2-0 - [0:0]
+-A fd00-8--2 -d 2001:db8::/32 -p ipv6-icmp --icmpv6-type echo-request -j fd00-8--2-0
+-A fd00-8--2-0 -d 2001:db8:5::/48 -j RETURN
+-A fd00-8--2-0 -j ACCEPT
+-A fd00-8--2 -j REJECT --reject-with icmp6-port-unreachable
+# end of rules for peer fd00:8::2`,
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			rules := GenerateIp6tableRulesFromNetworkPolicies(logr.Discard(), test.networkPolicies, test.peerIp, test.kubeDnsIp, test.wgServerIp)
+			if rules != test.expectedIp6tableRules {
+				t.Errorf("got %s, want %s", rules, test.expectedIp6tableRules)
+			}
+		})
+	}
+}
+
+func TestPeerChainName(t *testing.T) {
+	tests := []struct {
+		name   string
+		peerIp string
+		want   string
+	}{
+		{name: "IPv4 address", peerIp: "255.255.255.255", want: "255-255-255-255"},
+		{name: "short IPv6 address", peerIp: "fd00:8::2", want: "fd00-8--2"},
+		{name: "long IPv6 address", peerIp: "2001:db8:1234:5678:9abc:def0:1234:5678", want: "peer-ac9f0678574e567"},
+		{name: "other long IPv6 address", peerIp: "2001:db8:1234:5678:9abc:def0:1234:5679", want: "peer-4a99c34d9117fb4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := peerChainName(test.peerIp)
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+
+			// iptables rejects chain names longer than 28 characters
+			if ingressChain := ingressChainName(test.peerIp); len(ingressChain) > 28 {
+				t.Errorf("got ingress chain %s of %d characters, want at most 28", ingressChain, len(ingressChain))
+			}
+		})
+	}
+}
+
+func TestIptableRulesFromPeersWithRoutedSubnets(t *testing.T) {
+	peers := []v1alpha1.WireguardPeer{
+		{
+			Spec: v1alpha1.WireguardPeerSpec{
+				Address:       "10.8.0.2",
+				RoutedSubnets: []string{"192.168.10.0/24", "fd00:10::/64"},
+			},
+		},
+	}
+
+	expectedRules := `
+*nat
+:PREROUTING ACCEPT [0:0]
+:INPUT ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+:POSTROUTING ACCEPT [0:0]
+-A POSTROUTING -s 192.168.10.0/24 -j RETURN
+-A POSTROUTING -s 10.8.0.0/24 -o eth0 -j MASQUERADE
+COMMIT
+
+*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+# start of rules for peer 10.8.0.2
+:10-8-0-2 - [0:0]
+-A FORWARD -s 10.8.0.2 -j 10-8-0-2
+-A 10-8-0-2 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-2 -d 10.8.0.2 -j ACCEPT
+-A 10-8-0-2 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+# end of rules for peer 10.8.0.2
+-A FORWARD -s 192.168.10.0/24 -j 10-8-0-2
+COMMIT
+`
+
+	rules := GenerateIptableRulesFromPeers(logr.Discard(), "10.8.0.1", "100.64.0.10", "10.8.0.0/24", peers, false)
+	if rules != expectedRules {
+		t.Errorf("got %s, want %s", rules, expectedRules)
+	}
+}
+
+func TestIptableIngressRules(t *testing.T) {
+	tests := []struct {
+		name                 string
+		peerIp               string
+		isolatedSources      []string
+		networkPolicies      v1alpha1.IngressNetworkPolicies
+		expectedIptableRules string
+	}{
+		{
+			name:                 "No IngressNetworkPolicies without isolation",
+			peerIp:               "10.8.0.2",
+			networkPolicies:      v1alpha1.IngressNetworkPolicies{},
+			expectedIptableRules: "",
+		},
+		{
+			name:   "IngressNetworkPolicy with source CIDR and destination port",
+			peerIp: "10.8.0.2",
+			networkPolicies: v1alpha1.IngressNetworkPolicies{
+				v1alpha1.IngressNetworkPolicy{
+					Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+					Protocol: v1alpha1.EgressNetworkPolicyProtocolTCP,
+					From:     v1alpha1.IngressNetworkPolicyFrom{Ip: "10.244.0.0/16"},
+					To:       v1alpha1.IngressNetworkPolicyTo{Port: 8080},
+				},
+			},
+			expectedIptableRules: `# start of ingress rules for peer 10.8.0.2
+:10-8-0-2-in - [0:0]
+-A FORWARD -d 10.8.0.2 -j 10-8-0-2-in
+-A 10-8-0-2-in -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN
+-A 10-8-0-2-in -s 10.244.0.0/16 -p TCP --dport 8080 -j RETURN
+-A 10-8-0-2-in -j REJECT --reject-with icmp-port-unreachable
+# end of ingress rules for peer 10.8.0.2`,
+		},
+		{
+			name:            "Peer isolation with IngressNetworkPolicies accepting a peer",
+			peerIp:          "10.8.0.2",
+			isolatedSources: []string{"10.8.0.0/24", "fd00:8::/64"},
+			networkPolicies: v1alpha1.IngressNetworkPolicies{
+				v1alpha1.IngressNetworkPolicy{
+					Action: v1alpha1.EgressNetworkPolicyActionAccept,
+					From:   v1alpha1.IngressNetworkPolicyFrom{Ips: []string{"10.8.0.3", "fd00:8::3"}},
+					To:     v1alpha1.IngressNetworkPolicyTo{Ports: []int32{22, 443}},
+				},
+				v1alpha1.IngressNetworkPolicy{
+					Action:   v1alpha1.EgressNetworkPolicyActionDeny,
+					Protocol: v1alpha1.EgressNetworkPolicyProtocolICMP,
+					IcmpType: "echo-request",
+				},
+			},
+			expectedIptableRules: `# start of ingress rules for peer 10.8.0.2
+:10-8-0-2-in - [0:0]
+-A FORWARD -d 10.8.0.2 -j 10-8-0-2-in
+-A 10-8-0-2-in -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN
+-A 10-8-0-2-in -s 10.8.0.3 -p TCP -m multiport --dports 22,443 -j RETURN
+-A 10-8-0-2-in -s 10.8.0.3 -p UDP -m multiport --dports 22,443 -j RETURN
+-A 10-8-0-2-in -p icmp --icmp-type echo-request -j REJECT
+-A 10-8-0-2-in -s 10.8.0.0/24 -j REJECT --reject-with icmp-port-unreachable
+-A 10-8-0-2-in -j REJECT --reject-with icmp-port-unreachable
+# end of ingress rules for peer 10.8.0.2`,
+		},
+		{
+			name:            "Peer isolation without IngressNetworkPolicies",
+			peerIp:          "10.8.0.2",
+			isolatedSources: []string{"10.8.0.0/24", "192.168.10.0/24"},
+			expectedIptableRules: `# start of ingress rules for peer 10.8.0.2
+:10-8-0-2-in - [0:0]
+-A FORWARD -d 10.8.0.2 -j 10-8-0-2-in
+-A 10-8-0-2-in -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN
+-A 10-8-0-2-in -s 10.8.0.0/24 -j REJECT --reject-with icmp-port-unreachable
+-A 10-8-0-2-in -s 192.168.10.0/24 -j REJECT --reject-with icmp-port-unreachable
+# end of ingress rules for peer 10.8.0.2`,
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			rules := GenerateIptableIngressRulesFromNetworkPolicies(logr.Discard(), test.networkPolicies, test.peerIp, test.isolatedSources)
+			if rules != test.expectedIptableRules {
+				t.Errorf("got %s, want %s", rules, test.expectedIptableRules)
+			}
+		})
+	}
+}
+
+func TestIptableRulesFromPeersWithPeerIsolation(t *testing.T) {
+	peers := []v1alpha1.WireguardPeer{
+		{
+			Spec: v1alpha1.WireguardPeerSpec{
+				Address: "10.8.0.2",
+			},
+		},
+		{
+			Spec: v1alpha1.WireguardPeerSpec{
+				Address:       "10.8.0.3",
+				RoutedSubnets: []string{"192.168.10.0/24"},
+			},
+		},
+	}
+
+	expectedRules := `
+*nat
+:PREROUTING ACCEPT [0:0]
+:INPUT ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+:POSTROUTING ACCEPT [0:0]
+-A POSTROUTING -s 192.168.10.0/24 -j RETURN
+-A POSTROUTING -s 10.8.0.0/24 -o eth0 -j MASQUERADE
+COMMIT
+
+*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+# start of ingress rules for peer 10.8.0.2
+:10-8-0-2-in - [0:0]
+-A FORWARD -d 10.8.0.2 -j 10-8-0-2-in
+-A 10-8-0-2-in -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN
+-A 10-8-0-2-in -s 10.8.0.0/24 -j REJECT --reject-with icmp-port-unreachable
+-A 10-8-0-2-in -s 192.168.10.0/24 -j REJECT --reject-with icmp-port-unreachable
+# end of ingress rules for peer 10.8.0.2
+# start of ingress rules for peer 10.8.0.3
+:10-8-0-3-in - [0:0]
+-A FORWARD -d 10.8.0.3 -j 10-8-0-3-in
+-A 10-8-0-3-in -m conntrack --ctstate RELATED,ESTABLISHED -j RETURN
+-A 10-8-0-3-in -s 10.8.0.0/24 -j REJECT --reject-with icmp-port-unreachable
+-A 10-8-0-3-in -s 192.168.10.0/24 -j REJECT --reject-with icmp-port-unreachable
+# end of ingress rules for peer 10.8.0.3
+-A FORWARD -d 192.168.10.0/24 -j 10-8-0-3-in
+# start of rules for peer 10.8.0.2
+:10-8-0-2 - [0:0]
+-A FORWARD -s 10.8.0.2 -j 10-8-0-2
+-A 10-8-0-2 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-2 -d 10.8.0.2 -j ACCEPT
+-A 10-8-0-2 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+# end of rules for peer 10.8.0.2
+# start of rules for peer 10.8.0.3
+:10-8-0-3 - [0:0]
+-A FORWARD -s 10.8.0.3 -j 10-8-0-3
+-A 10-8-0-3 -d 10.8.0.1 -p icmp -j ACCEPT
+-A 10-8-0-3 -d 10.8.0.3 -j ACCEPT
+-A 10-8-0-3 -d 100.64.0.10 -p UDP --dport 53 -j ACCEPT
+# end of rules for peer 10.8.0.3
+-A FORWARD -s 192.168.10.0/24 -j 10-8-0-3
+COMMIT
+`
+
+	rules := GenerateIptableRulesFromPeers(logr.Discard(), "10.8.0.1", "100.64.0.10", "10.8.0.0/24", peers, true)
+	if rules != expectedRules {
+		t.Errorf("got %s, want %s", rules, expectedRules)
+	}
+}
@@ -50,6 +50,8 @@ const (
 	testClusterName     = "wg-kind-test"
 	testKindContextName = "kind-" + testClusterName
 	TestNamespace       = "default"
+	// certManagerRelease issues the serving certificate of the webhooks of the operator
+	certManagerRelease = "https://github.com/cert-manager/cert-manager/releases/download/v1.13.3/cert-manager.yaml"
 )
 
 func waitForDeploymentTobeReady(name string, namespace string) {
@@ -186,6 +188,21 @@ var _ = BeforeSuite(func() {
 		return
 	}
 
+	// the webhooks of the operator depend on cert-manager
+	if _, err := exec.
+		Command("kubectl", "apply", "-f", certManagerRelease, "--context", testKindContextName).
+		Output(); err != nil {
+		log.Error(err, "unable to apply cert-manager release")
+		return
+	}
+
+	if _, err := exec.
+		Command("kubectl", "wait", "deployment", "--all", "-n", "cert-manager", "--for=condition=Available", "--timeout=120s", "--context", testKindContextName).
+		Output(); err != nil {
+		log.Error(err, "cert-manager did not become available")
+		return
+	}
+
 	// simulate what users exactly do in real life.
 	b, err := exec.
 		Command("kubectl", "apply", "-f", releasePath, "--context", testKindContextName).
@@ -197,6 +214,7 @@ var _ = BeforeSuite(func() {
 
 	expectedResources := []string{
 		"namespace/wireguard-system",
+		"customresourcedefinition.apiextensions.k8s.io/wireguardnetworkpolicies.vpn.wireguard-operator.io",
 		"customresourcedefinition.apiextensions.k8s.io/wireguardpeers.vpn.wireguard-operator.io",
 		"customresourcedefinition.apiextensions.k8s.io/wireguards.vpn.wireguard-operator.io",
 		"serviceaccount/wireguard-controller-manager",
@@ -209,7 +227,12 @@ var _ = BeforeSuite(func() {
 		"clusterrolebinding.rbac.authorization.k8s.io/wireguard-proxy-rolebinding",
 		"configmap/wireguard-manager-config",
 		"service/wireguard-controller-manager-metrics-service",
+		"service/wireguard-webhook-service",
 		"deployment.apps/wireguard-controller-manager",
+		"certificate.cert-manager.io/wireguard-serving-cert",
+		"issuer.cert-manager.io/wireguard-selfsigned-issuer",
+		"mutatingwebhookconfiguration.admissionregistration.k8s.io/wireguard-mutating-webhook-configuration",
+		"validatingwebhookconfiguration.admissionregistration.k8s.io/wireguard-validating-webhook-configuration",
 	}
 
 	Expect(strings.Split(strings.Trim(strings.ReplaceAll(string(b), " created", ""), "\n"), "\n")).To(BeEquivalentTo(expectedResources))
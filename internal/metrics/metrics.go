@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// components of the agent whose syncs are observed
+const (
+	ComponentWireguard      = "wireguard"
+	ComponentFirewall       = "firewall"
+	ComponentTrafficShaping = "traffic_shaping"
+)
+
+// now returns the current time. It is a variable to be replaced in tests.
+var now = time.Now
+
+var peerLabels = []string{"name", "namespace"}
+
+var (
+	peerReceiveBytesDesc  = prometheus.NewDesc("wireguard_peer_receive_bytes_total", "Bytes received from the peer.", peerLabels, nil)
+	peerTransmitBytesDesc = prometheus.NewDesc("wireguard_peer_transmit_bytes_total", "Bytes sent to the peer.", peerLabels, nil)
+	peerHandshakeAgeDesc  = prometheus.NewDesc("wireguard_peer_last_handshake_age_seconds", "Seconds since the last handshake of the peer. Missing if the peer never completed a handshake.", peerLabels, nil)
+	peerConnectedDesc     = prometheus.NewDesc("wireguard_peer_connected", "Whether the peer completed a handshake within the last 180 seconds.", peerLabels, nil)
+)
+
+// Agent holds the metrics of the agent about its own operation.
+type Agent struct {
+	SyncDuration          *prometheus.HistogramVec
+	SyncErrors            *prometheus.CounterVec
+	FirewallApplyFailures *prometheus.CounterVec
+	StateChanges          prometheus.Counter
+}
+
+func NewAgent() *Agent {
+	return &Agent{
+		SyncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "wireguard_agent_sync_duration_seconds",
+			Help: "Duration of the syncs of the components of the agent.",
+		}, []string{"component"}),
+		SyncErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wireguard_agent_sync_errors_total",
+			Help: "Number of failed syncs of the components of the agent.",
+		}, []string{"component"}),
+		FirewallApplyFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wireguard_agent_firewall_apply_failures_total",
+			Help: "Number of times the firewall rules could not be applied.",
+		}, []string{"backend"}),
+		StateChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wireguard_agent_state_changes_total",
+			Help: "Number of times the agent received a new state.",
+		}),
+	}
+}
+
+// Register registers the metrics with registry.
+func (a *Agent) Register(registry prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{a.SyncDuration, a.SyncErrors, a.FirewallApplyFailures, a.StateChanges} {
+		if err := registry.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ObserveSync runs sync, recording its duration and failure as a sync of component.
+func (a *Agent) ObserveSync(component string, sync func() error) error {
+	start := time.Now()
+	err := sync()
+
+	a.SyncDuration.WithLabelValues(component).Observe(time.Since(start).Seconds())
+	if err != nil {
+		a.SyncErrors.WithLabelValues(component).Inc()
+	}
+
+	return err
+}
+
+// PeerCollector collects the stats of the peers of the wg device, labelled by the name and namespace of their
+// WireguardPeer. Peers missing from the last state set are skipped.
+type PeerCollector struct {
+	// Stats returns the stats of the peers of the wg device
+	Stats func() ([]agent.PeerStats, error)
+
+	mutex sync.Mutex
+	peers map[string]v1alpha1.WireguardPeer
+}
+
+// SetState sets the state the peers are looked up in.
+func (c *PeerCollector) SetState(state agent.State) {
+	peers := make(map[string]v1alpha1.WireguardPeer)
+	for _, peer := range state.Peers {
+		peers[peer.Spec.PublicKey] = peer
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.peers = peers
+}
+
+func (c *PeerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peerReceiveBytesDesc
+	ch <- peerTransmitBytesDesc
+	ch <- peerHandshakeAgeDesc
+	ch <- peerConnectedDesc
+}
+
+func (c *PeerCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(peerConnectedDesc, err)
+		return
+	}
+
+	c.mutex.Lock()
+	peers := c.peers
+	c.mutex.Unlock()
+
+	t := now()
+	for _, peerStats := range stats {
+		peer, ok := peers[peerStats.PublicKey]
+		if !ok {
+			continue
+		}
+
+		labels := []string{peer.Name, peer.Namespace}
+
+		ch <- prometheus.MustNewConstMetric(peerReceiveBytesDesc, prometheus.CounterValue, float64(peerStats.ReceiveBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(peerTransmitBytesDesc, prometheus.CounterValue, float64(peerStats.TransmitBytes), labels...)
+
+		if !peerStats.LastHandshakeTime.IsZero() {
+			ch <- prometheus.MustNewConstMetric(peerHandshakeAgeDesc, prometheus.GaugeValue, t.Sub(peerStats.LastHandshakeTime).Seconds(), labels...)
+		}
+
+		connected := 0.0
+		if peerStats.Connected(t) {
+			connected = 1
+		}
+		ch <- prometheus.MustNewConstMetric(peerConnectedDesc, prometheus.GaugeValue, connected, labels...)
+	}
+}
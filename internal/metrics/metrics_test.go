@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPeerCollector(t *testing.T) {
+	collectionTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return collectionTime }
+	defer func() { now = time.Now }()
+
+	collector := &PeerCollector{Stats: func() ([]agent.PeerStats, error) {
+		return []agent.PeerStats{
+			{PublicKey: "peer1-key", LastHandshakeTime: collectionTime.Add(-30 * time.Second), ReceiveBytes: 100, TransmitBytes: 200},
+			{PublicKey: "peer2-key", ReceiveBytes: 0, TransmitBytes: 0},
+			{PublicKey: "removed-key", ReceiveBytes: 1, TransmitBytes: 1},
+		}, nil
+	}}
+
+	collector.SetState(agent.State{Peers: []v1alpha1.WireguardPeer{
+		{ObjectMeta: metav1.ObjectMeta{Name: "peer1", Namespace: "vpn"}, Spec: v1alpha1.WireguardPeerSpec{PublicKey: "peer1-key"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "peer2", Namespace: "vpn"}, Spec: v1alpha1.WireguardPeerSpec{PublicKey: "peer2-key"}},
+	}})
+
+	expected := `
+# HELP wireguard_peer_connected Whether the peer completed a handshake within the last 180 seconds.
+# TYPE wireguard_peer_connected gauge
+wireguard_peer_connected{name="peer1",namespace="vpn"} 1
+wireguard_peer_connected{name="peer2",namespace="vpn"} 0
+# HELP wireguard_peer_last_handshake_age_seconds Seconds since the last handshake of the peer. Missing if the peer never completed a handshake.
+# TYPE wireguard_peer_last_handshake_age_seconds gauge
+wireguard_peer_last_handshake_age_seconds{name="peer1",namespace="vpn"} 30
+# HELP wireguard_peer_receive_bytes_total Bytes received from the peer.
+# TYPE wireguard_peer_receive_bytes_total counter
+wireguard_peer_receive_bytes_total{name="peer1",namespace="vpn"} 100
+wireguard_peer_receive_bytes_total{name="peer2",namespace="vpn"} 0
+# HELP wireguard_peer_transmit_bytes_total Bytes sent to the peer.
+# TYPE wireguard_peer_transmit_bytes_total counter
+wireguard_peer_transmit_bytes_total{name="peer1",namespace="vpn"} 200
+wireguard_peer_transmit_bytes_total{name="peer2",namespace="vpn"} 0
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAgentObserveSync(t *testing.T) {
+	metrics := NewAgent()
+
+	//nolint:errcheck
+	metrics.ObserveSync(ComponentFirewall, func() error { return nil })
+	if err := metrics.ObserveSync(ComponentFirewall, func() error { return errors.New("sync failed") }); err == nil {
+		t.Error("expected the error of the sync to be returned")
+	}
+
+	if count := testutil.CollectAndCount(metrics.SyncDuration); count != 1 {
+		t.Errorf("got %d sync duration series, want 1", count)
+	}
+
+	if errors := testutil.ToFloat64(metrics.SyncErrors.WithLabelValues(ComponentFirewall)); errors != 1 {
+		t.Errorf("got %v sync errors, want 1", errors)
+	}
+}
@@ -0,0 +1,223 @@
+package nftables
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// apply replaces the table of the agent with ruleset in a single transaction.
+func apply(conn *nftables.Conn, ruleset Ruleset) error {
+	table := &nftables.Table{Family: nftables.TableFamilyINet, Name: TableName}
+
+	// adding the table before deleting it makes sure the deletion does not fail if the table does not exist yet
+	conn.AddTable(table)
+	conn.DelTable(table)
+	conn.AddTable(table)
+
+	accept := nftables.ChainPolicyAccept
+	forward := conn.AddChain(&nftables.Chain{
+		Name:     "forward",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &accept,
+	})
+
+	postrouting := conn.AddChain(&nftables.Chain{
+		Name:     "postrouting",
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+
+	// chains have to exist before rules can jump to them
+	chains := make(map[string]*nftables.Chain)
+	for _, chain := range ruleset.Chains {
+		chains[chain.Name] = conn.AddChain(&nftables.Chain{Name: chain.Name, Table: table})
+	}
+
+	addRules := func(chain *nftables.Chain, rules []Rule) error {
+		for _, rule := range rules {
+			exprs, err := rule.Exprs()
+			if err != nil {
+				return fmt.Errorf("invalid rule %q in chain %s: %w", rule, chain.Name, err)
+			}
+			conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: exprs})
+		}
+		return nil
+	}
+
+	if err := addRules(forward, ruleset.Forward); err != nil {
+		return err
+	}
+
+	if err := addRules(postrouting, ruleset.Postrouting); err != nil {
+		return err
+	}
+
+	for _, chain := range ruleset.Chains {
+		if err := addRules(chains[chain.Name], chain.Rules); err != nil {
+			return err
+		}
+	}
+
+	return conn.Flush()
+}
+
+// Exprs returns the nftables expressions of the rule.
+func (r Rule) Exprs() ([]expr.Any, error) {
+	var exprs []expr.Any
+
+	if r.CtState != "" {
+		var state uint32
+		for _, name := range strings.Split(r.CtState, ",") {
+			bit, ok := map[string]uint32{"established": expr.CtStateBitESTABLISHED, "related": expr.CtStateBitRELATED, "new": expr.CtStateBitNEW, "invalid": expr.CtStateBitINVALID}[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported conntrack state %s", name)
+			}
+			state |= bit
+		}
+
+		exprs = append(exprs,
+			&expr.Ct{Key: expr.CtKeySTATE, Register: 1},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: binaryutil.NativeEndian.PutUint32(state), Xor: make([]byte, 4)},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: make([]byte, 4)},
+		)
+	}
+
+	for _, match := range []struct {
+		address string
+		source  bool
+	}{{r.Saddr, true}, {r.Daddr, false}} {
+		if match.address == "" {
+			continue
+		}
+
+		addressExprs, err := addressMatch(match.address, match.source)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, addressExprs...)
+	}
+
+	if r.Oifname != "" {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(r.Oifname)},
+		)
+	}
+
+	if r.Protocol != "" {
+		protocol, ok := map[string]byte{"tcp": unix.IPPROTO_TCP, "udp": unix.IPPROTO_UDP, "icmp": unix.IPPROTO_ICMP, "icmpv6": unix.IPPROTO_ICMPV6}[r.Protocol]
+		if !ok {
+			return nil, fmt.Errorf("unsupported protocol %s", r.Protocol)
+		}
+
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{protocol}},
+		)
+	}
+
+	if r.Dport != nil {
+		// destination port of TCP and UDP headers
+		exprs = append(exprs, &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2})
+		if r.Dport.From == r.Dport.To {
+			exprs = append(exprs, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(r.Dport.From)})
+		} else {
+			exprs = append(exprs, &expr.Range{Op: expr.CmpOpEq, Register: 1, FromData: binaryutil.BigEndian.PutUint16(r.Dport.From), ToData: binaryutil.BigEndian.PutUint16(r.Dport.To)})
+		}
+	}
+
+	if r.IcmpType != nil {
+		// type of ICMP and ICMPv6 headers
+		exprs = append(exprs,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{*r.IcmpType}},
+		)
+	}
+
+	switch r.Verdict {
+	case VerdictAccept:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictAccept})
+	case VerdictReturn:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictReturn})
+	case VerdictJump:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictJump, Chain: r.Chain})
+	case VerdictReject:
+		exprs = append(exprs, &expr.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: unix.NFT_REJECT_ICMPX_PORT_UNREACH})
+	case VerdictMasquerade:
+		exprs = append(exprs, &expr.Masq{})
+	default:
+		return nil, fmt.Errorf("unsupported verdict %s", r.Verdict)
+	}
+
+	return exprs, nil
+}
+
+// addressMatch returns the expressions matching the source or destination address of a packet against an IP address
+// or CIDR. The network header is only loaded after matching the family, as the table handles IPv4 and IPv6.
+func addressMatch(address string, source bool) ([]expr.Any, error) {
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %s", address)
+		}
+
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			bits = net.IPv4len * 8
+		}
+		network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+
+	nfproto := byte(unix.NFPROTO_IPV6)
+	ip := network.IP.To16()
+	offset := uint32(24)
+	if source {
+		offset = 8
+	}
+
+	if ip4 := network.IP.To4(); ip4 != nil {
+		nfproto = unix.NFPROTO_IPV4
+		ip = ip4
+		offset = 16
+		if source {
+			offset = 12
+		}
+	}
+
+	exprs := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{nfproto}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: uint32(len(ip))},
+	}
+
+	if ones, bits := network.Mask.Size(); ones != bits {
+		exprs = append(exprs, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            uint32(len(ip)),
+			Mask:           network.Mask,
+			Xor:            make([]byte, len(ip)),
+		})
+	}
+
+	return append(exprs, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip.Mask(network.Mask)}), nil
+}
+
+// ifname returns the interface name padded the way the kernel compares it.
+func ifname(name string) []byte {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
@@ -0,0 +1,591 @@
+package nftables
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/google/nftables"
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TableName is the name of the inet table holding all the rules of the agent
+const TableName = "wireguard-operator"
+
+const (
+	VerdictAccept     = "accept"
+	VerdictReject     = "reject"
+	VerdictJump       = "jump"
+	VerdictReturn     = "return"
+	VerdictMasquerade = "masquerade"
+)
+
+// lookupIP resolves hostnames used as destinations. It is a variable to be replaced in tests.
+var lookupIP = net.LookupIP
+
+type ipFamily struct {
+	icmpProtocol string
+	icmpTypes    map[string]uint8
+	ipv6         bool
+}
+
+var ipv4 = ipFamily{icmpProtocol: "icmp", icmpTypes: map[string]uint8{
+	"echo-reply":              0,
+	"destination-unreachable": 3,
+	"redirect":                5,
+	"echo-request":            8,
+	"router-advertisement":    9,
+	"router-solicitation":     10,
+	"time-exceeded":           11,
+	"parameter-problem":       12,
+	"timestamp-request":       13,
+	"timestamp-reply":         14,
+}}
+
+var ipv6 = ipFamily{icmpProtocol: "icmpv6", ipv6: true, icmpTypes: map[string]uint8{
+	"destination-unreachable": 1,
+	"packet-too-big":          2,
+	"time-exceeded":           3,
+	"parameter-problem":       4,
+	"echo-request":            128,
+	"echo-reply":              129,
+	"router-solicitation":     133,
+	"router-advertisement":    134,
+	"neighbour-solicitation":  135,
+	"neighbour-advertisement": 136,
+	"redirect":                137,
+}}
+
+// matches returns false if address is an IP address of the other family. Hostnames match both families.
+func (f ipFamily) matches(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		if _, ipnet, err := net.ParseCIDR(address); err == nil {
+			ip = ipnet.IP
+		}
+	}
+
+	if ip == nil {
+		return true
+	}
+
+	return (ip.To4() == nil) == f.ipv6
+}
+
+// resolveAll returns the IP addresses or CIDRs of the family for addresses. Hostnames are resolved, and those that do
+// not resolve are logged and skipped, so that they only leave out the rules matching them.
+func (f ipFamily) resolveAll(log logr.Logger, addresses []string) []string {
+	var resolved []string
+	for _, address := range addresses {
+		if address == "" || !f.matches(address) {
+			continue
+		}
+
+		ips, err := f.resolve(address)
+		if err != nil {
+			log.Error(err, "Skipping the rules of an address that does not resolve", "address", address)
+			continue
+		}
+		resolved = append(resolved, ips...)
+	}
+
+	return resolved
+}
+
+// resolve returns the IP addresses or CIDRs of the family for address. Hostnames are resolved.
+func (f ipFamily) resolve(address string) ([]string, error) {
+	if net.ParseIP(address) != nil {
+		return []string{address}, nil
+	}
+
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return []string{address}, nil
+	}
+
+	ips, err := lookupIP(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, ip := range ips {
+		if f.matches(ip.String()) {
+			addresses = append(addresses, ip.String())
+		}
+	}
+
+	return addresses, nil
+}
+
+// icmpType returns the ICMP type with the given name or number.
+func (f ipFamily) icmpType(name string) (uint8, error) {
+	if icmpType, ok := f.icmpTypes[name]; ok {
+		return icmpType, nil
+	}
+
+	icmpType, err := strconv.ParseUint(name, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown %s type %s", f.icmpProtocol, name)
+	}
+
+	return uint8(icmpType), nil
+}
+
+// PortRange is an inclusive range of ports. Single ports have From equal to To.
+type PortRange struct {
+	From uint16
+	To   uint16
+}
+
+// Rule is a rule of the nftables ruleset. Empty fields match any packet.
+type Rule struct {
+	// CtState is a comma separated list of conntrack states, e.g. established,related
+	CtState  string
+	Saddr    string
+	Daddr    string
+	Oifname  string
+	Protocol string
+	Dport    *PortRange
+	IcmpType *uint8
+	Verdict  string
+	// Chain is the chain to jump to for VerdictJump
+	Chain string
+}
+
+// String returns the rule in nft syntax.
+func (r Rule) String() string {
+	var parts []string
+
+	if r.CtState != "" {
+		parts = append(parts, "ct state "+r.CtState)
+	}
+
+	for _, match := range []struct{ field, address string }{{"saddr", r.Saddr}, {"daddr", r.Daddr}} {
+		if match.address == "" {
+			continue
+		}
+		family := "ip"
+		if !ipv4.matches(match.address) {
+			family = "ip6"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", family, match.field, match.address))
+	}
+
+	if r.Oifname != "" {
+		parts = append(parts, fmt.Sprintf("oifname %q", r.Oifname))
+	}
+
+	if r.Dport != nil {
+		if r.Dport.From == r.Dport.To {
+			parts = append(parts, fmt.Sprintf("%s dport %d", r.Protocol, r.Dport.From))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s dport %d-%d", r.Protocol, r.Dport.From, r.Dport.To))
+		}
+	} else if r.IcmpType != nil {
+		parts = append(parts, fmt.Sprintf("%s type %d", r.Protocol, *r.IcmpType))
+	} else if r.Protocol != "" {
+		parts = append(parts, "meta l4proto "+r.Protocol)
+	}
+
+	verdict := r.Verdict
+	if verdict == VerdictJump {
+		verdict = verdict + " " + r.Chain
+	}
+
+	return strings.Join(append(parts, verdict), " ")
+}
+
+// Chain is a regular chain of the ruleset.
+type Chain struct {
+	Name  string
+	Rules []Rule
+}
+
+// Ruleset holds the rules of the forward and postrouting base chains and the regular chains they jump to.
+type Ruleset struct {
+	Forward     []Rule
+	Postrouting []Rule
+	Chains      []Chain
+}
+
+// String returns the ruleset in nft syntax.
+func (rs Ruleset) String() string {
+	var lines []string
+
+	writeChain := func(name string, rules []Rule) {
+		lines = append(lines, fmt.Sprintf("chain %s {", name))
+		for _, rule := range rules {
+			lines = append(lines, "\t"+rule.String())
+		}
+		lines = append(lines, "}")
+	}
+
+	writeChain("forward", rs.Forward)
+	writeChain("postrouting", rs.Postrouting)
+	for _, chain := range rs.Chains {
+		writeChain(chain.Name, chain.Rules)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type Nftables struct {
+	Logger logr.Logger
+	// ApplyFailures counts the failures to apply the ruleset. It is optional.
+	ApplyFailures prometheus.Counter
+}
+
+func (nft *Nftables) Sync(state agent.State) error {
+	nft.Logger.Info("syncing network policies")
+
+	ruleset, err := GenerateRuleset(nft.Logger, state)
+	if err != nil {
+		return err
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+
+	err = apply(conn, ruleset)
+	if err != nil && nft.ApplyFailures != nil {
+		nft.ApplyFailures.Inc()
+	}
+
+	return err
+}
+
+// GenerateRuleset returns the ruleset enforcing the network policies of the peers and masquerading their traffic. It
+// has the same semantics as the iptables rules of the agent: every peer has its own chain, with an implicit deny if
+// the peer has policies. Traffic to a peer passes its ingress chain before the chain of its sender. Hostnames that do
+// not resolve are logged to log and skip only the rules matching them.
+func GenerateRuleset(log logr.Logger, state agent.State) (Ruleset, error) {
+	var ruleset Ruleset
+
+	network, _, err := agent.GetNetwork(state.Server)
+	if err != nil {
+		return ruleset, err
+	}
+
+	peerIsolation := state.Server.Spec.PeerIsolation
+
+	err = ruleset.addPeers(log, ipv4, state.Server.Status.Address, state.Server.Status.Dns, network.String(), state.Peers, peerIsolation)
+	if err != nil {
+		return ruleset, err
+	}
+
+	ipv6Network, ipv6Gateway, err := agent.GetIpv6Network(state.Server)
+	if err != nil || ipv6Network == nil {
+		return ruleset, err
+	}
+
+	err = ruleset.addPeers(log, ipv6, ipv6Gateway.String(), state.Server.Status.Dns, ipv6Network.String(), state.Peers, peerIsolation)
+	return ruleset, err
+}
+
+func (rs *Ruleset) addPeers(log logr.Logger, family ipFamily, wgServerIp string, kubeDnsIp string, network string, peers []v1alpha1.WireguardPeer, peerIsolation bool) error {
+	var isolatedSources []string
+	if peerIsolation {
+		isolatedSources = append(isolatedSources, network)
+		for _, peer := range peers {
+			for _, subnet := range agent.GetRoutedSubnets(peer) {
+				if family.matches(subnet.String()) {
+					isolatedSources = append(isolatedSources, subnet.String())
+				}
+			}
+		}
+	}
+
+	// ingress chains come first so that traffic between peers is subject to the ingress policies of the receiving peer
+	// before the egress policies of the sending peer accept it
+	for _, peer := range peers {
+		peerIp := peer.Spec.Address
+		if family.ipv6 {
+			peerIp = peer.Spec.Ipv6Address
+		}
+
+		if peerIp == "" || (len(peer.Spec.IngressNetworkPolicies) == 0 && len(isolatedSources) == 0) {
+			continue
+		}
+
+		ingressChain := strings.NewReplacer(".", "-", ":", "-").Replace(peerIp) + "-in"
+		if err := rs.addIngressChain(log, family, peer.Spec.IngressNetworkPolicies, ingressChain, peerIp, isolatedSources); err != nil {
+			return err
+		}
+
+		// traffic going to the subnets routed through the peer is subject to the peer ingress rules
+		for _, subnet := range agent.GetRoutedSubnets(peer) {
+			if family.matches(subnet.String()) {
+				rs.Forward = append(rs.Forward, Rule{Daddr: subnet.String(), Verdict: VerdictJump, Chain: ingressChain})
+			}
+		}
+	}
+
+	for _, peer := range peers {
+		peerIp := peer.Spec.Address
+		if family.ipv6 {
+			peerIp = peer.Spec.Ipv6Address
+		}
+
+		if peerIp == "" {
+			continue
+		}
+
+		peerChain := strings.NewReplacer(".", "-", ":", "-").Replace(peerIp)
+		if err := rs.addPeerChain(log, family, peer.Spec.EgressNetworkPolicies, peerChain, peerIp, kubeDnsIp, wgServerIp); err != nil {
+			return err
+		}
+
+		// traffic coming from the subnets routed through the peer is subject to the peer rules
+		for _, subnet := range agent.GetRoutedSubnets(peer) {
+			if !family.matches(subnet.String()) {
+				continue
+			}
+			rs.Forward = append(rs.Forward, Rule{Saddr: subnet.String(), Verdict: VerdictJump, Chain: peerChain})
+			// the routed subnets keep their addresses, their traffic is not masqueraded
+			rs.Postrouting = append(rs.Postrouting, Rule{Saddr: subnet.String(), Verdict: VerdictReturn})
+		}
+	}
+
+	rs.Postrouting = append(rs.Postrouting, Rule{Saddr: network, Oifname: "eth0", Verdict: VerdictMasquerade})
+
+	return nil
+}
+
+func (rs *Ruleset) addPeerChain(log logr.Logger, family ipFamily, policies v1alpha1.EgressNetworkPolicies, peerChain string, peerIp string, kubeDnsIp string, wgServerIp string) error {
+	// associate peer chain to forward chain
+	rs.Forward = append(rs.Forward, Rule{Saddr: peerIp, Verdict: VerdictJump, Chain: peerChain})
+
+	chain := Chain{Name: peerChain}
+
+	// allow peer to ping (ICMP) wireguard server for debugging purposes
+	for _, ip := range family.resolveAll(log, []string{wgServerIp}) {
+		chain.Rules = append(chain.Rules, Rule{Daddr: ip, Protocol: family.icmpProtocol, Verdict: VerdictAccept})
+	}
+
+	// allow peer to communicate with itself
+	chain.Rules = append(chain.Rules, Rule{Daddr: peerIp, Verdict: VerdictAccept})
+
+	if net.ParseIP(kubeDnsIp) != nil && family.matches(kubeDnsIp) {
+		// allow peer to communicate with kube-dns
+		chain.Rules = append(chain.Rules, Rule{Daddr: kubeDnsIp, Protocol: "udp", Dport: &PortRange{From: 53, To: 53}, Verdict: VerdictAccept})
+	}
+
+	for i, policy := range policies {
+		rules, policyChain, err := egressNetworkPolicyToRules(log, family, policy, fmt.Sprintf("%s-%d", peerChain, i))
+		if err != nil {
+			return err
+		}
+
+		chain.Rules = append(chain.Rules, rules...)
+		if policyChain != nil {
+			rs.Chains = append(rs.Chains, *policyChain)
+		}
+	}
+
+	// if policies are defined impose an implicit deny all
+	if len(policies) != 0 {
+		chain.Rules = append(chain.Rules, Rule{Verdict: VerdictReject})
+	}
+
+	rs.Chains = append(rs.Chains, chain)
+
+	return nil
+}
+
+// addIngressChain adds the chain holding the ingress rules of a peer. Traffic from isolatedSources to the peer is
+// rejected unless a policy accepts it. Accepted traffic returns to the forward chain, where it is still subject to the
+// chain of its sender.
+func (rs *Ruleset) addIngressChain(log logr.Logger, family ipFamily, policies v1alpha1.IngressNetworkPolicies, ingressChain string, peerIp string, isolatedSources []string) error {
+	// associate ingress chain to forward chain
+	rs.Forward = append(rs.Forward, Rule{Daddr: peerIp, Verdict: VerdictJump, Chain: ingressChain})
+
+	chain := Chain{Name: ingressChain}
+
+	// allow replies to the traffic sent by the peer
+	chain.Rules = append(chain.Rules, Rule{CtState: "established,related", Verdict: VerdictReturn})
+
+	for _, policy := range policies {
+		rules, err := ingressNetworkPolicyToRules(log, family, policy)
+		if err != nil {
+			return err
+		}
+		chain.Rules = append(chain.Rules, rules...)
+	}
+
+	for _, source := range isolatedSources {
+		chain.Rules = append(chain.Rules, Rule{Saddr: source, Verdict: VerdictReject})
+	}
+
+	// if policies are defined impose an implicit deny all
+	if len(policies) != 0 {
+		chain.Rules = append(chain.Rules, Rule{Verdict: VerdictReject})
+	}
+
+	rs.Chains = append(rs.Chains, chain)
+
+	return nil
+}
+
+// policyDirection describes how the network policies of one direction are translated into rules: which address of the
+// packets they match and which verdict accepts the traffic.
+type policyDirection struct {
+	source        bool
+	acceptVerdict string
+}
+
+// verdict returns the verdict of the rules of a policy with the given action. Policies without action reject the
+// traffic, and so do unknown actions, which the webhook does not admit.
+func (d policyDirection) verdict(action v1alpha1.EgressNetworkPolicyAction) string {
+	switch strings.ToUpper(string(action)) {
+	case "ACCEPT":
+		return d.acceptVerdict
+	case "", "REJECT":
+		return VerdictReject
+	default:
+		return VerdictReject
+	}
+}
+
+// egress policies match the destination of the traffic sent by a peer.
+var egress = policyDirection{acceptVerdict: VerdictAccept}
+
+// ingress policies match the source of the traffic sent to a peer. Accepted traffic returns to the forward chain.
+var ingress = policyDirection{source: true, acceptVerdict: VerdictReturn}
+
+// egressNetworkPolicyToRules translates policy into rules of the peer chain. Policies with except CIDRs jump to their
+// own chain named policyChainName, which returns for the excluded destinations before applying the action.
+func egressNetworkPolicyToRules(log logr.Logger, family ipFamily, policy v1alpha1.EgressNetworkPolicy, policyChainName string) ([]Rule, *Chain, error) {
+	return networkPolicyToRules(log, family, egress, policy, policyChainName)
+}
+
+// ingressNetworkPolicyToRules translates policy into rules of the ingress chain of a peer.
+func ingressNetworkPolicyToRules(log logr.Logger, family ipFamily, policy v1alpha1.IngressNetworkPolicy) ([]Rule, error) {
+	// ingress policies are egress policies matching the source of the traffic instead of its destination
+	rules, _, err := networkPolicyToRules(log, family, ingress, v1alpha1.EgressNetworkPolicy{
+		Action: policy.Action,
+		To: v1alpha1.EgressNetworkPolicyTo{
+			Ip:      policy.From.Ip,
+			Ips:     policy.From.Ips,
+			Port:    policy.To.Port,
+			EndPort: policy.To.EndPort,
+			Ports:   policy.To.Ports,
+		},
+		Protocol: policy.Protocol,
+		IcmpType: policy.IcmpType,
+	}, "")
+
+	return rules, err
+}
+
+// networkPolicyToRules translates policy into rules. A policy whose destinations all belong to the other family or do
+// not resolve has no rules, it still counts for the implicit deny of the chain.
+func networkPolicyToRules(log logr.Logger, family ipFamily, dir policyDirection, policy v1alpha1.EgressNetworkPolicy, policyChainName string) ([]Rule, *Chain, error) {
+	var rules []Rule
+
+	destinations := family.resolveAll(log, append([]string{policy.To.Ip}, policy.To.Ips...))
+	if len(destinations) == 0 {
+		if policy.To.Ip != "" || len(policy.To.Ips) != 0 {
+			return nil, nil, nil
+		}
+		destinations = []string{""}
+	}
+
+	action := dir.verdict(policy.Action)
+
+	target := Rule{Verdict: action}
+
+	var policyChain *Chain
+	for _, cidr := range policy.To.Except {
+		if !family.matches(cidr) {
+			continue
+		}
+
+		if policyChain == nil {
+			policyChain = &Chain{Name: policyChainName}
+			target = Rule{Verdict: VerdictJump, Chain: policyChainName}
+		}
+		exceptRule := Rule{Daddr: cidr, Verdict: VerdictReturn}
+		if dir.source {
+			exceptRule = Rule{Saddr: cidr, Verdict: VerdictReturn}
+		}
+		policyChain.Rules = append(policyChain.Rules, exceptRule)
+	}
+
+	if policyChain != nil {
+		policyChain.Rules = append(policyChain.Rules, Rule{Verdict: action})
+	}
+
+	ports := destinationPorts(policy.To)
+
+	var protocols []string
+	switch {
+	case policy.Protocol == "" && len(ports) != 0:
+		protocols = []string{"tcp", "udp"}
+	case policy.Protocol == v1alpha1.EgressNetworkPolicyProtocolICMP:
+		protocols = []string{family.icmpProtocol}
+	default:
+		protocols = []string{strings.ToLower(string(policy.Protocol))}
+	}
+
+	var icmpType *uint8
+	if policy.Protocol == v1alpha1.EgressNetworkPolicyProtocolICMP && policy.IcmpType != "" {
+		t, err := family.icmpType(policy.IcmpType)
+		if err != nil {
+			return nil, nil, err
+		}
+		icmpType = &t
+	}
+
+	for _, destination := range destinations {
+		for _, protocol := range protocols {
+			rule := target
+			if dir.source {
+				rule.Saddr = destination
+			} else {
+				rule.Daddr = destination
+			}
+			rule.Protocol = protocol
+
+			if protocol == "tcp" || protocol == "udp" {
+				for i := range ports {
+					portRule := rule
+					portRule.Dport = &ports[i]
+					rules = append(rules, portRule)
+				}
+
+				if len(ports) != 0 {
+					continue
+				}
+			}
+
+			rule.IcmpType = icmpType
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, policyChain, nil
+}
+
+// destinationPorts returns the destination ports of a policy.
+func destinationPorts(to v1alpha1.EgressNetworkPolicyTo) []PortRange {
+	var ports []PortRange
+	if to.Port != 0 {
+		portRange := PortRange{From: uint16(to.Port), To: uint16(to.Port)}
+		if to.EndPort > to.Port {
+			portRange.To = uint16(to.EndPort)
+		}
+		ports = append(ports, portRange)
+	}
+
+	for _, port := range to.Ports {
+		ports = append(ports, PortRange{From: uint16(port), To: uint16(port)})
+	}
+
+	return ports
+}
@@ -0,0 +1,236 @@
+package nftables
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/nftables/expr"
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"golang.org/x/sys/unix"
+)
+
+func TestGenerateRuleset(t *testing.T) {
+	tests := []struct {
+		name            string
+		state           agent.State
+		expectedRuleset string
+	}{
+		{
+			name: "peer without policies",
+			state: agent.State{
+				Server: v1alpha1.Wireguard{Status: v1alpha1.WireguardStatus{Address: "10.8.0.1", Dns: "100.64.0.10"}},
+				Peers:  []v1alpha1.WireguardPeer{{Spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.2"}}},
+			},
+			expectedRuleset: `chain forward {
+	ip saddr 10.8.0.2 jump 10-8-0-2
+}
+chain postrouting {
+	ip saddr 10.8.0.0/24 oifname "eth0" masquerade
+}
+chain 10-8-0-2 {
+	ip daddr 10.8.0.1 meta l4proto icmp accept
+	ip daddr 10.8.0.2 accept
+	ip daddr 100.64.0.10 udp dport 53 accept
+}`,
+		},
+		{
+			name: "hostname destinations",
+			state: agent.State{
+				Server: v1alpha1.Wireguard{Status: v1alpha1.WireguardStatus{Address: "vpn.example.com", Dns: "100.64.0.10"}},
+				Peers: []v1alpha1.WireguardPeer{{Spec: v1alpha1.WireguardPeerSpec{
+					Address: "10.8.0.2",
+					EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{
+						{
+							Action: v1alpha1.EgressNetworkPolicyActionAccept,
+							To:     v1alpha1.EgressNetworkPolicyTo{Ip: "example.com", Ips: []string{"unknown.example.com", "10.0.0.1"}},
+						},
+						{
+							Action: v1alpha1.EgressNetworkPolicyActionAccept,
+							To:     v1alpha1.EgressNetworkPolicyTo{Ip: "unknown.example.com"},
+						},
+						{
+							Action: "Drop",
+							To:     v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.2"},
+						},
+					},
+				}}},
+			},
+			expectedRuleset: `chain forward {
+	ip saddr 10.8.0.2 jump 10-8-0-2
+}
+chain postrouting {
+	ip saddr 10.8.0.0/24 oifname "eth0" masquerade
+}
+chain 10-8-0-2 {
+	ip daddr 10.8.0.1 meta l4proto icmp accept
+	ip daddr 10.8.0.2 accept
+	ip daddr 100.64.0.10 udp dport 53 accept
+	ip daddr 93.184.216.34 accept
+	ip daddr 10.0.0.1 accept
+	ip daddr 10.0.0.2 reject
+	reject
+}`,
+		},
+		{
+			name: "dual-stack peer with policies",
+			state: agent.State{
+				Server: v1alpha1.Wireguard{
+					Spec:   v1alpha1.WireguardSpec{Network: v1alpha1.WireguardNetwork{Ipv6Cidr: "fd00:8::/64"}},
+					Status: v1alpha1.WireguardStatus{Address: "10.8.0.1", Dns: "100.64.0.10"},
+				},
+				Peers: []v1alpha1.WireguardPeer{{Spec: v1alpha1.WireguardPeerSpec{
+					Address:     "10.8.0.2",
+					Ipv6Address: "fd00:8::2",
+					EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{
+						{
+							Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+							Protocol: v1alpha1.EgressNetworkPolicyProtocolTCP,
+							To:       v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.0/8", Except: []string{"10.0.5.0/24"}, Port: 8000, EndPort: 8100},
+						},
+						{
+							Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+							Protocol: v1alpha1.EgressNetworkPolicyProtocolICMP,
+							IcmpType: "echo-request",
+						},
+					},
+				}}},
+			},
+			expectedRuleset: `chain forward {
+	ip saddr 10.8.0.2 jump 10-8-0-2
+	ip6 saddr fd00:8::2 jump fd00-8--2
+}
+chain postrouting {
+	ip saddr 10.8.0.0/24 oifname "eth0" masquerade
+	ip6 saddr fd00:8::/64 oifname "eth0" masquerade
+}
+chain 10-8-0-2-0 {
+	ip daddr 10.0.5.0/24 return
+	accept
+}
+chain 10-8-0-2 {
+	ip daddr 10.8.0.1 meta l4proto icmp accept
+	ip daddr 10.8.0.2 accept
+	ip daddr 100.64.0.10 udp dport 53 accept
+	ip daddr 10.0.0.0/8 tcp dport 8000-8100 jump 10-8-0-2-0
+	icmp type 8 accept
+	reject
+}
+chain fd00-8--2 {
+	ip6 daddr fd00:8::1 meta l4proto icmpv6 accept
+	ip6 daddr fd00:8::2 accept
+	icmpv6 type 128 accept
+	reject
+}`,
+		},
+		{
+			name: "isolated peers with ingress policies",
+			state: agent.State{
+				Server: v1alpha1.Wireguard{
+					Spec:   v1alpha1.WireguardSpec{PeerIsolation: true},
+					Status: v1alpha1.WireguardStatus{Address: "10.8.0.1", Dns: "100.64.0.10"},
+				},
+				Peers: []v1alpha1.WireguardPeer{
+					{Spec: v1alpha1.WireguardPeerSpec{
+						Address: "10.8.0.2",
+						IngressNetworkPolicies: v1alpha1.IngressNetworkPolicies{
+							{
+								Action:   v1alpha1.EgressNetworkPolicyActionAccept,
+								Protocol: v1alpha1.EgressNetworkPolicyProtocolTCP,
+								From:     v1alpha1.IngressNetworkPolicyFrom{Ips: []string{"10.8.0.3", "10.244.0.0/16"}},
+								To:       v1alpha1.IngressNetworkPolicyTo{Port: 22},
+							},
+						},
+					}},
+					{Spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.3", RoutedSubnets: []string{"192.168.10.0/24"}}},
+				},
+			},
+			expectedRuleset: `chain forward {
+	ip daddr 10.8.0.2 jump 10-8-0-2-in
+	ip daddr 10.8.0.3 jump 10-8-0-3-in
+	ip daddr 192.168.10.0/24 jump 10-8-0-3-in
+	ip saddr 10.8.0.2 jump 10-8-0-2
+	ip saddr 10.8.0.3 jump 10-8-0-3
+	ip saddr 192.168.10.0/24 jump 10-8-0-3
+}
+chain postrouting {
+	ip saddr 192.168.10.0/24 return
+	ip saddr 10.8.0.0/24 oifname "eth0" masquerade
+}
+chain 10-8-0-2-in {
+	ct state established,related return
+	ip saddr 10.8.0.3 tcp dport 22 return
+	ip saddr 10.244.0.0/16 tcp dport 22 return
+	ip saddr 10.8.0.0/24 reject
+	ip saddr 192.168.10.0/24 reject
+	reject
+}
+chain 10-8-0-3-in {
+	ct state established,related return
+	ip saddr 10.8.0.0/24 reject
+	ip saddr 192.168.10.0/24 reject
+}
+chain 10-8-0-2 {
+	ip daddr 10.8.0.1 meta l4proto icmp accept
+	ip daddr 10.8.0.2 accept
+	ip daddr 100.64.0.10 udp dport 53 accept
+}
+chain 10-8-0-3 {
+	ip daddr 10.8.0.1 meta l4proto icmp accept
+	ip daddr 10.8.0.3 accept
+	ip daddr 100.64.0.10 udp dport 53 accept
+}`,
+		},
+	}
+
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800:220:1::")}, nil
+		case "vpn.example.com":
+			return []net.IP{net.ParseIP("10.8.0.1")}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	defer func() { lookupIP = net.LookupIP }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ruleset, err := GenerateRuleset(logr.Discard(), test.state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ruleset.String() != test.expectedRuleset {
+				t.Errorf("got %s, want %s", ruleset, test.expectedRuleset)
+			}
+		})
+	}
+}
+
+func TestRuleExprs(t *testing.T) {
+	rule := Rule{Daddr: "10.0.0.0/8", Protocol: "tcp", Dport: &PortRange{From: 443, To: 443}, Verdict: VerdictAccept}
+
+	expected := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.NFPROTO_IPV4}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 16, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{255, 0, 0, 0}, Xor: []byte{0, 0, 0, 0}},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{10, 0, 0, 0}},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{1, 187}},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+
+	exprs, err := rule.Exprs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(exprs, expected) {
+		t.Errorf("got %v, want %v", exprs, expected)
+	}
+}
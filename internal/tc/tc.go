@@ -0,0 +1,424 @@
+package tc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// rootMajor is the major number of the root htb qdiscs and of the classes of the peers
+const rootMajor = 1
+
+// filterPriority returns the priority of the u32 filters for the given protocol. Filters sharing a priority must
+// match the same protocol.
+func filterPriority(protocol uint16) uint16 {
+	if protocol == syscall.ETH_P_IPV6 {
+		return 2
+	}
+
+	return 1
+}
+
+// direction describes how the traffic in one direction is shaped: on which device and by which address of the peer
+// it is classified.
+type direction struct {
+	name        string
+	matchSource bool
+	speed       func(peer v1alpha1.WireguardPeer) v1alpha1.Speed
+}
+
+// download is the traffic sent to the peers. It leaves through the wg interface and is classified by destination.
+var download = direction{name: "download", speed: func(peer v1alpha1.WireguardPeer) v1alpha1.Speed { return peer.Spec.DownloadSpeed }}
+
+// upload is the traffic sent by the peers. It enters through the wg interface, is redirected to an ifb device and
+// classified there by source.
+var upload = direction{name: "upload", matchSource: true, speed: func(peer v1alpha1.WireguardPeer) v1alpha1.Speed { return peer.Spec.UploadSpeed }}
+
+// peerClass is the htb class of a peer together with the tunnel addresses classified into it.
+type peerClass struct {
+	rate      uint64
+	addresses []net.IP
+}
+
+type Tc struct {
+	Logger logr.Logger
+	Iface  string
+}
+
+// IfbName returns the name of the ifb device used to shape the traffic entering iface.
+func IfbName(iface string) string {
+	return "ifb-" + iface
+}
+
+// Sync shapes the bandwidth of the peers according to their DownloadSpeed and UploadSpeed. Classes of peers whose
+// limits did not change are left untouched.
+func (t *Tc) Sync(state agent.State) error {
+	t.Logger.Info("syncing traffic shaping")
+
+	link, err := netlink.LinkByName(t.Iface)
+	if err != nil {
+		return err
+	}
+
+	downloadClasses := getPeerClasses(state, download, t.Logger)
+	if err := syncClasses(link, downloadClasses, download); err != nil {
+		return err
+	}
+
+	uploadClasses := getPeerClasses(state, upload, t.Logger)
+
+	var ifb netlink.Link
+	if len(uploadClasses) != 0 {
+		ifb, err = syncIfb(link)
+	} else {
+		// the ifb device is only created once a peer has an upload limit
+		ifb, err = netlink.LinkByName(IfbName(t.Iface))
+		if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+			return nil
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return syncClasses(ifb, uploadClasses, upload)
+}
+
+// SpeedToBitsPerSecond converts speed to bits per second. A speed without unit is in mbps. It returns 0 if the speed
+// is not limited.
+func SpeedToBitsPerSecond(speed v1alpha1.Speed) uint64 {
+	if speed.Value <= 0 {
+		return 0
+	}
+
+	if speed.Unit == "kbps" {
+		return uint64(speed.Value) * 1000
+	}
+
+	return uint64(speed.Value) * 1000 * 1000
+}
+
+// ClassMinor returns the minor number of the htb class of the peer with the given IPv4 address in the tunnel network.
+// It is derived from the last 16 bits of the address, which identify the peer inside tunnel networks up to /16. Larger
+// networks are rejected, as the minors of their peers could collide.
+func ClassMinor(network *net.IPNet, address net.IP) (uint16, error) {
+	ip := address.To4()
+	if ip == nil {
+		return 0, fmt.Errorf("%s is not an IPv4 address", address)
+	}
+
+	if ones, bits := network.Mask.Size(); bits != 8*net.IPv4len || ones < 16 {
+		return 0, fmt.Errorf("tunnel network %s is larger than /16, the traffic classes of its peers could collide", network)
+	}
+
+	minor := binary.BigEndian.Uint16(ip[2:])
+	if minor == 0 || minor == 0xffff {
+		return 0, fmt.Errorf("no traffic class available for address %s", address)
+	}
+
+	return minor, nil
+}
+
+// U32Selector returns the u32 selector matching the source or destination address of IP packets. Offsets are relative
+// to the network header, as wg interfaces do not have a link layer header.
+func U32Selector(address net.IP, matchSource bool) *netlink.TcU32Sel {
+	sel := &netlink.TcU32Sel{Flags: nl.TC_U32_TERMINAL}
+
+	if ip := address.To4(); ip != nil {
+		offset := int32(16)
+		if matchSource {
+			offset = 12
+		}
+		sel.Keys = []netlink.TcU32Key{{Mask: 0xffffffff, Val: binary.BigEndian.Uint32(ip), Off: offset}}
+		return sel
+	}
+
+	ip := address.To16()
+	offset := int32(24)
+	if matchSource {
+		offset = 8
+	}
+	for i := 0; i < net.IPv6len; i += 4 {
+		sel.Keys = append(sel.Keys, netlink.TcU32Key{Mask: 0xffffffff, Val: binary.BigEndian.Uint32(ip[i:]), Off: offset + int32(i)})
+	}
+
+	return sel
+}
+
+// getPeerClasses returns the classes of the enabled peers that have a limit in the given direction, indexed by minor.
+func getPeerClasses(state agent.State, dir direction, logger logr.Logger) map[uint16]peerClass {
+	classes := make(map[uint16]peerClass)
+
+	network, _, err := agent.GetNetwork(state.Server)
+	if err != nil {
+		logger.Error(err, "unable to shape the traffic of the peers", "direction", dir.name)
+		return classes
+	}
+
+	for _, peer := range state.Peers {
+		rate := SpeedToBitsPerSecond(dir.speed(peer))
+		if peer.Spec.Disabled || rate == 0 {
+			continue
+		}
+
+		address := net.ParseIP(peer.Spec.Address)
+		minor, err := ClassMinor(network, address)
+		if err != nil {
+			logger.Error(err, "unable to shape the traffic of peer", "peer", peer.Name, "direction", dir.name)
+			continue
+		}
+
+		class := peerClass{rate: rate, addresses: []net.IP{address}}
+		if ipv6Address := net.ParseIP(peer.Spec.Ipv6Address); agent.IsDualStack(state.Server) && ipv6Address != nil {
+			class.addresses = append(class.addresses, ipv6Address)
+		}
+
+		classes[minor] = class
+	}
+
+	return classes
+}
+
+// syncIfb creates the ifb device and redirects the traffic entering link to it.
+func syncIfb(link netlink.Link) (netlink.Link, error) {
+	name := IfbName(link.Attrs().Name)
+
+	ifb, err := netlink.LinkByName(name)
+	if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+		err = netlink.LinkAdd(&netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: name}})
+		if err != nil {
+			return nil, err
+		}
+
+		ifb, err = netlink.LinkByName(name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := netlink.LinkSetUp(ifb); err != nil {
+		return nil, err
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() == "ingress" {
+			return ifb, nil
+		}
+	}
+
+	ingress := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+	if err := netlink.QdiscAdd(ingress); err != nil {
+		return nil, err
+	}
+
+	// redirect all IPv4 and IPv6 traffic entering the wg interface to the ifb device
+	for _, protocol := range []uint16{syscall.ETH_P_IP, syscall.ETH_P_IPV6} {
+		redirect := &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    ingress.Handle,
+				Priority:  filterPriority(protocol),
+				Protocol:  protocol,
+			},
+			Actions: []netlink.Action{netlink.NewMirredAction(ifb.Attrs().Index)},
+		}
+		if err := netlink.FilterAdd(redirect); err != nil {
+			return nil, err
+		}
+	}
+
+	return ifb, nil
+}
+
+// syncRootQdisc adds the root htb qdisc to link if it does not exist yet. Traffic not classified into a peer class is
+// not shaped. It returns false if the qdisc does not exist and is not needed as no class has to be added.
+func syncRootQdisc(link netlink.Link, needed bool) (bool, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return false, err
+	}
+
+	handle := netlink.MakeHandle(rootMajor, 0)
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Handle == handle && qdisc.Type() == "htb" {
+			return true, nil
+		}
+	}
+
+	if !needed {
+		return false, nil
+	}
+
+	return true, netlink.QdiscReplace(netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    handle,
+		Parent:    netlink.HANDLE_ROOT,
+	}))
+}
+
+// syncClasses adds, changes and removes the htb classes of link and the filters classifying the traffic of the peers
+// into them so that they match classes.
+func syncClasses(link netlink.Link, classes map[uint16]peerClass, dir direction) error {
+	exists, err := syncRootQdisc(link, len(classes) != 0)
+	if err != nil || !exists {
+		return err
+	}
+
+	root := netlink.MakeHandle(rootMajor, 0)
+
+	existingClasses, err := netlink.ClassList(link, root)
+	if err != nil {
+		return err
+	}
+
+	filters, err := netlink.FilterList(link, root)
+	if err != nil {
+		return err
+	}
+
+	// the filters classifying the traffic into the classes, by class handle
+	classFilters := make(map[uint32][]*netlink.U32)
+	for _, filter := range filters {
+		if u32, ok := filter.(*netlink.U32); ok && u32.ClassId != 0 {
+			classFilters[u32.ClassId] = append(classFilters[u32.ClassId], u32)
+		}
+	}
+
+	existing := make(map[uint16]*netlink.HtbClass)
+	for _, class := range existingClasses {
+		htbClass, ok := class.(*netlink.HtbClass)
+		if !ok || htbClass.Parent != root {
+			continue
+		}
+		_, minor := netlink.MajorMinor(htbClass.Handle)
+		existing[minor] = htbClass
+	}
+
+	// remove the classes of peers that are gone or no longer limited, filters first as they reference the class
+	for minor, class := range existing {
+		if _, ok := classes[minor]; ok {
+			continue
+		}
+
+		for _, filter := range classFilters[class.Handle] {
+			if err := netlink.FilterDel(filter); err != nil {
+				return err
+			}
+		}
+
+		if err := netlink.ClassDel(class); err != nil {
+			return err
+		}
+	}
+
+	for minor, class := range classes {
+		htbClass := netlink.NewHtbClass(netlink.ClassAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(rootMajor, minor),
+			Parent:    root,
+		}, netlink.HtbClassAttrs{Rate: class.rate})
+
+		current, ok := existing[minor]
+		if ok {
+			if current.Rate != htbClass.Rate {
+				if err := netlink.ClassChange(htbClass); err != nil {
+					return err
+				}
+			}
+
+			// the addresses of the peer changed, e.g. it got an IPv6 address, replace the filters of its class
+			if filtersMatch(classFilters[htbClass.Handle], class.addresses, dir.matchSource) {
+				continue
+			}
+
+			for _, filter := range classFilters[htbClass.Handle] {
+				if err := netlink.FilterDel(filter); err != nil {
+					return err
+				}
+			}
+		} else if err := netlink.ClassAdd(htbClass); err != nil {
+			return err
+		}
+
+		if err := addFilters(link, htbClass.Handle, class.addresses, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filtersMatch returns true if filters classify exactly the traffic of the given addresses.
+func filtersMatch(filters []*netlink.U32, addresses []net.IP, matchSource bool) bool {
+	if len(filters) != len(addresses) {
+		return false
+	}
+
+	remaining := make([]*netlink.U32, len(filters))
+	copy(remaining, filters)
+
+	for _, address := range addresses {
+		want := U32Selector(address, matchSource).Keys
+		found := false
+		for i, filter := range remaining {
+			if filter != nil && filter.Sel != nil && reflect.DeepEqual(filter.Sel.Keys, want) {
+				remaining[i] = nil
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// addFilters classifies the traffic of the given addresses into the class with the given handle.
+func addFilters(link netlink.Link, classId uint32, addresses []net.IP, dir direction) error {
+	for _, address := range addresses {
+		protocol := uint16(syscall.ETH_P_IP)
+		if address.To4() == nil {
+			protocol = syscall.ETH_P_IPV6
+		}
+
+		err := netlink.FilterAdd(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    netlink.MakeHandle(rootMajor, 0),
+				Priority:  filterPriority(protocol),
+				Protocol:  protocol,
+			},
+			ClassId: classId,
+			Sel:     U32Selector(address, dir.matchSource),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
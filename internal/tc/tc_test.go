@@ -0,0 +1,175 @@
+package tc
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/vishvananda/netlink"
+)
+
+func TestSpeedToBitsPerSecond(t *testing.T) {
+	tests := []struct {
+		name     string
+		speed    v1alpha1.Speed
+		expected uint64
+	}{
+		{name: "not limited", speed: v1alpha1.Speed{}, expected: 0},
+		{name: "mbps", speed: v1alpha1.Speed{Value: 10, Unit: "mbps"}, expected: 10000000},
+		{name: "kbps", speed: v1alpha1.Speed{Value: 512, Unit: "kbps"}, expected: 512000},
+		{name: "defaults to mbps", speed: v1alpha1.Speed{Value: 2}, expected: 2000000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SpeedToBitsPerSecond(test.speed); got != test.expected {
+				t.Errorf("got %d, want %d", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestClassMinor(t *testing.T) {
+	tests := []struct {
+		name      string
+		network   string
+		address   string
+		expected  uint16
+		expectErr bool
+	}{
+		{name: "address in /24", network: "10.8.0.0/24", address: "10.8.0.2", expected: 2},
+		{name: "address in /16", network: "172.16.0.0/16", address: "172.16.3.4", expected: 0x0304},
+		{name: "network address of /16", network: "172.16.0.0/16", address: "172.16.0.0", expectErr: true},
+		{name: "address in /8", network: "10.0.0.0/8", address: "10.1.0.2", expectErr: true},
+		{name: "IPv6 address", network: "10.8.0.0/24", address: "fd00:8::2", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, network, _ := net.ParseCIDR(test.network)
+			got, err := ClassMinor(network, net.ParseIP(test.address))
+			if (err != nil) != test.expectErr {
+				t.Fatalf("got error %v, want error %t", err, test.expectErr)
+			}
+			if got != test.expected {
+				t.Errorf("got %d, want %d", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestU32Selector(t *testing.T) {
+	tests := []struct {
+		name        string
+		address     string
+		matchSource bool
+		expected    []netlink.TcU32Key
+	}{
+		{
+			name:     "IPv4 destination",
+			address:  "10.8.0.2",
+			expected: []netlink.TcU32Key{{Mask: 0xffffffff, Val: 0x0a080002, Off: 16}},
+		},
+		{
+			name:        "IPv4 source",
+			address:     "10.8.0.2",
+			matchSource: true,
+			expected:    []netlink.TcU32Key{{Mask: 0xffffffff, Val: 0x0a080002, Off: 12}},
+		},
+		{
+			name:        "IPv6 source",
+			address:     "fd00:8::2",
+			matchSource: true,
+			expected: []netlink.TcU32Key{
+				{Mask: 0xffffffff, Val: 0xfd000008, Off: 8},
+				{Mask: 0xffffffff, Val: 0, Off: 12},
+				{Mask: 0xffffffff, Val: 0, Off: 16},
+				{Mask: 0xffffffff, Val: 2, Off: 20},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := U32Selector(net.ParseIP(test.address), test.matchSource)
+			if !reflect.DeepEqual(got.Keys, test.expected) {
+				t.Errorf("got %+v, want %+v", got.Keys, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetPeerClasses(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidr      string
+		addresses []string
+		expected  map[uint16]peerClass
+	}{
+		{
+			name:      "network of /16",
+			cidr:      "10.8.0.0/16",
+			addresses: []string{"10.8.0.2", "10.8.1.2"},
+			expected: map[uint16]peerClass{
+				0x0002: {rate: 10000000, addresses: []net.IP{net.ParseIP("10.8.0.2")}},
+				0x0102: {rate: 10000000, addresses: []net.IP{net.ParseIP("10.8.1.2")}},
+			},
+		},
+		{
+			// the minors of 10.0.0.2 and 10.1.0.2 would collide
+			name:      "network larger than /16",
+			cidr:      "10.0.0.0/14",
+			addresses: []string{"10.0.0.2", "10.1.0.2"},
+			expected:  map[uint16]peerClass{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := agent.State{
+				Server: v1alpha1.Wireguard{Spec: v1alpha1.WireguardSpec{Network: v1alpha1.WireguardNetwork{Cidr: test.cidr}}},
+			}
+			for _, address := range test.addresses {
+				state.Peers = append(state.Peers, v1alpha1.WireguardPeer{Spec: v1alpha1.WireguardPeerSpec{Address: address, DownloadSpeed: v1alpha1.Speed{Value: 10}}})
+			}
+
+			got := getPeerClasses(state, download, logr.Discard())
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %+v, want %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFiltersMatch(t *testing.T) {
+	ipv4 := net.ParseIP("10.8.0.2")
+	ipv6 := net.ParseIP("fd00:8::2")
+	filter := func(address net.IP) *netlink.U32 {
+		return &netlink.U32{Sel: U32Selector(address, false)}
+	}
+
+	tests := []struct {
+		name      string
+		filters   []*netlink.U32
+		addresses []net.IP
+		expected  bool
+	}{
+		{name: "same addresses", filters: []*netlink.U32{filter(ipv4), filter(ipv6)}, addresses: []net.IP{ipv6, ipv4}, expected: true},
+		{name: "IPv6 address added", filters: []*netlink.U32{filter(ipv4)}, addresses: []net.IP{ipv4, ipv6}, expected: false},
+		{name: "address changed", filters: []*netlink.U32{filter(net.ParseIP("10.8.0.3"))}, addresses: []net.IP{ipv4}, expected: false},
+		{name: "duplicate filter", filters: []*netlink.U32{filter(ipv4), filter(ipv4)}, addresses: []net.IP{ipv4, ipv6}, expected: false},
+		{name: "filter without selector", filters: []*netlink.U32{{}}, addresses: []net.IP{ipv4}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := filtersMatch(test.filters, test.addresses, false)
+			if got != test.expected {
+				t.Errorf("got %t, want %t", got, test.expected)
+			}
+		})
+	}
+}
@@ -18,6 +18,8 @@ type State struct {
 	Server           v1alpha1.Wireguard
 	ServerPrivateKey string
 	Peers            []v1alpha1.WireguardPeer
+	// PresharedKeys holds the preshared keys of the peers indexed by the peer public key
+	PresharedKeys map[string]string `json:",omitempty"`
 }
 
 func IsStateValid(state State) error {
@@ -38,6 +40,14 @@ func IsStateValid(state State) error {
 		return fmt.Errorf("dns is not defined")
 	}
 
+	if _, _, err := GetNetwork(state.Server); err != nil {
+		return err
+	}
+
+	if _, _, err := GetIpv6Network(state.Server); err != nil {
+		return err
+	}
+
 	for i, peer := range state.Peers {
 		if peer.Spec.Address == "" {
 			return fmt.Errorf("peer with index %d does not have the address defined", i)
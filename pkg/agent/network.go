@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/korylprince/ipnetgen"
+)
+
+// DefaultNetworkCidr is the tunnel network used when Wireguard.Spec.Network.Cidr is not set.
+const DefaultNetworkCidr = "10.8.0.0/24"
+
+// DefaultListenPort is the UDP port of the wg server when Wireguard.Spec.ListenPort is not set.
+const DefaultListenPort = 51820
+
+// DefaultMtu is the MTU of the wg interface of the server, which peers use when Wireguard.Spec.Mtu is not set.
+const DefaultMtu = 1420
+
+// FallbackDns is the DNS server of the peers when Wireguard.Spec.Dns is not set and the cluster DNS service cannot be
+// found.
+const FallbackDns = "1.1.1.1"
+
+// GetListenPort returns the UDP port the wg server of a Wireguard instance listens on.
+func GetListenPort(wireguard v1alpha1.Wireguard) int32 {
+	if wireguard.Spec.ListenPort != 0 {
+		return wireguard.Spec.ListenPort
+	}
+
+	return DefaultListenPort
+}
+
+// GetNetwork returns the tunnel network of a Wireguard instance and the address of the server inside it.
+func GetNetwork(wireguard v1alpha1.Wireguard) (*net.IPNet, net.IP, error) {
+	cidr := wireguard.Spec.Network.Cidr
+	if cidr == "" {
+		cidr = DefaultNetworkCidr
+	}
+
+	network, gateway, err := parseNetwork(cidr, wireguard.Spec.Network.Gateway)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if network.IP.To4() == nil {
+		return nil, nil, fmt.Errorf("network cidr %s is not an IPv4 network", cidr)
+	}
+
+	return network, gateway, nil
+}
+
+// GetIpv6Network returns the IPv6 tunnel network of a dual-stack Wireguard instance and the address of the
+// server inside it. Both are nil if the instance is IPv4 only.
+func GetIpv6Network(wireguard v1alpha1.Wireguard) (*net.IPNet, net.IP, error) {
+	cidr := wireguard.Spec.Network.Ipv6Cidr
+	if cidr == "" {
+		return nil, nil, nil
+	}
+
+	network, gateway, err := parseNetwork(cidr, wireguard.Spec.Network.Ipv6Gateway)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if network.IP.To4() != nil {
+		return nil, nil, fmt.Errorf("network ipv6 cidr %s is not an IPv6 network", cidr)
+	}
+
+	return network, gateway, nil
+}
+
+// IsDualStack returns true if the Wireguard instance has an IPv6 tunnel network on top of the IPv4 one.
+func IsDualStack(wireguard v1alpha1.Wireguard) bool {
+	return wireguard.Spec.Network.Ipv6Cidr != ""
+}
+
+func parseNetwork(cidr string, gatewayAddress string) (*net.IPNet, net.IP, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid network cidr %s: %w", cidr, err)
+	}
+
+	if gatewayAddress == "" {
+		gateway := make(net.IP, len(network.IP))
+		copy(gateway, network.IP)
+		ipnetgen.Increment(gateway)
+
+		return network, gateway, nil
+	}
+
+	gateway := net.ParseIP(gatewayAddress)
+	if gateway == nil {
+		return nil, nil, fmt.Errorf("invalid network gateway %s", gatewayAddress)
+	}
+
+	if !network.Contains(gateway) {
+		return nil, nil, fmt.Errorf("network gateway %s is not part of %s", gateway, network)
+	}
+
+	if ip := gateway.To4(); ip != nil {
+		gateway = ip
+	}
+
+	return network, gateway, nil
+}
+
+// GetBroadcastAddress returns the last address of the network.
+func GetBroadcastAddress(network *net.IPNet) net.IP {
+	broadcast := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		broadcast[i] = network.IP[i] | ^network.Mask[i]
+	}
+
+	return broadcast
+}
+
+// GetAvailableIp returns the first address of the network cidr that is not part of usedIps.
+func GetAvailableIp(cidr string, usedIps []string) (string, error) {
+	gen, err := ipnetgen.New(cidr)
+	if err != nil {
+		return "", err
+	}
+	for ip := gen.Next(); ip != nil; ip = gen.Next() {
+		used := false
+		for _, usedIp := range usedIps {
+			if ip.String() == usedIp {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return ip.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no available ip found in %s", cidr)
+}
+
+// GetRoutedSubnets returns the subnets routed through the peer. Invalid CIDRs are ignored.
+func GetRoutedSubnets(peer v1alpha1.WireguardPeer) []*net.IPNet {
+	var subnets []*net.IPNet
+	for _, cidr := range peer.Spec.RoutedSubnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/robfig/cron/v3"
+)
+
+// GetAccessWindow returns whether one of the access windows of a peer schedule is open at now, and the next time one
+// of the windows opens or closes. Peers without a schedule can always connect, the returned time is then zero.
+func GetAccessWindow(schedule *v1alpha1.AccessSchedule, now time.Time) (bool, time.Time, error) {
+	if schedule == nil {
+		return true, time.Time{}, nil
+	}
+
+	location, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	now = now.In(location)
+
+	open := false
+	var next time.Time
+	for _, window := range schedule.Windows {
+		start, err := cron.ParseStandard(window.Start)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("invalid start %q of access window: %w", window.Start, err)
+		}
+
+		// the first start after now - duration is the start of the open window, or else the next start
+		boundary := start.Next(now.Add(-window.Duration.Duration))
+		if boundary.IsZero() {
+			// the window never opens
+			continue
+		}
+
+		if !boundary.After(now) {
+			open = true
+			boundary = boundary.Add(window.Duration.Duration)
+		}
+
+		if next.IsZero() || boundary.Before(next) {
+			next = boundary
+		}
+	}
+
+	return open, next, nil
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetAccessWindow(t *testing.T) {
+	businessHours := v1alpha1.AccessWindow{Start: "0 9 * * 1-5", Duration: metav1.Duration{Duration: 8 * time.Hour}}
+	evening := v1alpha1.AccessWindow{Start: "0 20 * * *", Duration: metav1.Duration{Duration: time.Hour}}
+
+	// 2024-06-03 is a Monday, Europe/Berlin is two hours ahead of UTC in June
+	at := func(day int, hour int) time.Time {
+		return time.Date(2024, time.June, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule *v1alpha1.AccessSchedule
+		now      time.Time
+		open     bool
+		next     time.Time
+		err      bool
+	}{
+		{name: "no schedule", now: at(3, 12), open: true},
+		{name: "inside the window", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(3, 8), open: true, next: at(3, 15)},
+		{name: "start of the window", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(3, 7), open: true, next: at(3, 15)},
+		{name: "before the window", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(3, 6), next: at(3, 7)},
+		{name: "end of the window", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(3, 15), next: at(4, 7)},
+		{name: "weekend", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(8, 12), next: at(10, 7)},
+		{name: "utc by default", schedule: &v1alpha1.AccessSchedule{Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(3, 8), next: at(3, 9)},
+		{name: "next of several windows", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours, evening}}, now: at(3, 16), next: at(3, 18)},
+		{name: "second of several windows open", schedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{businessHours, evening}}, now: at(3, 18), open: true, next: at(3, 19)},
+		{name: "invalid time zone", schedule: &v1alpha1.AccessSchedule{TimeZone: "Mars/Olympus", Windows: []v1alpha1.AccessWindow{businessHours}}, now: at(3, 8), err: true},
+		{name: "invalid start", schedule: &v1alpha1.AccessSchedule{Windows: []v1alpha1.AccessWindow{{Start: "at nine", Duration: metav1.Duration{Duration: time.Hour}}}}, now: at(3, 8), err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			open, next, err := GetAccessWindow(test.schedule, test.now)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want error %v", err, test.err)
+			}
+
+			if open != test.open {
+				t.Errorf("got open %v, want %v", open, test.open)
+			}
+
+			if !next.Equal(test.next) {
+				t.Errorf("got next %v, want %v", next, test.next)
+			}
+		})
+	}
+}
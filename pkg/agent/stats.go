@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"time"
+)
+
+// HandshakeTimeout is the time after its last handshake a peer is no longer considered connected. WireGuard rejects
+// the packets of sessions older than 180 seconds.
+const HandshakeTimeout = 180 * time.Second
+
+// PeerStats holds the statistics of a peer collected from the wg device.
+type PeerStats struct {
+	PublicKey string `json:"publicKey"`
+	// Endpoint is the address the wg server last received traffic of the peer from
+	Endpoint          string    `json:"endpoint,omitempty"`
+	LastHandshakeTime time.Time `json:"lastHandshakeTime,omitempty"`
+	// ReceiveBytes is the number of bytes received from the peer
+	ReceiveBytes int64 `json:"receiveBytes"`
+	// TransmitBytes is the number of bytes sent to the peer
+	TransmitBytes int64 `json:"transmitBytes"`
+}
+
+// Connected returns true if the peer completed a handshake within HandshakeTimeout before now.
+func (s PeerStats) Connected(now time.Time) bool {
+	return !s.LastHandshakeTime.IsZero() && now.Sub(s.LastHandshakeTime) < HandshakeTimeout
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeerStatsConnected(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name              string
+		lastHandshakeTime time.Time
+		expected          bool
+	}{
+		{name: "no handshake", expected: false},
+		{name: "recent handshake", lastHandshakeTime: now.Add(-2 * time.Minute), expected: true},
+		{name: "expired handshake", lastHandshakeTime: now.Add(-HandshakeTimeout), expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := PeerStats{LastHandshakeTime: test.lastHandshakeTime}
+			if connected := stats.Connected(now); connected != test.expected {
+				t.Errorf("got %v, want %v", connected, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWireguardConversion(t *testing.T) {
+	tests := []struct {
+		name string
+		spec WireguardSpec
+		// the MTU of the v1beta1 Wireguard
+		mtu int32
+	}{
+		{name: "numeric mtu", spec: WireguardSpec{Mtu: "1420", NodePort: 31820, ServiceType: corev1.ServiceTypeNodePort}, mtu: 1420},
+		{name: "empty mtu", spec: WireguardSpec{Dns: "10.96.0.10", DnsSearchDomains: []string{"default.svc.cluster.local"}}},
+		{name: "invalid mtu", spec: WireguardSpec{Mtu: "auto"}},
+		{name: "padded mtu", spec: WireguardSpec{Mtu: "01420"}},
+		{name: "key rotation", spec: WireguardSpec{KeyRotation: &KeyRotation{RotationPeriod: &metav1.Duration{Duration: 1}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wireguard := &Wireguard{
+				ObjectMeta: metav1.ObjectMeta{Name: "vpn", Namespace: "default"},
+				Spec:       test.spec,
+				Status: WireguardStatus{
+					Address:      "1.2.3.4",
+					Ipv6Address:  "2001:db8::1",
+					Port:         "31820",
+					Status:       Ready,
+					PublicKey:    "key",
+					KeyRotations: []KeyRotationRecord{{PublicKey: "key", Reason: KeyRotationRequested}},
+				},
+			}
+
+			hub := &v1beta1.Wireguard{}
+			if err := wireguard.DeepCopy().ConvertTo(hub); err != nil {
+				t.Fatal(err)
+			}
+
+			if hub.Spec.Mtu != test.mtu {
+				t.Errorf("got mtu %d, want %d", hub.Spec.Mtu, test.mtu)
+			}
+
+			if hub.Spec.NodePort != test.spec.NodePort || hub.Status.Port != 31820 || hub.Status.Status != v1beta1.Ready {
+				t.Errorf("got spec %+v and status %+v", hub.Spec, hub.Status)
+			}
+
+			converted := &Wireguard{}
+			if err := converted.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(converted, wireguard) {
+				t.Errorf("got %+v, want %+v", converted, wireguard)
+			}
+		})
+	}
+}
+
+func TestWireguardPeerConversion(t *testing.T) {
+	tests := []struct {
+		name string
+		spec WireguardPeerSpec
+		// the AllowedIPs of the v1beta1 peer
+		allowedIPs []string
+	}{
+		{name: "empty", spec: WireguardPeerSpec{WireguardRef: "vpn"}},
+		{
+			name: "all fields",
+			spec: WireguardPeerSpec{
+				WireguardRef:      "vpn",
+				Address:           "10.8.0.2",
+				AllowedIPs:        "0.0.0.0/0, ::/0",
+				Mtu:               "1280",
+				PresharedKey:      &PresharedKey{RotationPeriod: &metav1.Duration{Duration: 1}},
+				KeyRotationPeriod: &metav1.Duration{Duration: 2},
+				ValidFor:          &metav1.Duration{Duration: 3},
+				ExpiryPolicy:      ExpiryPolicyDelete,
+				AccessSchedule:    &AccessSchedule{TimeZone: "Europe/Berlin", Windows: []AccessWindow{{Start: "0 9 * * 1-5", Duration: metav1.Duration{Duration: 8}}}},
+				DownloadSpeed:     Speed{Value: 10, Unit: "mbps"},
+				EgressNetworkPolicies: EgressNetworkPolicies{
+					{Action: EgressNetworkPolicyActionAccept, Protocol: EgressNetworkPolicyProtocolTCP, To: EgressNetworkPolicyTo{Ip: "10.0.0.1", Port: 443}},
+				},
+				IngressNetworkPolicies: IngressNetworkPolicies{
+					{Action: EgressNetworkPolicyActionDeny, From: IngressNetworkPolicyFrom{Ips: []string{"10.8.0.3"}}, To: IngressNetworkPolicyTo{Ports: []int32{22}}},
+				},
+			},
+			allowedIPs: []string{"0.0.0.0/0", "::/0"},
+		},
+		{name: "unformatted allowed IPs", spec: WireguardPeerSpec{WireguardRef: "vpn", AllowedIPs: "0.0.0.0/0,::/0"}, allowedIPs: []string{"0.0.0.0/0", "::/0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer := &WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{Name: "peer", Namespace: "default", Labels: map[string]string{"team": "a"}},
+				Spec:       test.spec,
+				Status:     WireguardPeerStatus{Status: Pending, Message: "Waiting for the Wireguard", ServerPublicKey: "key", KeyRotationRequest: "1"},
+			}
+
+			hub := &v1beta1.WireguardPeer{}
+			if err := peer.DeepCopy().ConvertTo(hub); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(hub.Spec.AllowedIPs, test.allowedIPs) {
+				t.Errorf("got allowed IPs %v, want %v", hub.Spec.AllowedIPs, test.allowedIPs)
+			}
+
+			converted := &WireguardPeer{}
+			if err := converted.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(converted, peer) {
+				t.Errorf("got %+v, want %+v", converted, peer)
+			}
+		})
+	}
+}
+
+func TestWireguardPeerConversionFromHub(t *testing.T) {
+	hub := &v1beta1.WireguardPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "peer",
+			Annotations: map[string]string{AllowedIPsAnnotation: "0.0.0.0/0,::/0", MtuAnnotation: "auto"},
+		},
+		Spec: v1beta1.WireguardPeerSpec{WireguardRef: "vpn", AllowedIPs: []string{"10.0.0.0/8"}, Mtu: 1380},
+	}
+
+	peer := &WireguardPeer{}
+	if err := peer.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+
+	// the annotations are outdated once the v1beta1 fields were changed
+	want := WireguardPeerSpec{WireguardRef: "vpn", AllowedIPs: "10.0.0.0/8", Mtu: "1380"}
+	if !reflect.DeepEqual(peer.Spec, want) {
+		t.Errorf("got %+v, want %+v", peer.Spec, want)
+	}
+
+	if peer.Annotations != nil {
+		t.Errorf("got annotations %v, want none", peer.Annotations)
+	}
+}
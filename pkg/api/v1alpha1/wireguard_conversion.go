@@ -0,0 +1,175 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// MtuAnnotation keeps a v1alpha1 MTU that is not a plain number on the v1beta1 object, so that it survives the
+// conversion back to v1alpha1.
+const MtuAnnotation = "vpn.wireguard-operator.io/v1alpha1-mtu"
+
+// ConvertTo converts the Wireguard to the v1beta1 hub version.
+func (src *Wireguard) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.Wireguard)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 Wireguard but got a %T", dstRaw)
+	}
+
+	in := src.DeepCopy()
+	dst.ObjectMeta = in.ObjectMeta
+	dst.Spec = v1beta1.WireguardSpec{
+		Mtu:                          convertMtuTo(&dst.ObjectMeta, in.Spec.Mtu),
+		Address:                      in.Spec.Address,
+		Dns:                          in.Spec.Dns,
+		DnsSearchDomains:             in.Spec.DnsSearchDomains,
+		ServiceType:                  in.Spec.ServiceType,
+		NodePort:                     in.Spec.NodePort,
+		ListenPort:                   in.Spec.ListenPort,
+		ServiceAnnotations:           in.Spec.ServiceAnnotations,
+		EnableIpForwardOnPodInit:     in.Spec.EnableIpForwardOnPodInit,
+		UseWgUserspaceImplementation: in.Spec.UseWgUserspaceImplementation,
+		Network:                      v1beta1.WireguardNetwork(in.Spec.Network),
+		KeyRotation:                  (*v1beta1.KeyRotation)(in.Spec.KeyRotation),
+		PeerIsolation:                in.Spec.PeerIsolation,
+		NodeSelector:                 in.Spec.NodeSelector,
+		Agent:                        v1beta1.WireguardPodSpec(in.Spec.Agent),
+		Metric:                       v1beta1.WireguardPodSpec(in.Spec.Metric),
+	}
+
+	// the port is always written as a number by the operator
+	port, _ := strconv.Atoi(in.Status.Port)
+	dst.Status = v1beta1.WireguardStatus{
+		Address:             in.Status.Address,
+		Ipv6Address:         in.Status.Ipv6Address,
+		Dns:                 in.Status.Dns,
+		Port:                int32(port),
+		Status:              v1beta1.Phase(in.Status.Status),
+		Message:             in.Status.Message,
+		PublicKey:           in.Status.PublicKey,
+		NextPublicKey:       in.Status.NextPublicKey,
+		KeySwitchTime:       in.Status.KeySwitchTime,
+		KeyLastRotationTime: in.Status.KeyLastRotationTime,
+		KeyRotationRequest:  in.Status.KeyRotationRequest,
+		ObservedGeneration:  in.Status.ObservedGeneration,
+		Conditions:          in.Status.Conditions,
+	}
+	for _, rotation := range in.Status.KeyRotations {
+		dst.Status.KeyRotations = append(dst.Status.KeyRotations, v1beta1.KeyRotationRecord(rotation))
+	}
+
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 hub version to the Wireguard.
+func (dst *Wireguard) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.Wireguard)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 Wireguard but got a %T", srcRaw)
+	}
+
+	in := src.DeepCopy()
+	dst.ObjectMeta = in.ObjectMeta
+	dst.Spec = WireguardSpec{
+		Mtu:                          convertMtuFrom(&dst.ObjectMeta, in.Spec.Mtu),
+		Address:                      in.Spec.Address,
+		Dns:                          in.Spec.Dns,
+		DnsSearchDomains:             in.Spec.DnsSearchDomains,
+		ServiceType:                  in.Spec.ServiceType,
+		NodePort:                     in.Spec.NodePort,
+		ListenPort:                   in.Spec.ListenPort,
+		ServiceAnnotations:           in.Spec.ServiceAnnotations,
+		EnableIpForwardOnPodInit:     in.Spec.EnableIpForwardOnPodInit,
+		UseWgUserspaceImplementation: in.Spec.UseWgUserspaceImplementation,
+		Network:                      WireguardNetwork(in.Spec.Network),
+		KeyRotation:                  (*KeyRotation)(in.Spec.KeyRotation),
+		PeerIsolation:                in.Spec.PeerIsolation,
+		NodeSelector:                 in.Spec.NodeSelector,
+		Agent:                        WireguardPodSpec(in.Spec.Agent),
+		Metric:                       WireguardPodSpec(in.Spec.Metric),
+	}
+
+	port := ""
+	if in.Status.Port != 0 {
+		port = strconv.Itoa(int(in.Status.Port))
+	}
+	dst.Status = WireguardStatus{
+		Address:             in.Status.Address,
+		Ipv6Address:         in.Status.Ipv6Address,
+		Dns:                 in.Status.Dns,
+		Port:                port,
+		Status:              string(in.Status.Status),
+		Message:             in.Status.Message,
+		PublicKey:           in.Status.PublicKey,
+		NextPublicKey:       in.Status.NextPublicKey,
+		KeySwitchTime:       in.Status.KeySwitchTime,
+		KeyLastRotationTime: in.Status.KeyLastRotationTime,
+		KeyRotationRequest:  in.Status.KeyRotationRequest,
+		ObservedGeneration:  in.Status.ObservedGeneration,
+		Conditions:          in.Status.Conditions,
+	}
+	for _, rotation := range in.Status.KeyRotations {
+		dst.Status.KeyRotations = append(dst.Status.KeyRotations, KeyRotationRecord(rotation))
+	}
+
+	return nil
+}
+
+// convertMtuTo converts a v1alpha1 MTU to a number. MTUs that are not plain numbers are kept in MtuAnnotation.
+func convertMtuTo(meta *metav1.ObjectMeta, mtu string) int32 {
+	if mtu == "" {
+		return 0
+	}
+
+	value, err := strconv.ParseInt(mtu, 10, 32)
+	if err != nil || strconv.FormatInt(value, 10) != mtu {
+		metav1.SetMetaDataAnnotation(meta, MtuAnnotation, mtu)
+		return 0
+	}
+
+	return int32(value)
+}
+
+// convertMtuFrom converts a v1beta1 MTU to a string, restoring the value kept in MtuAnnotation if the MTU was not
+// set since.
+func convertMtuFrom(meta *metav1.ObjectMeta, mtu int32) string {
+	original, ok := meta.Annotations[MtuAnnotation]
+	removeAnnotation(meta, MtuAnnotation)
+
+	if mtu == 0 {
+		if ok {
+			return original
+		}
+		return ""
+	}
+
+	return strconv.Itoa(int(mtu))
+}
+
+// removeAnnotation removes an annotation used to keep v1alpha1 values on v1beta1 objects.
+func removeAnnotation(meta *metav1.ObjectMeta, annotation string) {
+	delete(meta.Annotations, annotation)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WireguardNetworkPolicySpec defines the desired state of WireguardNetworkPolicy
+type WireguardNetworkPolicySpec struct {
+	// Selects the peers in the namespace of the policy by their labels. An empty selector selects all peers of the namespace.
+	PeerSelector metav1.LabelSelector `json:"peerSelector,omitempty"`
+	// The precedence of the policy. The rules of a peer come first, followed by the rules of the policies selecting it in ascending order of priority and then name. The first rule matching the traffic applies.
+	Priority int32 `json:"priority,omitempty"`
+	// Egress network policies for the selected peers.
+	Egress EgressNetworkPolicies `json:"egress,omitempty"`
+	// Ingress network policies for the selected peers.
+	Ingress IngressNetworkPolicies `json:"ingress,omitempty"`
+}
+
+// WireguardNetworkPolicyStatus defines the observed state of WireguardNetworkPolicy
+type WireguardNetworkPolicyStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// WireguardNetworkPolicy is the Schema for the wireguardnetworkpolicies API
+type WireguardNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireguardNetworkPolicySpec   `json:"spec,omitempty"`
+	Status WireguardNetworkPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WireguardNetworkPolicyList contains a list of WireguardNetworkPolicy
+type WireguardNetworkPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WireguardNetworkPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WireguardNetworkPolicy{}, &WireguardNetworkPolicyList{})
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// AllowedIPsAnnotation keeps the v1alpha1 AllowedIPs of a peer on the v1beta1 object when splitting and joining them
+// again would change their formatting, e.g. "0.0.0.0/0,::/0".
+const AllowedIPsAnnotation = "vpn.wireguard-operator.io/v1alpha1-allowed-ips"
+
+// ConvertTo converts the WireguardPeer to the v1beta1 hub version.
+func (src *WireguardPeer) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.WireguardPeer)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 WireguardPeer but got a %T", dstRaw)
+	}
+
+	in := src.DeepCopy()
+	dst.ObjectMeta = in.ObjectMeta
+
+	allowedIPs := SplitAllowedIPs(in.Spec.AllowedIPs)
+	if strings.Join(allowedIPs, ", ") != in.Spec.AllowedIPs {
+		metav1.SetMetaDataAnnotation(&dst.ObjectMeta, AllowedIPsAnnotation, in.Spec.AllowedIPs)
+	}
+
+	dst.Spec = v1beta1.WireguardPeerSpec{
+		Address:                in.Spec.Address,
+		Ipv6Address:            in.Spec.Ipv6Address,
+		AllowedIPs:             allowedIPs,
+		Disabled:               in.Spec.Disabled,
+		ExpiresAt:              in.Spec.ExpiresAt,
+		ValidFor:               in.Spec.ValidFor,
+		ExpiryPolicy:           v1beta1.ExpiryPolicy(in.Spec.ExpiryPolicy),
+		AccessSchedule:         convertAccessScheduleTo(in.Spec.AccessSchedule),
+		Dns:                    in.Spec.Dns,
+		DnsSearchDomains:       in.Spec.DnsSearchDomains,
+		Mtu:                    convertMtuTo(&dst.ObjectMeta, in.Spec.Mtu),
+		Endpoint:               in.Spec.Endpoint,
+		RoutedSubnets:          in.Spec.RoutedSubnets,
+		StaticEndpoint:         in.Spec.StaticEndpoint,
+		PersistentKeepalive:    in.Spec.PersistentKeepalive,
+		PrivateKey:             v1beta1.PrivateKey(in.Spec.PrivateKey),
+		OmitPrivateKey:         in.Spec.OmitPrivateKey,
+		PublicKey:              in.Spec.PublicKey,
+		KeyRotationPeriod:      in.Spec.KeyRotationPeriod,
+		PresharedKey:           (*v1beta1.PresharedKey)(in.Spec.PresharedKey),
+		WireguardRef:           in.Spec.WireguardRef,
+		EgressNetworkPolicies:  convertEgressNetworkPoliciesTo(in.Spec.EgressNetworkPolicies),
+		IngressNetworkPolicies: convertIngressNetworkPoliciesTo(in.Spec.IngressNetworkPolicies),
+		DownloadSpeed:          v1beta1.Speed{Value: int32(in.Spec.DownloadSpeed.Value), Unit: v1beta1.SpeedUnit(in.Spec.DownloadSpeed.Unit)},
+		UploadSpeed:            v1beta1.Speed{Value: int32(in.Spec.UploadSpeed.Value), Unit: v1beta1.SpeedUnit(in.Spec.UploadSpeed.Unit)},
+	}
+
+	dst.Status = v1beta1.WireguardPeerStatus{
+		ConfigRef:                    in.Status.ConfigRef,
+		NextConfigRef:                in.Status.NextConfigRef,
+		PresharedKeyLastRotationTime: in.Status.PresharedKeyLastRotationTime,
+		PresharedKeySwitchTime:       in.Status.PresharedKeySwitchTime,
+		KeyLastRotationTime:          in.Status.KeyLastRotationTime,
+		KeyNextRotationTime:          in.Status.KeyNextRotationTime,
+		KeyRotationRequest:           in.Status.KeyRotationRequest,
+		ExpiryTime:                   in.Status.ExpiryTime,
+		ExpiryWarningTime:            in.Status.ExpiryWarningTime,
+		AppliedNetworkPolicies:       in.Status.AppliedNetworkPolicies,
+		LastHandshake:                in.Status.LastHandshake,
+		RxBytes:                      in.Status.RxBytes,
+		TxBytes:                      in.Status.TxBytes,
+		Endpoint:                     in.Status.Endpoint,
+		Connected:                    in.Status.Connected,
+		ServerPublicKey:              in.Status.ServerPublicKey,
+		Status:                       v1beta1.Phase(in.Status.Status),
+		Message:                      in.Status.Message,
+		ObservedGeneration:           in.Status.ObservedGeneration,
+		Conditions:                   in.Status.Conditions,
+	}
+
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 hub version to the WireguardPeer.
+func (dst *WireguardPeer) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.WireguardPeer)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 WireguardPeer but got a %T", srcRaw)
+	}
+
+	in := src.DeepCopy()
+	dst.ObjectMeta = in.ObjectMeta
+
+	allowedIPs := strings.Join(in.Spec.AllowedIPs, ", ")
+	if original, ok := dst.Annotations[AllowedIPsAnnotation]; ok && reflect.DeepEqual(SplitAllowedIPs(original), in.Spec.AllowedIPs) {
+		allowedIPs = original
+	}
+	removeAnnotation(&dst.ObjectMeta, AllowedIPsAnnotation)
+
+	dst.Spec = WireguardPeerSpec{
+		Address:                in.Spec.Address,
+		Ipv6Address:            in.Spec.Ipv6Address,
+		AllowedIPs:             allowedIPs,
+		Disabled:               in.Spec.Disabled,
+		ExpiresAt:              in.Spec.ExpiresAt,
+		ValidFor:               in.Spec.ValidFor,
+		ExpiryPolicy:           ExpiryPolicy(in.Spec.ExpiryPolicy),
+		AccessSchedule:         convertAccessScheduleFrom(in.Spec.AccessSchedule),
+		Dns:                    in.Spec.Dns,
+		DnsSearchDomains:       in.Spec.DnsSearchDomains,
+		Mtu:                    convertMtuFrom(&dst.ObjectMeta, in.Spec.Mtu),
+		Endpoint:               in.Spec.Endpoint,
+		RoutedSubnets:          in.Spec.RoutedSubnets,
+		StaticEndpoint:         in.Spec.StaticEndpoint,
+		PersistentKeepalive:    in.Spec.PersistentKeepalive,
+		PrivateKey:             PrivateKey(in.Spec.PrivateKey),
+		OmitPrivateKey:         in.Spec.OmitPrivateKey,
+		PublicKey:              in.Spec.PublicKey,
+		KeyRotationPeriod:      in.Spec.KeyRotationPeriod,
+		PresharedKey:           (*PresharedKey)(in.Spec.PresharedKey),
+		WireguardRef:           in.Spec.WireguardRef,
+		EgressNetworkPolicies:  convertEgressNetworkPoliciesFrom(in.Spec.EgressNetworkPolicies),
+		IngressNetworkPolicies: convertIngressNetworkPoliciesFrom(in.Spec.IngressNetworkPolicies),
+		DownloadSpeed:          Speed{Value: int(in.Spec.DownloadSpeed.Value), Unit: string(in.Spec.DownloadSpeed.Unit)},
+		UploadSpeed:            Speed{Value: int(in.Spec.UploadSpeed.Value), Unit: string(in.Spec.UploadSpeed.Unit)},
+	}
+
+	dst.Status = WireguardPeerStatus{
+		ConfigRef:                    in.Status.ConfigRef,
+		NextConfigRef:                in.Status.NextConfigRef,
+		PresharedKeyLastRotationTime: in.Status.PresharedKeyLastRotationTime,
+		PresharedKeySwitchTime:       in.Status.PresharedKeySwitchTime,
+		KeyLastRotationTime:          in.Status.KeyLastRotationTime,
+		KeyNextRotationTime:          in.Status.KeyNextRotationTime,
+		KeyRotationRequest:           in.Status.KeyRotationRequest,
+		ExpiryTime:                   in.Status.ExpiryTime,
+		ExpiryWarningTime:            in.Status.ExpiryWarningTime,
+		AppliedNetworkPolicies:       in.Status.AppliedNetworkPolicies,
+		LastHandshake:                in.Status.LastHandshake,
+		RxBytes:                      in.Status.RxBytes,
+		TxBytes:                      in.Status.TxBytes,
+		Endpoint:                     in.Status.Endpoint,
+		Connected:                    in.Status.Connected,
+		ServerPublicKey:              in.Status.ServerPublicKey,
+		Status:                       string(in.Status.Status),
+		Message:                      in.Status.Message,
+		ObservedGeneration:           in.Status.ObservedGeneration,
+		Conditions:                   in.Status.Conditions,
+	}
+
+	return nil
+}
+
+// SplitAllowedIPs splits comma separated AllowedIPs into a list of addresses and CIDRs.
+func SplitAllowedIPs(allowedIPs string) []string {
+	var ips []string
+	for _, ip := range strings.Split(allowedIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+func convertAccessScheduleTo(schedule *AccessSchedule) *v1beta1.AccessSchedule {
+	if schedule == nil {
+		return nil
+	}
+
+	converted := &v1beta1.AccessSchedule{TimeZone: schedule.TimeZone}
+	for _, window := range schedule.Windows {
+		converted.Windows = append(converted.Windows, v1beta1.AccessWindow(window))
+	}
+
+	return converted
+}
+
+func convertAccessScheduleFrom(schedule *v1beta1.AccessSchedule) *AccessSchedule {
+	if schedule == nil {
+		return nil
+	}
+
+	converted := &AccessSchedule{TimeZone: schedule.TimeZone}
+	for _, window := range schedule.Windows {
+		converted.Windows = append(converted.Windows, AccessWindow(window))
+	}
+
+	return converted
+}
+
+func convertEgressNetworkPoliciesTo(policies EgressNetworkPolicies) v1beta1.EgressNetworkPolicies {
+	if policies == nil {
+		return nil
+	}
+
+	converted := make(v1beta1.EgressNetworkPolicies, len(policies))
+	for i, policy := range policies {
+		converted[i] = v1beta1.EgressNetworkPolicy{
+			Action:   v1beta1.EgressNetworkPolicyAction(policy.Action),
+			To:       v1beta1.EgressNetworkPolicyTo(policy.To),
+			Protocol: v1beta1.EgressNetworkPolicyProtocol(policy.Protocol),
+			IcmpType: policy.IcmpType,
+		}
+	}
+
+	return converted
+}
+
+func convertEgressNetworkPoliciesFrom(policies v1beta1.EgressNetworkPolicies) EgressNetworkPolicies {
+	if policies == nil {
+		return nil
+	}
+
+	converted := make(EgressNetworkPolicies, len(policies))
+	for i, policy := range policies {
+		converted[i] = EgressNetworkPolicy{
+			Action:   EgressNetworkPolicyAction(policy.Action),
+			To:       EgressNetworkPolicyTo(policy.To),
+			Protocol: EgressNetworkPolicyProtocol(policy.Protocol),
+			IcmpType: policy.IcmpType,
+		}
+	}
+
+	return converted
+}
+
+func convertIngressNetworkPoliciesTo(policies IngressNetworkPolicies) v1beta1.IngressNetworkPolicies {
+	if policies == nil {
+		return nil
+	}
+
+	converted := make(v1beta1.IngressNetworkPolicies, len(policies))
+	for i, policy := range policies {
+		converted[i] = v1beta1.IngressNetworkPolicy{
+			Action:   v1beta1.EgressNetworkPolicyAction(policy.Action),
+			From:     v1beta1.IngressNetworkPolicyFrom(policy.From),
+			To:       v1beta1.IngressNetworkPolicyTo(policy.To),
+			Protocol: v1beta1.EgressNetworkPolicyProtocol(policy.Protocol),
+			IcmpType: policy.IcmpType,
+		}
+	}
+
+	return converted
+}
+
+func convertIngressNetworkPoliciesFrom(policies v1beta1.IngressNetworkPolicies) IngressNetworkPolicies {
+	if policies == nil {
+		return nil
+	}
+
+	converted := make(IngressNetworkPolicies, len(policies))
+	for i, policy := range policies {
+		converted[i] = IngressNetworkPolicy{
+			Action:   EgressNetworkPolicyAction(policy.Action),
+			From:     IngressNetworkPolicyFrom(policy.From),
+			To:       IngressNetworkPolicyTo(policy.To),
+			Protocol: EgressNetworkPolicyProtocol(policy.Protocol),
+			IcmpType: policy.IcmpType,
+		}
+	}
+
+	return converted
+}
@@ -25,6 +25,15 @@ type PrivateKey struct {
 	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
 }
 
+type PresharedKey struct {
+	// A reference to the secret key holding the preshared key. If left empty, a preshared key is generated into the peer secret.
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// How often a generated preshared key is rotated, e.g. 720h. Preshared keys provided through secretKeyRef are never rotated.
+	RotationPeriod *metav1.Duration `json:"rotationPeriod,omitempty"`
+	// How long the configurations of both the current and the next preshared key are exposed before the server switches to the next one. Defaults to 24h.
+	RotationGracePeriod *metav1.Duration `json:"rotationGracePeriod,omitempty"`
+}
+
 type Status struct {
 }
 
@@ -37,26 +46,84 @@ type WireguardPeerSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	// The address of the peer.
 	Address string `json:"address,omitempty"`
+	// The IPv6 address of the peer. Only used when the Wireguard instance is dual-stack.
+	Ipv6Address string `json:"ipv6Address,omitempty"`
 	// The AllowedIPs of the peer.
 	AllowedIPs string `json:"allowedIPs,omitempty"`
 	// Set to true to temporarily disable the peer.
 	Disabled bool `json:"disabled,omitempty"`
-	// The DNS configuration for the peer.
+	// The time the peer expires at. The peer is then disabled or deleted according to expiryPolicy.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// How long the peer is valid after its creation, e.g. 168h. The peer expires at the earlier of expiresAt and the end of validFor.
+	ValidFor *metav1.Duration `json:"validFor,omitempty"`
+	// What happens to the peer once it expired: Disable sets disabled to true, Delete deletes the peer. Defaults to Disable.
+	ExpiryPolicy ExpiryPolicy `json:"expiryPolicy,omitempty"`
+	// Restricts the times the peer can connect to recurring access windows, e.g. business hours. The peer is disabled on the wg server outside of its access windows.
+	AccessSchedule *AccessSchedule `json:"accessSchedule,omitempty"`
+	// The DNS configuration for the peer. Overrides the DNS server(s) of the Wireguard instance.
 	Dns string `json:"dns,omitempty"`
-	// The private key of the peer
+	// The DNS search domains for the peer. Overrides the search domain of the Wireguard instance.
+	DnsSearchDomains []string `json:"dnsSearchDomains,omitempty"`
+	// The maximum transmission unit (MTU) size for the peer. Overrides the MTU of the Wireguard instance.
+	Mtu string `json:"mtu,omitempty"`
+	// The endpoint the peer uses to reach the wg server, as host or host:port. Overrides the address and port of the Wireguard instance, e.g. to use an internal hostname.
+	Endpoint string `json:"endpoint,omitempty"`
+	// The CIDRs of the subnets routed through the peer, e.g. the LAN of a branch office router. They are added to the AllowedIPs of the peer on the wg server, routed via the wg interface and excluded from NAT.
+	RoutedSubnets []string `json:"routedSubnets,omitempty"`
+	// A static host:port endpoint of the peer. Allows the wg server to initiate the connection to peers with a fixed address, e.g. office routers.
+	StaticEndpoint string `json:"staticEndpoint,omitempty"`
+	// The interval in seconds at which keepalive packets are sent between the peer and the wg server. Useful for peers behind NAT. Disabled if not set.
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=65535
+	PersistentKeepalive int32 `json:"persistentKeepalive,omitempty"`
+	// A reference to the secret key holding the private key of the peer. Set it to a secret of your own, e.g. synced from Vault, to let the operator derive the public key from it instead of generating the keys into the peer secret.
 	PrivateKey PrivateKey `json:"privateKeyRef,omitempty"`
+	// Set to true to leave the private key out of the rendered configuration. The peer then has to add it to the configuration itself.
+	OmitPrivateKey bool `json:"omitPrivateKey,omitempty"`
 	// The key used by the peer to authenticate with the wg server.
 	PublicKey string `json:"publicKey,omitempty"`
+	// How often the private key of the peer is rotated, e.g. 2160h, in addition to the rotations requested through the rotate-key annotation. The peer has to fetch its new configuration after every rotation. Keys provided by the user are never rotated.
+	KeyRotationPeriod *metav1.Duration `json:"keyRotationPeriod,omitempty"`
+	// The preshared key of the peer. Set to {} to let the operator generate one.
+	PresharedKey *PresharedKey `json:"presharedKey,omitempty"`
 	// The name of the Wireguard instance in k8s that the peer belongs to. The wg instance should be in the same namespace as the peer.
 	//+kubebuilder:validation:Required
 	//+kubebuilder:validation:MinLength=1
 	WireguardRef string `json:"wireguardRef"`
 	// Egress network policies for the peer.
 	EgressNetworkPolicies EgressNetworkPolicies `json:"egressNetworkPolicies,omitempty"`
-	DownloadSpeed         Speed                 `json:"downloadSpeed,omitempty"`
-	UploadSpeed           Speed                 `json:"uploadSpeed,omitempty"`
+	// Ingress network policies for the peer. They control the traffic sent to the peer by the cluster and by other peers.
+	IngressNetworkPolicies IngressNetworkPolicies `json:"ingressNetworkPolicies,omitempty"`
+	DownloadSpeed          Speed                  `json:"downloadSpeed,omitempty"`
+	UploadSpeed            Speed                  `json:"uploadSpeed,omitempty"`
+}
+
+type AccessSchedule struct {
+	// The IANA time zone the access windows are evaluated in, e.g. Europe/Berlin. Defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+	// The access windows of the peer. The peer can connect while any of them is open.
+	//+kubebuilder:validation:MinItems=1
+	Windows []AccessWindow `json:"windows"`
 }
 
+type AccessWindow struct {
+	// A standard cron expression for the times the window opens, e.g. "0 9 * * 1-5" for 9:00 on weekdays.
+	//+kubebuilder:validation:MinLength=1
+	Start string `json:"start"`
+	// How long the window stays open after each start, e.g. 8h.
+	Duration metav1.Duration `json:"duration"`
+}
+
+// +kubebuilder:validation:Enum=Disable;Delete
+type ExpiryPolicy string
+
+const (
+	// ExpiryPolicyDisable disables expired peers.
+	ExpiryPolicyDisable ExpiryPolicy = "Disable"
+	// ExpiryPolicyDelete deletes expired peers.
+	ExpiryPolicyDelete ExpiryPolicy = "Delete"
+)
+
 type EgressNetworkPolicies []EgressNetworkPolicy
 
 // +kubebuilder:validation:Enum=ACCEPT;REJECT;Accept;Reject
@@ -71,36 +138,116 @@ const (
 )
 
 const (
-	EgressNetworkPolicyProtocolTCP EgressNetworkPolicyProtocol = "TCP"
-	EgressNetworkPolicyProtocolUDP EgressNetworkPolicyProtocol = "UDP"
+	EgressNetworkPolicyProtocolTCP  EgressNetworkPolicyProtocol = "TCP"
+	EgressNetworkPolicyProtocolUDP  EgressNetworkPolicyProtocol = "UDP"
+	EgressNetworkPolicyProtocolICMP EgressNetworkPolicyProtocol = "ICMP"
 )
 
 type EgressNetworkPolicy struct {
-	// Specifies the action to take when outgoing traffic from a Wireguard peer matches the policy. This could be 'Accept' or 'Reject'.
+	// Specifies the action to take when outgoing traffic from a Wireguard peer matches the policy. This could be 'Accept' or 'Reject', policies without action reject the traffic.
 	Action EgressNetworkPolicyAction `json:"action,omitempty"`
 	// A struct that specifies the destination address and port for the traffic. This could include IP addresses or hostnames, as well as specific port numbers or port ranges.
 	To EgressNetworkPolicyTo `json:"to,omitempty"`
 	// Specifies the protocol to match for this policy. This could be TCP, UDP, or ICMP.
 	Protocol EgressNetworkPolicyProtocol `json:"protocol,omitempty"`
+	// Specifies the ICMP type to match, by name or number, e.g. 'echo-request'. Only used with the ICMP protocol.
+	IcmpType string `json:"icmpType,omitempty"`
 }
 
 type EgressNetworkPolicyTo struct {
-	// A string field that specifies the destination IP address for traffic that matches the policy.
+	// A string field that specifies the destination IP address, CIDR or hostname for traffic that matches the policy. Hostnames are resolved when the agent applies the rules, the rules of hostnames that do not resolve are skipped.
 	Ip string `json:"ip,omitempty"`
+	// A list of destination IP addresses, CIDRs or hostnames for traffic that matches the policy, in addition to Ip.
+	Ips []string `json:"ips,omitempty"`
+	// A list of CIDRs that are excluded from the destinations of the policy.
+	Except []string `json:"except,omitempty"`
 	// An integer field that specifies the destination port number for traffic that matches the policy.
 	Port int32 `json:"port,omitempty" protobuf:"varint,3,opt,name=port"`
+	// An integer field that specifies the last port of a destination port range starting at Port.
+	EndPort int32 `json:"endPort,omitempty"`
+	// A list of destination port numbers for traffic that matches the policy, in addition to Port.
+	Ports []int32 `json:"ports,omitempty"`
+}
+
+type IngressNetworkPolicies []IngressNetworkPolicy
+
+type IngressNetworkPolicy struct {
+	// Specifies the action to take when incoming traffic to a Wireguard peer matches the policy. This could be 'Accept' or 'Reject', policies without action reject the traffic.
+	Action EgressNetworkPolicyAction `json:"action,omitempty"`
+	// A struct that specifies the source addresses of the traffic.
+	From IngressNetworkPolicyFrom `json:"from,omitempty"`
+	// A struct that specifies the destination ports on the peer.
+	To IngressNetworkPolicyTo `json:"to,omitempty"`
+	// Specifies the protocol to match for this policy. This could be TCP, UDP, or ICMP.
+	Protocol EgressNetworkPolicyProtocol `json:"protocol,omitempty"`
+	// Specifies the ICMP type to match, by name or number, e.g. 'echo-request'. Only used with the ICMP protocol.
+	IcmpType string `json:"icmpType,omitempty"`
+}
+
+type IngressNetworkPolicyFrom struct {
+	// A string field that specifies the source IP address or CIDR for traffic that matches the policy.
+	Ip string `json:"ip,omitempty"`
+	// A list of source IP addresses or CIDRs for traffic that matches the policy, in addition to Ip.
+	Ips []string `json:"ips,omitempty"`
+}
+
+type IngressNetworkPolicyTo struct {
+	// An integer field that specifies the destination port number on the peer for traffic that matches the policy.
+	Port int32 `json:"port,omitempty"`
+	// An integer field that specifies the last port of a destination port range starting at Port.
+	EndPort int32 `json:"endPort,omitempty"`
+	// A list of destination port numbers on the peer for traffic that matches the policy, in addition to Port.
+	Ports []int32 `json:"ports,omitempty"`
 }
 
 // WireguardPeerStatus defines the observed state of WireguardPeer
 type WireguardPeerStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	// A string field that contains the current configuration for the Wireguard peer.
-	Config string `json:"config,omitempty"`
+	// A reference to the secret key that contains the current wg-quick configuration file of the Wireguard peer.
+	ConfigRef *corev1.SecretKeySelector `json:"configRef,omitempty"`
+	// A reference to the secret key that contains the wg-quick configuration file of the Wireguard peer using the next preshared key. It is only set while a preshared key rotation is pending.
+	NextConfigRef *corev1.SecretKeySelector `json:"nextConfigRef,omitempty"`
+	// The time the preshared key was last rotated.
+	PresharedKeyLastRotationTime *metav1.Time `json:"presharedKeyLastRotationTime,omitempty"`
+	// The time the server switches to the next preshared key. It is only set while a preshared key rotation is pending.
+	PresharedKeySwitchTime *metav1.Time `json:"presharedKeySwitchTime,omitempty"`
+	// The time the private key of the peer was last rotated.
+	KeyLastRotationTime *metav1.Time `json:"keyLastRotationTime,omitempty"`
+	// The time the next rotation of the private key of the peer is due. It is only set if keyRotationPeriod is set.
+	KeyNextRotationTime *metav1.Time `json:"keyNextRotationTime,omitempty"`
+	// The value of the rotate-key annotation the private key of the peer was last rotated for.
+	KeyRotationRequest string `json:"keyRotationRequest,omitempty"`
+	// The time the peer expires at, according to expiresAt and validFor.
+	ExpiryTime *metav1.Time `json:"expiryTime,omitempty"`
+	// The time the warning about the upcoming expiry of the peer was recorded.
+	ExpiryWarningTime *metav1.Time `json:"expiryWarningTime,omitempty"`
+	// The names of the WireguardNetworkPolicies selecting the peer, in the order their rules are applied after the rules of the peer.
+	AppliedNetworkPolicies []string `json:"appliedNetworkPolicies,omitempty"`
+	// The time of the last handshake of the peer with the wg server.
+	LastHandshake *metav1.Time `json:"lastHandshake,omitempty"`
+	// The number of bytes the wg server received from the peer.
+	RxBytes int64 `json:"rxBytes,omitempty"`
+	// The number of bytes the wg server sent to the peer.
+	TxBytes int64 `json:"txBytes,omitempty"`
+	// The address the wg server last received traffic of the peer from.
+	Endpoint string `json:"endpoint,omitempty"`
+	// A boolean field that is true if the peer completed a handshake with the wg server within the last 180 seconds.
+	Connected bool `json:"connected,omitempty"`
+	// The public key of the wg server at the last handshake of the peer. A peer still reporting a previous key after a rotation of the server key has not picked up its new configuration.
+	ServerPublicKey string `json:"serverPublicKey,omitempty"`
 	// A string field that represents the current status of the Wireguard peer. This could include values like ready, pending, or error.
 	Status string `json:"status,omitempty"`
 	// A string field that provides additional information about the status of the Wireguard peer. This could include error messages or other information that helps to diagnose issues with the peer.
 	Message string `json:"message,omitempty"`
+	// The generation of the Wireguard peer the status was last updated for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// The conditions of the Wireguard peer: KeysGenerated and Ready.
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 type Speed struct {
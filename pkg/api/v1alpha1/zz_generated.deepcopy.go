@@ -21,15 +21,55 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessSchedule) DeepCopyInto(out *AccessSchedule) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]AccessWindow, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessSchedule.
+func (in *AccessSchedule) DeepCopy() *AccessSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessWindow) DeepCopyInto(out *AccessWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessWindow.
+func (in *AccessWindow) DeepCopy() *AccessWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in EgressNetworkPolicies) DeepCopyInto(out *EgressNetworkPolicies) {
 	{
 		in := &in
 		*out = make(EgressNetworkPolicies, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -46,7 +86,7 @@ func (in EgressNetworkPolicies) DeepCopy() EgressNetworkPolicies {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressNetworkPolicy) DeepCopyInto(out *EgressNetworkPolicy) {
 	*out = *in
-	out.To = in.To
+	in.To.DeepCopyInto(&out.To)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNetworkPolicy.
@@ -62,6 +102,21 @@ func (in *EgressNetworkPolicy) DeepCopy() *EgressNetworkPolicy {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressNetworkPolicyTo) DeepCopyInto(out *EgressNetworkPolicyTo) {
 	*out = *in
+	if in.Ips != nil {
+		in, out := &in.Ips, &out.Ips
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Except != nil {
+		in, out := &in.Except, &out.Except
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNetworkPolicyTo.
@@ -74,6 +129,155 @@ func (in *EgressNetworkPolicyTo) DeepCopy() *EgressNetworkPolicyTo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in IngressNetworkPolicies) DeepCopyInto(out *IngressNetworkPolicies) {
+	{
+		in := &in
+		*out = make(IngressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicies.
+func (in IngressNetworkPolicies) DeepCopy() IngressNetworkPolicies {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicies)
+	in.DeepCopyInto(out)
+	return *out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressNetworkPolicy) DeepCopyInto(out *IngressNetworkPolicy) {
+	*out = *in
+	in.From.DeepCopyInto(&out.From)
+	in.To.DeepCopyInto(&out.To)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicy.
+func (in *IngressNetworkPolicy) DeepCopy() *IngressNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressNetworkPolicyFrom) DeepCopyInto(out *IngressNetworkPolicyFrom) {
+	*out = *in
+	if in.Ips != nil {
+		in, out := &in.Ips, &out.Ips
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicyFrom.
+func (in *IngressNetworkPolicyFrom) DeepCopy() *IngressNetworkPolicyFrom {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicyFrom)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressNetworkPolicyTo) DeepCopyInto(out *IngressNetworkPolicyTo) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicyTo.
+func (in *IngressNetworkPolicyTo) DeepCopy() *IngressNetworkPolicyTo {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicyTo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotation) DeepCopyInto(out *KeyRotation) {
+	*out = *in
+	if in.RotationPeriod != nil {
+		in, out := &in.RotationPeriod, &out.RotationPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RotationGracePeriod != nil {
+		in, out := &in.RotationGracePeriod, &out.RotationGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotation.
+func (in *KeyRotation) DeepCopy() *KeyRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotationRecord) DeepCopyInto(out *KeyRotationRecord) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.SwitchTime != nil {
+		in, out := &in.SwitchTime, &out.SwitchTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotationRecord.
+func (in *KeyRotationRecord) DeepCopy() *KeyRotationRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotationRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PresharedKey) DeepCopyInto(out *PresharedKey) {
+	*out = *in
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+	if in.RotationPeriod != nil {
+		in, out := &in.RotationPeriod, &out.RotationPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RotationGracePeriod != nil {
+		in, out := &in.RotationGracePeriod, &out.RotationGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PresharedKey.
+func (in *PresharedKey) DeepCopy() *PresharedKey {
+	if in == nil {
+		return nil
+	}
+	out := new(PresharedKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PrivateKey) DeepCopyInto(out *PrivateKey) {
 	*out = *in
@@ -141,7 +345,7 @@ func (in *Wireguard) DeepCopyInto(out *Wireguard) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Wireguard.
@@ -195,7 +399,22 @@ func (in *WireguardList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *WireguardPeer) DeepCopyInto(out *WireguardPeer) {
+func (in *WireguardNetwork) DeepCopyInto(out *WireguardNetwork) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardNetwork.
+func (in *WireguardNetwork) DeepCopy() *WireguardNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardNetworkPolicy) DeepCopyInto(out *WireguardNetworkPolicy) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -203,6 +422,110 @@ func (in *WireguardPeer) DeepCopyInto(out *WireguardPeer) {
 	out.Status = in.Status
 }
 
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardNetworkPolicy.
+func (in *WireguardNetworkPolicy) DeepCopy() *WireguardNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardNetworkPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardNetworkPolicyList) DeepCopyInto(out *WireguardNetworkPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WireguardNetworkPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardNetworkPolicyList.
+func (in *WireguardNetworkPolicyList) DeepCopy() *WireguardNetworkPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardNetworkPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardNetworkPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardNetworkPolicySpec) DeepCopyInto(out *WireguardNetworkPolicySpec) {
+	*out = *in
+	in.PeerSelector.DeepCopyInto(&out.PeerSelector)
+	if in.Egress != nil {
+		in, out := &in.Egress, &out.Egress
+		*out = make(EgressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = make(IngressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardNetworkPolicySpec.
+func (in *WireguardNetworkPolicySpec) DeepCopy() *WireguardNetworkPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardNetworkPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardNetworkPolicyStatus) DeepCopyInto(out *WireguardNetworkPolicyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardNetworkPolicyStatus.
+func (in *WireguardNetworkPolicyStatus) DeepCopy() *WireguardNetworkPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardNetworkPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardPeer) DeepCopyInto(out *WireguardPeer) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPeer.
 func (in *WireguardPeer) DeepCopy() *WireguardPeer {
 	if in == nil {
@@ -256,11 +579,54 @@ func (in *WireguardPeerList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardPeerSpec) DeepCopyInto(out *WireguardPeerSpec) {
 	*out = *in
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.ValidFor != nil {
+		in, out := &in.ValidFor, &out.ValidFor
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.AccessSchedule != nil {
+		in, out := &in.AccessSchedule, &out.AccessSchedule
+		*out = new(AccessSchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DnsSearchDomains != nil {
+		in, out := &in.DnsSearchDomains, &out.DnsSearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RoutedSubnets != nil {
+		in, out := &in.RoutedSubnets, &out.RoutedSubnets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.PrivateKey.DeepCopyInto(&out.PrivateKey)
+	if in.KeyRotationPeriod != nil {
+		in, out := &in.KeyRotationPeriod, &out.KeyRotationPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.PresharedKey != nil {
+		in, out := &in.PresharedKey, &out.PresharedKey
+		*out = new(PresharedKey)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.EgressNetworkPolicies != nil {
 		in, out := &in.EgressNetworkPolicies, &out.EgressNetworkPolicies
 		*out = make(EgressNetworkPolicies, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IngressNetworkPolicies != nil {
+		in, out := &in.IngressNetworkPolicies, &out.IngressNetworkPolicies
+		*out = make(IngressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	out.DownloadSpeed = in.DownloadSpeed
 	out.UploadSpeed = in.UploadSpeed
@@ -279,6 +645,56 @@ func (in *WireguardPeerSpec) DeepCopy() *WireguardPeerSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardPeerStatus) DeepCopyInto(out *WireguardPeerStatus) {
 	*out = *in
+	if in.ConfigRef != nil {
+		in, out := &in.ConfigRef, &out.ConfigRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NextConfigRef != nil {
+		in, out := &in.NextConfigRef, &out.NextConfigRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PresharedKeyLastRotationTime != nil {
+		in, out := &in.PresharedKeyLastRotationTime, &out.PresharedKeyLastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PresharedKeySwitchTime != nil {
+		in, out := &in.PresharedKeySwitchTime, &out.PresharedKeySwitchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyLastRotationTime != nil {
+		in, out := &in.KeyLastRotationTime, &out.KeyLastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyNextRotationTime != nil {
+		in, out := &in.KeyNextRotationTime, &out.KeyNextRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiryTime != nil {
+		in, out := &in.ExpiryTime, &out.ExpiryTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiryWarningTime != nil {
+		in, out := &in.ExpiryWarningTime, &out.ExpiryWarningTime
+		*out = (*in).DeepCopy()
+	}
+	if in.AppliedNetworkPolicies != nil {
+		in, out := &in.AppliedNetworkPolicies, &out.AppliedNetworkPolicies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastHandshake != nil {
+		in, out := &in.LastHandshake, &out.LastHandshake
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPeerStatus.
@@ -310,6 +726,11 @@ func (in *WireguardPodSpec) DeepCopy() *WireguardPodSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardSpec) DeepCopyInto(out *WireguardSpec) {
 	*out = *in
+	if in.DnsSearchDomains != nil {
+		in, out := &in.DnsSearchDomains, &out.DnsSearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.ServiceAnnotations != nil {
 		in, out := &in.ServiceAnnotations, &out.ServiceAnnotations
 		*out = make(map[string]string, len(*in))
@@ -317,6 +738,12 @@ func (in *WireguardSpec) DeepCopyInto(out *WireguardSpec) {
 			(*out)[key] = val
 		}
 	}
+	out.Network = in.Network
+	if in.KeyRotation != nil {
+		in, out := &in.KeyRotation, &out.KeyRotation
+		*out = new(KeyRotation)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.NodeSelector != nil {
 		in, out := &in.NodeSelector, &out.NodeSelector
 		*out = make(map[string]string, len(*in))
@@ -341,6 +768,28 @@ func (in *WireguardSpec) DeepCopy() *WireguardSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardStatus) DeepCopyInto(out *WireguardStatus) {
 	*out = *in
+	if in.KeySwitchTime != nil {
+		in, out := &in.KeySwitchTime, &out.KeySwitchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyLastRotationTime != nil {
+		in, out := &in.KeyLastRotationTime, &out.KeyLastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyRotations != nil {
+		in, out := &in.KeyRotations, &out.KeyRotations
+		*out = make([]KeyRotationRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardStatus.
@@ -0,0 +1,199 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Phase is the state of a Wireguard instance or of a peer reported in its status.
+// +kubebuilder:validation:Enum=pending;error;ready
+type Phase string
+
+const (
+	Pending Phase = "pending"
+	Error   Phase = "error"
+	Ready   Phase = "ready"
+)
+
+// RotateKeyAnnotation requests a rotation of the private key of the wg server of a Wireguard, or of the private key
+// of a WireguardPeer, whenever its value changes, e.g. to the current time.
+const RotateKeyAnnotation = "vpn.wireguard-operator.io/rotate-key"
+
+// Reasons of the rotations of the private key of the wg server recorded in the status of a Wireguard.
+const (
+	// KeyRotationRequested is the reason of rotations requested through RotateKeyAnnotation.
+	KeyRotationRequested = "Requested"
+	// KeyRotationScheduled is the reason of rotations due according to KeyRotation.RotationPeriod of a Wireguard or
+	// KeyRotationPeriod of a WireguardPeer.
+	KeyRotationScheduled = "Scheduled"
+)
+
+// Condition types reported in the status of Wireguard and WireguardPeer resources.
+const (
+	// ConditionServiceReady is true once the service exposing the wg server is ready.
+	ConditionServiceReady = "ServiceReady"
+	// ConditionEndpointResolved is true once the address and port peers use to reach the wg server are known.
+	ConditionEndpointResolved = "EndpointResolved"
+	// ConditionKeysGenerated is true once the keys of the wg server or of the peer exist.
+	ConditionKeysGenerated = "KeysGenerated"
+	// ConditionAgentSynced is true once the current state of the wg server and its peers was pushed to the agent.
+	ConditionAgentSynced = "AgentSynced"
+	// ConditionReady is true once the resource is fully configured. It mirrors Status.
+	ConditionReady = "Ready"
+)
+
+// WireguardSpec defines the desired state of Wireguard
+type WireguardSpec struct {
+	// The maximum transmission unit (MTU) size for Wireguard packets for all peers.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Mtu int32 `json:"mtu,omitempty"`
+	// A string field that specifies the address for the Wireguard VPN server. This is the public IP address or hostname that peers will use to connect to the VPN.
+	Address string `json:"address,omitempty"`
+	// A string field that specifies the DNS server(s) to be used by the peers.
+	Dns string `json:"dns,omitempty"`
+	// The DNS search domains of the peers. Defaulted together with Dns when the cluster DNS is used.
+	DnsSearchDomains []string `json:"dnsSearchDomains,omitempty"`
+	// A field that specifies the type of Kubernetes service that should be used for the Wireguard VPN. This could be ClusterIP, NodePort or LoadBalancer, depending on the needs of the deployment.
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+	// The node port of the service when ServiceType is NodePort.
+	NodePort int32 `json:"nodePort,omitempty"`
+	// The UDP port the wg server listens on and the service exposes. Defaults to 51820.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="listenPort is immutable"
+	ListenPort int32 `json:"listenPort,omitempty"`
+	// A map of key value strings for service annotations
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+	// A boolean field that specifies whether IP forwarding should be enabled on the Wireguard VPN pod at startup. This can be useful to enable if the peers are having problems with sending traffic to the internet.
+	EnableIpForwardOnPodInit bool `json:"enableIpForwardOnPodInit,omitempty"`
+	// A boolean field that specifies whether to use the userspace implementation of Wireguard instead of the kernel one.
+	UseWgUserspaceImplementation bool `json:"useWgUserspaceImplementation,omitempty"`
+	// A field that specifies the tunnel network used by the Wireguard VPN server and its peers.
+	Network WireguardNetwork `json:"network,omitempty"`
+	// The rotation of the private key of the wg server.
+	KeyRotation *KeyRotation `json:"keyRotation,omitempty"`
+	// A boolean field that specifies whether peers are isolated from each other. Traffic from the tunnel network and the routed subnets of the peers to a peer is then rejected unless an ingress network policy of the peer accepts it.
+	PeerIsolation bool `json:"peerIsolation,omitempty"`
+
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Agent        WireguardPodSpec  `json:"agent,omitempty"`
+	// Deprecated: the metrics are served by the agent, whose resources are set through Agent. This field is ignored.
+	Metric WireguardPodSpec `json:"metric,omitempty"`
+}
+
+// WireguardNetwork defines the tunnel network of a Wireguard instance
+type WireguardNetwork struct {
+	// A string field that specifies the CIDR from which peer addresses are allocated. Defaults to 10.8.0.0/24.
+	Cidr string `json:"cidr,omitempty"`
+	// A string field that specifies the address of the Wireguard VPN server inside the tunnel network. Defaults to the first address of the CIDR.
+	Gateway string `json:"gateway,omitempty"`
+	// A string field that specifies the IPv6 CIDR from which peer IPv6 addresses are allocated. Setting it turns the Wireguard instance into a dual-stack one.
+	Ipv6Cidr string `json:"ipv6Cidr,omitempty"`
+	// A string field that specifies the IPv6 address of the Wireguard VPN server inside the tunnel network. Defaults to the first address of the IPv6 CIDR.
+	Ipv6Gateway string `json:"ipv6Gateway,omitempty"`
+}
+
+// KeyRotation configures the rotation of the private key of the wg server. A rotation can also be requested at any
+// time through the RotateKeyAnnotation.
+type KeyRotation struct {
+	// How often the private key of the wg server is rotated, e.g. 2160h. The key is only rotated on request if not set.
+	RotationPeriod *metav1.Duration `json:"rotationPeriod,omitempty"`
+	// How long the configurations of the peers with both the current and the next public key of the server are exposed before the server switches to the next key. Defaults to 24h.
+	RotationGracePeriod *metav1.Duration `json:"rotationGracePeriod,omitempty"`
+}
+
+// KeyRotationRecord is a rotation of the private key of the wg server.
+type KeyRotationRecord struct {
+	// The public key the wg server rotated to.
+	PublicKey string `json:"publicKey"`
+	// Why the key was rotated: Requested or Scheduled.
+	Reason string `json:"reason"`
+	// The time the next key was generated and the configurations of the peers using it were exposed.
+	StartTime metav1.Time `json:"startTime"`
+	// The time the wg server switched to the key. It is not set while the rotation is pending.
+	SwitchTime *metav1.Time `json:"switchTime,omitempty"`
+}
+
+// WireguardPodSpec defines spec for respective containers created for Wireguard
+type WireguardPodSpec struct {
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// WireguardStatus defines the observed state of Wireguard
+type WireguardStatus struct {
+	// A string field that specifies the address for the Wireguard VPN server that is currently being used.
+	Address string `json:"address,omitempty"`
+	// The IPv6 address of the load balancer of a dual-stack Wireguard instance, if the Service has one.
+	Ipv6Address string `json:"ipv6Address,omitempty"`
+	Dns         string `json:"dns,omitempty"`
+	// The port for the Wireguard VPN server that is currently being used.
+	Port int32 `json:"port,omitempty"`
+	// The current status of Wireguard: ready, pending or error.
+	Status Phase `json:"status,omitempty"`
+	// A string field that provides additional information about the status of Wireguard. This could include error messages or other information that helps to diagnose issues with the wg instance.
+	Message string `json:"message,omitempty"`
+	// The public key of the wg server.
+	PublicKey string `json:"publicKey,omitempty"`
+	// The public key the wg server switches to at KeySwitchTime. It is only set while a key rotation is pending.
+	NextPublicKey string `json:"nextPublicKey,omitempty"`
+	// The time the wg server switches to the next key. It is only set while a key rotation is pending.
+	KeySwitchTime *metav1.Time `json:"keySwitchTime,omitempty"`
+	// The time the wg server last switched to a new key.
+	KeyLastRotationTime *metav1.Time `json:"keyLastRotationTime,omitempty"`
+	// The value of the rotate-key annotation the last requested rotation was started for.
+	KeyRotationRequest string `json:"keyRotationRequest,omitempty"`
+	// The last rotations of the private key of the wg server, the most recent one last.
+	KeyRotations []KeyRotationRecord `json:"keyRotations,omitempty"`
+	// The generation of the Wireguard the status was last updated for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// The conditions of the Wireguard: ServiceReady, EndpointResolved, KeysGenerated, AgentSynced and Ready.
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+
+// Wireguard is the Schema for the wireguards API
+type Wireguard struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireguardSpec   `json:"spec,omitempty"`
+	Status WireguardStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WireguardList contains a list of Wireguard
+type WireguardList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Wireguard `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Wireguard{}, &WireguardList{})
+}
@@ -0,0 +1,674 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessSchedule) DeepCopyInto(out *AccessSchedule) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]AccessWindow, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessSchedule.
+func (in *AccessSchedule) DeepCopy() *AccessSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessWindow) DeepCopyInto(out *AccessWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessWindow.
+func (in *AccessWindow) DeepCopy() *AccessWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in EgressNetworkPolicies) DeepCopyInto(out *EgressNetworkPolicies) {
+	{
+		in := &in
+		*out = make(EgressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNetworkPolicies.
+func (in EgressNetworkPolicies) DeepCopy() EgressNetworkPolicies {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNetworkPolicies)
+	in.DeepCopyInto(out)
+	return *out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNetworkPolicy) DeepCopyInto(out *EgressNetworkPolicy) {
+	*out = *in
+	in.To.DeepCopyInto(&out.To)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNetworkPolicy.
+func (in *EgressNetworkPolicy) DeepCopy() *EgressNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNetworkPolicyTo) DeepCopyInto(out *EgressNetworkPolicyTo) {
+	*out = *in
+	if in.Ips != nil {
+		in, out := &in.Ips, &out.Ips
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Except != nil {
+		in, out := &in.Except, &out.Except
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNetworkPolicyTo.
+func (in *EgressNetworkPolicyTo) DeepCopy() *EgressNetworkPolicyTo {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNetworkPolicyTo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in IngressNetworkPolicies) DeepCopyInto(out *IngressNetworkPolicies) {
+	{
+		in := &in
+		*out = make(IngressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicies.
+func (in IngressNetworkPolicies) DeepCopy() IngressNetworkPolicies {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicies)
+	in.DeepCopyInto(out)
+	return *out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressNetworkPolicy) DeepCopyInto(out *IngressNetworkPolicy) {
+	*out = *in
+	in.From.DeepCopyInto(&out.From)
+	in.To.DeepCopyInto(&out.To)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicy.
+func (in *IngressNetworkPolicy) DeepCopy() *IngressNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressNetworkPolicyFrom) DeepCopyInto(out *IngressNetworkPolicyFrom) {
+	*out = *in
+	if in.Ips != nil {
+		in, out := &in.Ips, &out.Ips
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicyFrom.
+func (in *IngressNetworkPolicyFrom) DeepCopy() *IngressNetworkPolicyFrom {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicyFrom)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressNetworkPolicyTo) DeepCopyInto(out *IngressNetworkPolicyTo) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressNetworkPolicyTo.
+func (in *IngressNetworkPolicyTo) DeepCopy() *IngressNetworkPolicyTo {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressNetworkPolicyTo)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotation) DeepCopyInto(out *KeyRotation) {
+	*out = *in
+	if in.RotationPeriod != nil {
+		in, out := &in.RotationPeriod, &out.RotationPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RotationGracePeriod != nil {
+		in, out := &in.RotationGracePeriod, &out.RotationGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotation.
+func (in *KeyRotation) DeepCopy() *KeyRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotationRecord) DeepCopyInto(out *KeyRotationRecord) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.SwitchTime != nil {
+		in, out := &in.SwitchTime, &out.SwitchTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotationRecord.
+func (in *KeyRotationRecord) DeepCopy() *KeyRotationRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotationRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PresharedKey) DeepCopyInto(out *PresharedKey) {
+	*out = *in
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+	if in.RotationPeriod != nil {
+		in, out := &in.RotationPeriod, &out.RotationPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RotationGracePeriod != nil {
+		in, out := &in.RotationGracePeriod, &out.RotationGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PresharedKey.
+func (in *PresharedKey) DeepCopy() *PresharedKey {
+	if in == nil {
+		return nil
+	}
+	out := new(PresharedKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrivateKey) DeepCopyInto(out *PrivateKey) {
+	*out = *in
+	in.SecretKeyRef.DeepCopyInto(&out.SecretKeyRef)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrivateKey.
+func (in *PrivateKey) DeepCopy() *PrivateKey {
+	if in == nil {
+		return nil
+	}
+	out := new(PrivateKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Speed) DeepCopyInto(out *Speed) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Speed.
+func (in *Speed) DeepCopy() *Speed {
+	if in == nil {
+		return nil
+	}
+	out := new(Speed)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Wireguard) DeepCopyInto(out *Wireguard) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Wireguard.
+func (in *Wireguard) DeepCopy() *Wireguard {
+	if in == nil {
+		return nil
+	}
+	out := new(Wireguard)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Wireguard) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardList) DeepCopyInto(out *WireguardList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Wireguard, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardList.
+func (in *WireguardList) DeepCopy() *WireguardList {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardNetwork) DeepCopyInto(out *WireguardNetwork) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardNetwork.
+func (in *WireguardNetwork) DeepCopy() *WireguardNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardPeer) DeepCopyInto(out *WireguardPeer) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPeer.
+func (in *WireguardPeer) DeepCopy() *WireguardPeer {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardPeer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardPeer) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardPeerList) DeepCopyInto(out *WireguardPeerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WireguardPeer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPeerList.
+func (in *WireguardPeerList) DeepCopy() *WireguardPeerList {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardPeerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardPeerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardPeerSpec) DeepCopyInto(out *WireguardPeerSpec) {
+	*out = *in
+	if in.AllowedIPs != nil {
+		in, out := &in.AllowedIPs, &out.AllowedIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.ValidFor != nil {
+		in, out := &in.ValidFor, &out.ValidFor
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.AccessSchedule != nil {
+		in, out := &in.AccessSchedule, &out.AccessSchedule
+		*out = new(AccessSchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DnsSearchDomains != nil {
+		in, out := &in.DnsSearchDomains, &out.DnsSearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RoutedSubnets != nil {
+		in, out := &in.RoutedSubnets, &out.RoutedSubnets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.PrivateKey.DeepCopyInto(&out.PrivateKey)
+	if in.KeyRotationPeriod != nil {
+		in, out := &in.KeyRotationPeriod, &out.KeyRotationPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.PresharedKey != nil {
+		in, out := &in.PresharedKey, &out.PresharedKey
+		*out = new(PresharedKey)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EgressNetworkPolicies != nil {
+		in, out := &in.EgressNetworkPolicies, &out.EgressNetworkPolicies
+		*out = make(EgressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IngressNetworkPolicies != nil {
+		in, out := &in.IngressNetworkPolicies, &out.IngressNetworkPolicies
+		*out = make(IngressNetworkPolicies, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	out.DownloadSpeed = in.DownloadSpeed
+	out.UploadSpeed = in.UploadSpeed
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPeerSpec.
+func (in *WireguardPeerSpec) DeepCopy() *WireguardPeerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardPeerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardPeerStatus) DeepCopyInto(out *WireguardPeerStatus) {
+	*out = *in
+	if in.ConfigRef != nil {
+		in, out := &in.ConfigRef, &out.ConfigRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NextConfigRef != nil {
+		in, out := &in.NextConfigRef, &out.NextConfigRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PresharedKeyLastRotationTime != nil {
+		in, out := &in.PresharedKeyLastRotationTime, &out.PresharedKeyLastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PresharedKeySwitchTime != nil {
+		in, out := &in.PresharedKeySwitchTime, &out.PresharedKeySwitchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyLastRotationTime != nil {
+		in, out := &in.KeyLastRotationTime, &out.KeyLastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyNextRotationTime != nil {
+		in, out := &in.KeyNextRotationTime, &out.KeyNextRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiryTime != nil {
+		in, out := &in.ExpiryTime, &out.ExpiryTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiryWarningTime != nil {
+		in, out := &in.ExpiryWarningTime, &out.ExpiryWarningTime
+		*out = (*in).DeepCopy()
+	}
+	if in.AppliedNetworkPolicies != nil {
+		in, out := &in.AppliedNetworkPolicies, &out.AppliedNetworkPolicies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastHandshake != nil {
+		in, out := &in.LastHandshake, &out.LastHandshake
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPeerStatus.
+func (in *WireguardPeerStatus) DeepCopy() *WireguardPeerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardPeerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardPodSpec) DeepCopyInto(out *WireguardPodSpec) {
+	*out = *in
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardPodSpec.
+func (in *WireguardPodSpec) DeepCopy() *WireguardPodSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardPodSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardSpec) DeepCopyInto(out *WireguardSpec) {
+	*out = *in
+	if in.DnsSearchDomains != nil {
+		in, out := &in.DnsSearchDomains, &out.DnsSearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceAnnotations != nil {
+		in, out := &in.ServiceAnnotations, &out.ServiceAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.Network = in.Network
+	if in.KeyRotation != nil {
+		in, out := &in.KeyRotation, &out.KeyRotation
+		*out = new(KeyRotation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Agent.DeepCopyInto(&out.Agent)
+	in.Metric.DeepCopyInto(&out.Metric)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardSpec.
+func (in *WireguardSpec) DeepCopy() *WireguardSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardStatus) DeepCopyInto(out *WireguardStatus) {
+	*out = *in
+	if in.KeySwitchTime != nil {
+		in, out := &in.KeySwitchTime, &out.KeySwitchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyLastRotationTime != nil {
+		in, out := &in.KeyLastRotationTime, &out.KeyLastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.KeyRotations != nil {
+		in, out := &in.KeyRotations, &out.KeyRotations
+		*out = make([]KeyRotationRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardStatus.
+func (in *WireguardStatus) DeepCopy() *WireguardStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardStatus)
+	in.DeepCopyInto(out)
+	return out
+}
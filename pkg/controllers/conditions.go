@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the conditions set by the controllers.
+const (
+	reasonReady            = "Ready"
+	reasonPending          = "Pending"
+	reasonError            = "Error"
+	reasonServiceCreating  = "ServiceCreating"
+	reasonServicePending   = "ServicePending"
+	reasonServiceReady     = "ServiceReady"
+	reasonAddressUnknown   = "AddressUnknown"
+	reasonEndpointResolved = "EndpointResolved"
+	reasonKeysGenerated    = "KeysGenerated"
+	reasonKeysPending      = "KeysPending"
+	reasonStatePushed      = "StatePushed"
+)
+
+// condition returns a condition of the given type that is true or false depending on status.
+func condition(conditionType string, status bool, reason string, message string) metav1.Condition {
+	conditionStatus := metav1.ConditionFalse
+	if status {
+		conditionStatus = metav1.ConditionTrue
+	}
+
+	return metav1.Condition{Type: conditionType, Status: conditionStatus, Reason: reason, Message: message}
+}
+
+// readyCondition returns the Ready condition matching the given status and message of a Wireguard or WireguardPeer.
+func readyCondition(status string, message string) metav1.Condition {
+	switch status {
+	case v1alpha1.Ready:
+		return condition(v1alpha1.ConditionReady, true, reasonReady, message)
+	case v1alpha1.Error:
+		return condition(v1alpha1.ConditionReady, false, reasonError, message)
+	default:
+		return condition(v1alpha1.ConditionReady, false, reasonPending, message)
+	}
+}
+
+// setConditions sets conditions observed at the given generation. The transition time of a condition only changes
+// with its status.
+func setConditions(conditions *[]metav1.Condition, generation int64, newConditions ...metav1.Condition) {
+	for _, newCondition := range newConditions {
+		newCondition.ObservedGeneration = generation
+		meta.SetStatusCondition(conditions, newCondition)
+	}
+}
+
+// setPeerConditions sets the KeysGenerated and Ready conditions of the peer from its spec and status.
+func setPeerConditions(peer *v1alpha1.WireguardPeer) {
+	keysGenerated := condition(v1alpha1.ConditionKeysGenerated, true, reasonKeysGenerated, "")
+	if peer.Spec.PublicKey == "" && hasUserPrivateKey(peer) {
+		keysGenerated = condition(v1alpha1.ConditionKeysGenerated, false, reasonKeysPending, fmt.Sprintf("Waiting for the private key of the peer in secret %s", peer.Spec.PrivateKey.SecretKeyRef.Name))
+	} else if peer.Spec.PublicKey == "" {
+		keysGenerated = condition(v1alpha1.ConditionKeysGenerated, false, reasonKeysPending, "Waiting for the keys of the peer to be generated")
+	}
+
+	peer.Status.ObservedGeneration = peer.Generation
+	setConditions(&peer.Status.Conditions, peer.Generation, keysGenerated, readyCondition(peer.Status.Status, peer.Status.Message))
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"net"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	peerStateTotal    = "total"
+	peerStateReady    = "ready"
+	peerStateDisabled = "disabled"
+
+	poolStateUsed = "used"
+	poolStateFree = "free"
+)
+
+var (
+	peersMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wireguard_operator_peers",
+		Help: "Number of peers of the Wireguard. The state is total, ready or disabled, so that a peer is counted in more than one state.",
+	}, []string{"namespace", "wireguard", "state"})
+
+	poolAddressesMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wireguard_operator_pool_addresses",
+		Help: "Number of used and free addresses of the network of the Wireguard, by IP family. Reserved addresses count as used.",
+	}, []string{"namespace", "wireguard", "family", "state"})
+
+	wireguardsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wireguard_operator_wireguards",
+		Help: "Number of Wireguard instances by status.",
+	}, []string{"status"})
+
+	peerReadyDurationMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wireguard_operator_peer_ready_duration_seconds",
+		Help:    "Time from the creation of a WireguardPeer until it becomes ready.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	configUpdatesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wireguard_operator_config_updates_total",
+		Help: "Number of times the pods of the Wireguard were annotated to push a new configuration to the agent.",
+	}, []string{"namespace", "wireguard"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(peersMetric, poolAddressesMetric, wireguardsMetric, peerReadyDurationMetric, configUpdatesMetric)
+}
+
+// poolUsage returns the number of used and free addresses of network. Used addresses outside of network are ignored.
+func poolUsage(network *net.IPNet, usedIps []string) (float64, float64) {
+	used := make(map[string]bool)
+	for _, usedIp := range usedIps {
+		ip := net.ParseIP(usedIp)
+		if ip != nil && network.Contains(ip) {
+			used[ip.String()] = true
+		}
+	}
+
+	ones, bits := network.Mask.Size()
+	size := math.Pow(2, float64(bits-ones))
+
+	return float64(len(used)), size - float64(len(used))
+}
+
+// setPoolMetrics records the used and free addresses of network, the pool of the given IP family of the Wireguard.
+func setPoolMetrics(key types.NamespacedName, family string, network *net.IPNet, usedIps []string) {
+	used, free := poolUsage(network, usedIps)
+	poolAddressesMetric.WithLabelValues(key.Namespace, key.Name, family, poolStateUsed).Set(used)
+	poolAddressesMetric.WithLabelValues(key.Namespace, key.Name, family, poolStateFree).Set(free)
+}
+
+// setPeerMetrics records the number of peers of the Wireguard and how many of them are ready and disabled.
+func setPeerMetrics(key types.NamespacedName, peers []v1alpha1.WireguardPeer) {
+	ready := 0
+	disabled := 0
+	for _, peer := range peers {
+		if peer.Status.Status == v1alpha1.Ready {
+			ready++
+		}
+		if peer.Spec.Disabled {
+			disabled++
+		}
+	}
+
+	peersMetric.WithLabelValues(key.Namespace, key.Name, peerStateTotal).Set(float64(len(peers)))
+	peersMetric.WithLabelValues(key.Namespace, key.Name, peerStateReady).Set(float64(ready))
+	peersMetric.WithLabelValues(key.Namespace, key.Name, peerStateDisabled).Set(float64(disabled))
+}
+
+// observePeerReady records the time it took the peer to become ready since its creation.
+func observePeerReady(peer v1alpha1.WireguardPeer, now time.Time) {
+	peerReadyDurationMetric.Observe(now.Sub(peer.CreationTimestamp.Time).Seconds())
+}
+
+// deleteWireguardMetrics removes the metrics of the deleted Wireguard with the given key.
+func deleteWireguardMetrics(key types.NamespacedName) {
+	labels := prometheus.Labels{"namespace": key.Namespace, "wireguard": key.Name}
+	peersMetric.DeletePartialMatch(labels)
+	poolAddressesMetric.DeletePartialMatch(labels)
+	configUpdatesMetric.DeletePartialMatch(labels)
+}
+
+// updateWireguardsMetric records the number of Wireguard instances in each status. Instances without status yet are
+// counted as pending.
+func (r *WireguardReconciler) updateWireguardsMetric(ctx context.Context) error {
+	wireguards := &v1alpha1.WireguardList{}
+	if err := r.List(ctx, wireguards); err != nil {
+		return err
+	}
+
+	counts := map[string]int{v1alpha1.Pending: 0, v1alpha1.Ready: 0, v1alpha1.Error: 0}
+	for _, wireguard := range wireguards.Items {
+		status := wireguard.Status.Status
+		if status == "" {
+			status = v1alpha1.Pending
+		}
+		counts[status]++
+	}
+
+	wireguardsMetric.Reset()
+	for status, count := range counts {
+		wireguardsMetric.WithLabelValues(status).Set(float64(count))
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// storageMigrationInterval is the interval between two attempts to migrate the objects of a CRD, e.g. while the
+// conversion webhook is not reachable yet.
+const storageMigrationInterval = 30 * time.Second
+
+var crdGroupVersionKind = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,resourceNames=wireguards.vpn.wireguard-operator.io;wireguardpeers.vpn.wireguard-operator.io,verbs=get
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,resourceNames=wireguards.vpn.wireguard-operator.io;wireguardpeers.vpn.wireguard-operator.io,verbs=update
+
+// StorageVersionMigrator rewrites the Wireguard and WireguardPeer objects that are still stored in an older version
+// of their CRD in its storage version, and then drops the older versions from the stored versions of the CRD, so
+// that they can be removed from the CRD in a later release.
+type StorageVersionMigrator struct {
+	Client client.Client
+	// APIReader reads the CRDs and objects directly from the API server, without caching them
+	APIReader client.Reader
+}
+
+// Start migrates the objects of the CRDs, retrying until it succeeds or ctx is cancelled.
+func (m *StorageVersionMigrator) Start(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("storage-version-migrator")
+
+	resources := []struct {
+		crd  string
+		list client.ObjectList
+	}{
+		{crd: "wireguards.vpn.wireguard-operator.io", list: &v1alpha1.WireguardList{}},
+		{crd: "wireguardpeers.vpn.wireguard-operator.io", list: &v1alpha1.WireguardPeerList{}},
+	}
+
+	for _, resource := range resources {
+		err := wait.PollUntilContextCancel(ctx, storageMigrationInterval, true, func(ctx context.Context) (bool, error) {
+			if err := m.migrate(ctx, resource.crd, resource.list); err != nil {
+				log.Error(err, "Failed to migrate the objects to the storage version", "crd", resource.crd)
+				return false, nil
+			}
+
+			return true, nil
+		})
+		if err != nil {
+			// the manager is stopping
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// NeedLeaderElection makes only the leader migrate the objects.
+func (m *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+// migrate rewrites all objects of the CRD unless the storage version is the only stored version, and then sets it
+// as the only stored version.
+func (m *StorageVersionMigrator) migrate(ctx context.Context, name string, list client.ObjectList) error {
+	log := ctrllog.FromContext(ctx)
+
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(crdGroupVersionKind)
+	if err := m.APIReader.Get(ctx, types.NamespacedName{Name: name}, crd); err != nil {
+		return err
+	}
+
+	storageVersion, err := getStorageVersion(crd)
+	if err != nil {
+		return err
+	}
+
+	storedVersions, _, err := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if err != nil {
+		return err
+	}
+
+	if len(storedVersions) == 1 && storedVersions[0] == storageVersion {
+		return nil
+	}
+
+	if err := m.APIReader.List(ctx, list); err != nil {
+		return err
+	}
+
+	objects, err := listObjects(list)
+	if err != nil {
+		return err
+	}
+
+	// an update without changes is enough for the API server to write the object in the storage version. The
+	// validating webhooks only check the fields an update changes, so they admit it even if the object is invalid.
+	// An object that can't be migrated doesn't stop the others from being migrated, but keeps the older version in
+	// the stored versions until the next attempt.
+	var errs []error
+	for _, object := range objects {
+		err := m.Client.Update(ctx, object)
+		if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			log.Error(err, "Failed to migrate object to the storage version", "crd", name, "namespace", object.GetNamespace(), "name", object.GetName())
+			errs = append(errs, fmt.Errorf("failed to migrate %s/%s: %w", object.GetNamespace(), object.GetName(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+
+	if err := unstructured.SetNestedStringSlice(crd.Object, []string{storageVersion}, "status", "storedVersions"); err != nil {
+		return err
+	}
+
+	if err := m.Client.Status().Update(ctx, crd); err != nil {
+		return err
+	}
+
+	log.Info("Migrated the objects to the storage version", "crd", name, "storageVersion", storageVersion, "objects", len(objects))
+
+	return nil
+}
+
+// getStorageVersion returns the name of the version of the CRD objects are stored in.
+func getStorageVersion(crd *unstructured.Unstructured) (string, error) {
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return "", err
+	}
+
+	for _, version := range versions {
+		version, ok := version.(map[string]interface{})
+		if ok && version["storage"] == true {
+			name, _ := version["name"].(string)
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no storage version found in CRD %s", crd.GetName())
+}
+
+// listObjects returns the items of a Wireguard or WireguardPeer list.
+func listObjects(list client.ObjectList) ([]client.Object, error) {
+	var objects []client.Object
+	switch list := list.(type) {
+	case *v1alpha1.WireguardList:
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	case *v1alpha1.WireguardPeerList:
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	default:
+		return nil, fmt.Errorf("unexpected list %T", list)
+	}
+
+	return objects, nil
+}
@@ -81,12 +81,14 @@ var _ = BeforeSuite(func() {
 		Scheme:               k8sManager.GetScheme(),
 		AgentImagePullPolicy: "IfNotPresent",
 		AgentImage:           wgTestImage,
+		Recorder:             k8sManager.GetEventRecorderFor("wireguard-controller"),
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&WireguardPeerReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:   k8sManager.GetClient(),
+		Scheme:   k8sManager.GetScheme(),
+		Recorder: k8sManager.GetEventRecorderFor("wireguardpeer-controller"),
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
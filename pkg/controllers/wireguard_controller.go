@@ -21,44 +21,81 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jodevsa/wireguard-operator/pkg/agent"
 	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
 
-	"github.com/korylprince/ipnetgen"
 	wgtypes "golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // WireguardReconciler reconciles a Wireguard object
 
-const port = 51820
 const httpPort = 8080
 
 const metricsPort = 9586
 
+const peerConfigSecretKey = "wg0.conf"
+const peerNextConfigSecretKey = "wg0.next.conf"
+const peerIpv6ConfigSecretKey = "wg0.ipv6.conf"
+
+const nextPrivateKeySecretKey = "nextPrivateKey"
+const nextPublicKeySecretKey = "nextPublicKey"
+
+const defaultKeyRotationGracePeriod = 24 * time.Hour
+
+// maxKeyRotations is the number of rotations of the server key kept in the status of a Wireguard
+const maxKeyRotations = 10
+
+// agentStatsTimeout is the timeout of the requests for the stats of the peers to the agent
+const agentStatsTimeout = 5 * time.Second
+
 type WireguardReconciler struct {
 	client.Client
 	Scheme               *runtime.Scheme
 	AgentImage           string
 	AgentImagePullPolicy corev1.PullPolicy
+	// Recorder emits the events of the Wireguard instances and their peers.
+	Recorder record.EventRecorder
+	// PeerStatsInterval is the minimum interval between two updates of the stats in the status of the peers of a
+	// Wireguard. The stats are not collected if it is 0.
+	PeerStatsInterval time.Duration
+
+	peerStatsMutex   sync.Mutex
+	peerStatsUpdates map[types.NamespacedName]time.Time
 }
 
 func labelsForWireguard(name string) map[string]string {
 	return map[string]string{"app": "wireguard", "instance": name}
 }
 
+// labelsForPeer returns the labels of the secrets owned by a peer. They differ from the labels of a Wireguard, so that
+// the selectors of a Wireguard named like the peer don't match its secrets.
+func labelsForPeer(name string) map[string]string {
+	return map[string]string{"app": "wireguard-peer", "peer": name}
+}
+
 func (r *WireguardReconciler) ConfigmapForWireguard(m *v1alpha1.Wireguard, hostname string) *corev1.ConfigMap {
 	ls := labelsForWireguard(m.Name)
 	dep := &corev1.ConfigMap{
@@ -119,12 +156,16 @@ func (r *WireguardReconciler) getNodeIps(ctx context.Context, req ctrl.Request)
 	return ips, nil
 }
 
-func (r *WireguardReconciler) updateStatus(ctx context.Context, req ctrl.Request, wireguard *v1alpha1.Wireguard, status v1alpha1.WgStatusReport) error {
+// updateStatus sets the status and message of the Wireguard together with its Ready condition and the given
+// conditions. The status is only updated if it changed.
+func (r *WireguardReconciler) updateStatus(ctx context.Context, req ctrl.Request, wireguard *v1alpha1.Wireguard, status v1alpha1.WgStatusReport, conditions ...metav1.Condition) error {
 	newWireguard := wireguard.DeepCopy()
-	if newWireguard.Status.Status != status.Status || newWireguard.Status.Message != status.Message {
-		newWireguard.Status.Status = status.Status
-		newWireguard.Status.Message = status.Message
+	newWireguard.Status.Status = status.Status
+	newWireguard.Status.Message = status.Message
+	newWireguard.Status.ObservedGeneration = wireguard.Generation
+	setConditions(&newWireguard.Status.Conditions, wireguard.Generation, append(conditions, readyCondition(status.Status, status.Message))...)
 
+	if !equality.Semantic.DeepEqual(wireguard.Status, newWireguard.Status) {
 		if err := r.Status().Update(ctx, newWireguard); err != nil {
 			return err
 		}
@@ -132,29 +173,145 @@ func (r *WireguardReconciler) updateStatus(ctx context.Context, req ctrl.Request
 	return nil
 }
 
-func getAvaialbleIp(cidr string, usedIps []string) (string, error) {
-	gen, err := ipnetgen.New(cidr)
-	if err != nil {
-		return "", err
-	}
-	for ip := gen.Next(); ip != nil; ip = gen.Next() {
-		used := false
-		for _, usedIp := range usedIps {
-			if ip.String() == usedIp {
-				used = true
-				break
+// getSecretValue reads the key referenced by selector from a secret in namespace. It returns false if the secret or
+// the key does not exist.
+func (r *WireguardReconciler) getSecretValue(ctx context.Context, namespace string, selector corev1.SecretKeySelector) (string, bool, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: selector.Name, Namespace: namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	value, ok := secret.Data[selector.Key]
+	return string(value), ok, nil
+}
+
+// getPeerPresharedKeys reads the preshared keys of the peers from their secrets. Peers whose preshared key is not
+// available yet are left out.
+func (r *WireguardReconciler) getPeerPresharedKeys(ctx context.Context, peers []v1alpha1.WireguardPeer) (map[string]string, error) {
+	log := ctrllog.FromContext(ctx)
+	presharedKeys := make(map[string]string)
+
+	for _, peer := range peers {
+		if peer.Spec.PresharedKey == nil || peer.Spec.PresharedKey.SecretKeyRef.Name == "" {
+			continue
+		}
+
+		secretKeyRef := peer.Spec.PresharedKey.SecretKeyRef
+		presharedKey, ok, err := r.getSecretValue(ctx, peer.Namespace, secretKeyRef)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			log.Info("Preshared key of peer not found", "peer.Name", peer.Name, "secret.Name", secretKeyRef.Name, "secret.Key", secretKeyRef.Key)
+			continue
+		}
+
+		presharedKeys[peer.Spec.PublicKey] = presharedKey
+	}
+
+	return presharedKeys, nil
+}
+
+// reconcileServerKey rotates the private key of the wg server when it is requested through the RotateKeyAnnotation or
+// due according to Spec.KeyRotation, and switches the server to the next key once the grace period is over. It
+// returns true if the Wireguard was updated, and otherwise the duration after which the next rotation step is due.
+func (r *WireguardReconciler) reconcileServerKey(ctx context.Context, wireguard *v1alpha1.Wireguard, secret *corev1.Secret) (bool, time.Duration, error) {
+	log := ctrllog.FromContext(ctx)
+	now := time.Now()
+
+	if publicKey := string(secret.Data["publicKey"]); wireguard.Status.PublicKey != publicKey {
+		wireguard.Status.PublicKey = publicKey
+		return true, 0, r.Status().Update(ctx, wireguard)
+	}
+
+	if switchTime := wireguard.Status.KeySwitchTime; switchTime != nil {
+		if now.Before(switchTime.Time) {
+			return false, switchTime.Sub(now), nil
+		}
+
+		if nextPrivateKey, ok := secret.Data[nextPrivateKeySecretKey]; ok {
+			log.Info("Switching to the next server key", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
+			secret.Data["privateKey"] = nextPrivateKey
+			secret.Data["publicKey"] = secret.Data[nextPublicKeySecretKey]
+			delete(secret.Data, nextPrivateKeySecretKey)
+			delete(secret.Data, nextPublicKeySecretKey)
+			if err := r.Update(ctx, secret); err != nil {
+				return false, 0, err
 			}
+			r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "KeySwitched", "Switched the server to the public key %s", secret.Data["publicKey"])
+		}
+
+		wireguard.Status.PublicKey = string(secret.Data["publicKey"])
+		wireguard.Status.NextPublicKey = ""
+		wireguard.Status.KeySwitchTime = nil
+		wireguard.Status.KeyLastRotationTime = &metav1.Time{Time: now}
+		if n := len(wireguard.Status.KeyRotations); n > 0 && wireguard.Status.KeyRotations[n-1].SwitchTime == nil {
+			wireguard.Status.KeyRotations[n-1].SwitchTime = &metav1.Time{Time: now}
 		}
-		if !used {
-			return ip.String(), nil
+		return true, 0, r.Status().Update(ctx, wireguard)
+	}
+
+	reason := ""
+	request := wireguard.Annotations[v1alpha1.RotateKeyAnnotation]
+	if request != "" && request != wireguard.Status.KeyRotationRequest {
+		reason = v1alpha1.KeyRotationRequested
+	}
+
+	var after time.Duration
+	keyRotation := wireguard.Spec.KeyRotation
+	if reason == "" && keyRotation != nil && keyRotation.RotationPeriod != nil {
+		lastRotation := wireguard.CreationTimestamp.Time
+		if wireguard.Status.KeyLastRotationTime != nil {
+			lastRotation = wireguard.Status.KeyLastRotationTime.Time
+		}
+
+		nextRotation := lastRotation.Add(keyRotation.RotationPeriod.Duration)
+		if now.Before(nextRotation) {
+			after = nextRotation.Sub(now)
+		} else {
+			reason = v1alpha1.KeyRotationScheduled
 		}
 	}
 
-	return "", fmt.Errorf("no available ip found in %s", cidr)
+	if reason == "" {
+		return false, after, nil
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return false, 0, err
+	}
+	publicKey := key.PublicKey().String()
+
+	log.Info("Rotating server key", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name, "reason", reason)
+	secret.Data[nextPrivateKeySecretKey] = []byte(key.String())
+	secret.Data[nextPublicKeySecretKey] = []byte(publicKey)
+	if err := r.Update(ctx, secret); err != nil {
+		return false, 0, err
+	}
+
+	gracePeriod := defaultKeyRotationGracePeriod
+	if keyRotation != nil && keyRotation.RotationGracePeriod != nil {
+		gracePeriod = keyRotation.RotationGracePeriod.Duration
+	}
+
+	r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "KeyRotated", "Generated the next key of the server with public key %s, the server switches to it in %s", publicKey, gracePeriod)
+	wireguard.Status.NextPublicKey = publicKey
+	wireguard.Status.KeySwitchTime = &metav1.Time{Time: now.Add(gracePeriod)}
+	wireguard.Status.KeyRotationRequest = request
+	wireguard.Status.KeyRotations = append(wireguard.Status.KeyRotations, v1alpha1.KeyRotationRecord{PublicKey: publicKey, Reason: reason, StartTime: metav1.Time{Time: now}})
+	if n := len(wireguard.Status.KeyRotations); n > maxKeyRotations {
+		wireguard.Status.KeyRotations = wireguard.Status.KeyRotations[n-maxKeyRotations:]
+	}
+	return true, 0, r.Status().Update(ctx, wireguard)
 }
 
-func (r *WireguardReconciler) getUsedIps(peers *v1alpha1.WireguardPeerList) []string {
-	usedIps := []string{"10.8.0.0", "10.8.0.1"}
+func (r *WireguardReconciler) getUsedIps(peers *v1alpha1.WireguardPeerList, network *net.IPNet, gateway net.IP) []string {
+	usedIps := []string{network.IP.String(), gateway.String(), agent.GetBroadcastAddress(network).String()}
 	for _, p := range peers.Items {
 		usedIps = append(usedIps, p.Spec.Address)
 
@@ -163,76 +320,526 @@ func (r *WireguardReconciler) getUsedIps(peers *v1alpha1.WireguardPeerList) []st
 	return usedIps
 }
 
+func (r *WireguardReconciler) getUsedIpv6s(peers *v1alpha1.WireguardPeerList, network *net.IPNet, gateway net.IP) []string {
+	usedIps := []string{network.IP.String(), gateway.String()}
+	for _, p := range peers.Items {
+		if p.Spec.Ipv6Address != "" {
+			usedIps = append(usedIps, p.Spec.Ipv6Address)
+		}
+	}
+
+	return usedIps
+}
+
+// peerEndpoint returns the endpoint the peer uses to reach the server. Peers can override the server address, and
+// optionally the port, through Spec.Endpoint.
+func peerEndpoint(peer v1alpha1.WireguardPeer, serverAddress string, serverPort string) string {
+	if peer.Spec.Endpoint == "" {
+		return net.JoinHostPort(serverAddress, serverPort)
+	}
+
+	if _, _, err := net.SplitHostPort(peer.Spec.Endpoint); err == nil {
+		return peer.Spec.Endpoint
+	}
+
+	return net.JoinHostPort(peer.Spec.Endpoint, serverPort)
+}
+
+// loadBalancerIpv6Address returns the first IPv6 address among the ingress points of the load balancer of a Service.
+func loadBalancerIpv6Address(ingressList []corev1.LoadBalancerIngress) string {
+	for _, ingress := range ingressList {
+		if ip := net.ParseIP(ingress.IP); ip != nil && ip.To4() == nil {
+			return ingress.IP
+		}
+	}
+
+	return ""
+}
+
+// peerConfigSecretName returns the name of the secret holding the rendered configuration of the peer.
+func peerConfigSecretName(peer *v1alpha1.WireguardPeer) string {
+	return peer.Name + "-config"
+}
+
+// getPeerPresharedKey returns the preshared key of the peer stored under secretKey of its preshared key secret. An
+// empty secretKey selects the current preshared key. It returns an empty string if the peer has no preshared key.
+func (r *WireguardReconciler) getPeerPresharedKey(ctx context.Context, peer v1alpha1.WireguardPeer, secretKey string) (string, error) {
+	if peer.Spec.PresharedKey == nil || peer.Spec.PresharedKey.SecretKeyRef.Name == "" {
+		return "", nil
+	}
+
+	selector := peer.Spec.PresharedKey.SecretKeyRef
+	if secretKey != "" {
+		selector.Key = secretKey
+	}
+
+	presharedKey, _, err := r.getSecretValue(ctx, peer.Namespace, selector)
+	return presharedKey, err
+}
+
+// syncPeerConfigSecret stores the rendered configuration of the peer in a secret owned by the peer.
+func (r *WireguardReconciler) syncPeerConfigSecret(ctx context.Context, peer *v1alpha1.WireguardPeer, data map[string][]byte) error {
+	log := ctrllog.FromContext(ctx)
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: peerConfigSecretName(peer), Namespace: peer.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      peerConfigSecretName(peer),
+				Namespace: peer.Namespace,
+				Labels:    labelsForPeer(peer.Name),
+			},
+			Data: data,
+		}
+		if err := ctrl.SetControllerReference(peer, secret, r.Scheme); err != nil {
+			return err
+		}
+
+		log.Info("Creating a new peer config secret", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
+		if err := r.Create(ctx, secret); err != nil {
+			return err
+		}
+
+		r.Recorder.Eventf(peer, corev1.EventTypeNormal, "ConfigRendered", "Rendered the configuration of the peer into secret %s", secret.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(secret, peer) {
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "ConfigSecretConflict", "Secret %s exists and is not owned by the peer", secret.Name)
+		return fmt.Errorf("secret %s/%s is not owned by peer %s", secret.Namespace, secret.Name, peer.Name)
+	}
+
+	// the secret also holds the preshared key and the QR codes of peers with a private key of the user, only the
+	// configuration files are synced
+	changed := false
+	for _, key := range []string{peerConfigSecretKey, peerNextConfigSecretKey, peerIpv6ConfigSecretKey} {
+		value, ok := data[key]
+		current, exists := secret.Data[key]
+		if ok && (!exists || !bytes.Equal(current, value)) {
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data[key] = value
+			changed = true
+		} else if !ok && exists {
+			delete(secret.Data, key)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if err := r.Update(ctx, secret); err != nil {
+		return err
+	}
+
+	r.Recorder.Eventf(peer, corev1.EventTypeNormal, "ConfigRendered", "Rendered the configuration of the peer into secret %s", secret.Name)
+	return nil
+}
+
+// getNetworkPolicies returns the WireguardNetworkPolicies of namespace in order of precedence: ascending priority, then
+// name.
+func (r *WireguardReconciler) getNetworkPolicies(ctx context.Context, namespace string) ([]v1alpha1.WireguardNetworkPolicy, error) {
+	policies := &v1alpha1.WireguardNetworkPolicyList{}
+	if err := r.List(ctx, policies, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(policies.Items, func(i, j int) bool {
+		if policies.Items[i].Spec.Priority != policies.Items[j].Spec.Priority {
+			return policies.Items[i].Spec.Priority < policies.Items[j].Spec.Priority
+		}
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	return policies.Items, nil
+}
+
+// mergeNetworkPolicies returns a copy of peer with the rules of the policies selecting it appended to its own rules,
+// and the names of these policies. policies have to be in order of precedence.
+func mergeNetworkPolicies(peer v1alpha1.WireguardPeer, policies []v1alpha1.WireguardNetworkPolicy) (v1alpha1.WireguardPeer, []string) {
+	merged := peer.DeepCopy()
+	var applied []string
+
+	for _, policy := range policies {
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PeerSelector)
+		if err != nil {
+			// a policy with an invalid selector does not select any peer
+			continue
+		}
+
+		if !selector.Matches(labels.Set(peer.Labels)) {
+			continue
+		}
+
+		merged.Spec.EgressNetworkPolicies = append(merged.Spec.EgressNetworkPolicies, policy.Spec.Egress...)
+		merged.Spec.IngressNetworkPolicies = append(merged.Spec.IngressNetworkPolicies, policy.Spec.Ingress...)
+		applied = append(applied, policy.Name)
+	}
+
+	return *merged, applied
+}
+
 func (r *WireguardReconciler) updateWireguardPeers(ctx context.Context, req ctrl.Request, wireguard *v1alpha1.Wireguard, serverAddress string, dns string, dnsSearchDomain string, serverPublicKey string, serverMtu string) error {
+	log := ctrllog.FromContext(ctx)
 
 	peers, err := r.getWireguardPeers(ctx, req)
 	if err != nil {
 		return err
 	}
 
-	usedIps := r.getUsedIps(peers)
+	network, gateway, err := agent.GetNetwork(*wireguard)
+	if err != nil {
+		return err
+	}
+
+	usedIps := r.getUsedIps(peers, network, gateway)
 
-	for _, peer := range peers.Items {
+	ipv6Network, ipv6Gateway, err := agent.GetIpv6Network(*wireguard)
+	if err != nil {
+		return err
+	}
+
+	var usedIpv6s []string
+	if ipv6Network != nil {
+		usedIpv6s = r.getUsedIpv6s(peers, ipv6Network, ipv6Gateway)
+	}
+
+	networkPolicies, err := r.getNetworkPolicies(ctx, wireguard.Namespace)
+	if err != nil {
+		return err
+	}
+
+	for i, peer := range peers.Items {
+		addressAllocated := false
 		if peer.Spec.Address == "" {
-			ip, err := getAvaialbleIp("10.8.0.0/24", usedIps)
+			ip, err := agent.GetAvailableIp(network.String(), usedIps)
 
 			if err != nil {
+				r.Recorder.Eventf(&peer, corev1.EventTypeWarning, "AddressAllocationFailed", "Unable to allocate an address: %v", err)
 				return err
 			}
 
 			peer.Spec.Address = ip
+			usedIps = append(usedIps, ip)
+			addressAllocated = true
+		}
+
+		if ipv6Network != nil && peer.Spec.Ipv6Address == "" {
+			ip, err := agent.GetAvailableIp(ipv6Network.String(), usedIpv6s)
+
+			if err != nil {
+				r.Recorder.Eventf(&peer, corev1.EventTypeWarning, "AddressAllocationFailed", "Unable to allocate an IPv6 address: %v", err)
+				return err
+			}
+
+			peer.Spec.Ipv6Address = ip
+			usedIpv6s = append(usedIpv6s, ip)
+			addressAllocated = true
+		}
 
+		if addressAllocated {
 			if err := r.Update(ctx, &peer); err != nil {
+				r.Recorder.Eventf(&peer, corev1.EventTypeWarning, "UpdateFailed", "Failed to store the allocated address: %v", err)
 				return err
 			}
 
-			usedIps = append(usedIps, ip)
+			r.Recorder.Eventf(&peer, corev1.EventTypeNormal, "AddressAllocated", "Allocated address %s", strings.TrimSuffix(peer.Spec.Address+", "+peer.Spec.Ipv6Address, ", "))
 		}
+
 		dnsConfiguration := dns
 
-		if dnsSearchDomain != "" {
-			dnsConfiguration = dns + ", " + dnsSearchDomain
+		if peer.Spec.Dns != "" {
+			dnsConfiguration = peer.Spec.Dns
+		}
+
+		if len(peer.Spec.DnsSearchDomains) != 0 {
+			dnsConfiguration = dnsConfiguration + ", " + strings.Join(peer.Spec.DnsSearchDomains, ", ")
+		} else if dnsSearchDomain != "" {
+			dnsConfiguration = dnsConfiguration + ", " + dnsSearchDomain
 		}
 
 		allowIps := peer.Spec.AllowedIPs
 
 		if allowIps == "" {
 			allowIps = "0.0.0.0/0"
+
+			if ipv6Network != nil {
+				allowIps = allowIps + ", ::/0"
+			}
+		}
+
+		peerAddress := peer.Spec.Address
+
+		if ipv6Network != nil && peer.Spec.Ipv6Address != "" {
+			peerAddress = peerAddress + ", " + peer.Spec.Ipv6Address
+		}
+
+		newConfig := "[Interface]\n"
+
+		if !peer.Spec.OmitPrivateKey && peer.Spec.PrivateKey.SecretKeyRef.Name != "" {
+			privateKey, ok, err := r.getSecretValue(ctx, peer.Namespace, peer.Spec.PrivateKey.SecretKeyRef)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				log.Info("Waiting for the private key of peer", "peer.Name", peer.Name, "secret.Name", peer.Spec.PrivateKey.SecretKeyRef.Name)
+				r.Recorder.Eventf(&peer, corev1.EventTypeWarning, "PrivateKeyMissing", "Waiting for key %s of secret %s holding the private key of the peer", peer.Spec.PrivateKey.SecretKeyRef.Key, peer.Spec.PrivateKey.SecretKeyRef.Name)
+				continue
+			}
+
+			newConfig = newConfig + "PrivateKey = " + strings.TrimSpace(privateKey) + "\n"
 		}
 
-		newConfig := fmt.Sprintf(`
-echo "
-[Interface]
-PrivateKey = $(kubectl get secret %s --template={{.data.%s}} -n %s | base64 -d)
-Address = %s
-DNS = %s`, peer.Spec.PrivateKey.SecretKeyRef.Name, peer.Spec.PrivateKey.SecretKeyRef.Key, peer.Namespace, peer.Spec.Address, dnsConfiguration)
+		newConfig = newConfig + fmt.Sprintf("Address = %s\nDNS = %s\n", peerAddress, dnsConfiguration)
+
+		mtu := serverMtu
 
-		if serverMtu != "" {
-			newConfig = newConfig + "\nMTU = " + serverMtu
+		if peer.Spec.Mtu != "" {
+			mtu = peer.Spec.Mtu
 		}
 
-		newConfig = newConfig + fmt.Sprintf(`
+		if mtu != "" {
+			newConfig = newConfig + "MTU = " + mtu + "\n"
+		}
 
+		endpoint := peerEndpoint(peer, serverAddress, wireguard.Status.Port)
+
+		renderConfig := func(serverPublicKey string, presharedKey string, endpoint string) []byte {
+			config := newConfig + fmt.Sprintf(`
 [Peer]
 PublicKey = %s
 AllowedIPs = %s
-Endpoint = %s:%s"`, serverPublicKey, allowIps, serverAddress, wireguard.Status.Port)
-		if peer.Status.Config != newConfig || peer.Status.Status != v1alpha1.Ready {
-			peer.Status.Config = newConfig
-			peer.Status.Status = v1alpha1.Ready
-			peer.Status.Message = "Peer configured"
-			if err := r.Status().Update(ctx, &peer); err != nil {
+Endpoint = %s
+`, serverPublicKey, allowIps, endpoint)
+
+			if peer.Spec.PersistentKeepalive != 0 {
+				config = config + fmt.Sprintf("PersistentKeepalive = %d\n", peer.Spec.PersistentKeepalive)
+			}
+
+			if presharedKey != "" {
+				config = config + "PresharedKey = " + presharedKey + "\n"
+			}
+
+			return []byte(config)
+		}
+
+		presharedKey, err := r.getPeerPresharedKey(ctx, peer, "")
+		if err != nil {
+			return err
+		}
+
+		nextPresharedKey := presharedKey
+		if peer.Status.PresharedKeySwitchTime != nil {
+			key, err := r.getPeerPresharedKey(ctx, peer, nextPresharedKeySecretKey)
+			if err != nil {
 				return err
 			}
+
+			if key != "" {
+				nextPresharedKey = key
+			}
+		}
+
+		nextServerPublicKey := serverPublicKey
+		if wireguard.Status.NextPublicKey != "" {
+			nextServerPublicKey = wireguard.Status.NextPublicKey
 		}
+
+		configData := map[string][]byte{peerConfigSecretKey: renderConfig(serverPublicKey, presharedKey, endpoint)}
+		var nextConfigRef *corev1.SecretKeySelector
+
+		// the next configuration is the one after all pending rotations of the server key and the preshared key
+		if nextPresharedKey != presharedKey || nextServerPublicKey != serverPublicKey {
+			configData[peerNextConfigSecretKey] = renderConfig(nextServerPublicKey, nextPresharedKey, endpoint)
+			nextConfigRef = &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: peerConfigSecretName(&peer)},
+				Key:                  peerNextConfigSecretKey,
+			}
+		}
+
+		// peers of a dual-stack load balancer can also reach the server over its IPv6 address, unless they override
+		// the endpoint
+		if wireguard.Status.Ipv6Address != "" && wireguard.Status.Ipv6Address != serverAddress && peer.Spec.Endpoint == "" {
+			configData[peerIpv6ConfigSecretKey] = renderConfig(serverPublicKey, presharedKey, net.JoinHostPort(wireguard.Status.Ipv6Address, wireguard.Status.Port))
+		}
+
+		if err := r.syncPeerConfigSecret(ctx, &peer, configData); err != nil {
+			return err
+		}
+
+		configRef := &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: peerConfigSecretName(&peer)},
+			Key:                  peerConfigSecretKey,
+		}
+
+		_, appliedNetworkPolicies := mergeNetworkPolicies(peer, networkPolicies)
+
+		newPeer := peer.DeepCopy()
+		newPeer.Status.ConfigRef = configRef
+		newPeer.Status.NextConfigRef = nextConfigRef
+		newPeer.Status.AppliedNetworkPolicies = appliedNetworkPolicies
+		newPeer.Status.Status = v1alpha1.Ready
+		newPeer.Status.Message = "Peer configured"
+		setPeerConditions(newPeer)
+
+		if !equality.Semantic.DeepEqual(peer.Status, newPeer.Status) {
+			if err := r.Status().Update(ctx, newPeer); err != nil {
+				return err
+			}
+
+			// the config reference is only set here and never cleared, so it tells whether the peer was ready before
+			if peer.Status.ConfigRef == nil {
+				observePeerReady(peer, time.Now())
+			}
+		}
+
+		peers.Items[i] = *newPeer
+	}
+
+	key := types.NamespacedName{Name: wireguard.Name, Namespace: wireguard.Namespace}
+	setPeerMetrics(key, peers.Items)
+	setPoolMetrics(key, "ipv4", network, usedIps)
+	if ipv6Network != nil {
+		setPoolMetrics(key, "ipv6", ipv6Network, usedIpv6s)
 	}
 
 	return nil
 }
 
+// peerStatsDue returns true if the stats of the peers of the Wireguard with the given key were not updated within
+// PeerStatsInterval before now. It records now as the time of the next update.
+func (r *WireguardReconciler) peerStatsDue(key types.NamespacedName, now time.Time) bool {
+	r.peerStatsMutex.Lock()
+	defer r.peerStatsMutex.Unlock()
+
+	if last, ok := r.peerStatsUpdates[key]; ok && now.Sub(last) < r.PeerStatsInterval {
+		return false
+	}
+
+	if r.peerStatsUpdates == nil {
+		r.peerStatsUpdates = make(map[types.NamespacedName]time.Time)
+	}
+	r.peerStatsUpdates[key] = now
+
+	return true
+}
+
+// getAgentPeerStats returns the stats of the peers collected by a running agent of wireguard.
+func (r *WireguardReconciler) getAgentPeerStats(ctx context.Context, wireguard *v1alpha1.Wireguard) ([]agent.PeerStats, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(wireguard.Namespace), client.MatchingLabels(labelsForWireguard(wireguard.Name))); err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: agentStatsTimeout}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+
+		url := fmt.Sprintf("http://%s/stats", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(httpPort)))
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("agent %s responded with %s", pod.Name, response.Status)
+		}
+
+		var stats []agent.PeerStats
+		if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+			return nil, err
+		}
+
+		return stats, nil
+	}
+
+	return nil, fmt.Errorf("no running agent found for wireguard %s", wireguard.Name)
+}
+
+// updatePeerStats sets the stats collected by the agent in the status of the peers of wireguard. The status of a peer
+// is only updated if its stats changed.
+func (r *WireguardReconciler) updatePeerStats(ctx context.Context, req ctrl.Request, wireguard *v1alpha1.Wireguard) error {
+	stats, err := r.getAgentPeerStats(ctx, wireguard)
+	if err != nil {
+		return err
+	}
+
+	statsByPublicKey := make(map[string]agent.PeerStats)
+	for _, peerStats := range stats {
+		statsByPublicKey[peerStats.PublicKey] = peerStats
+	}
+
+	peers, err := r.getWireguardPeers(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, peer := range peers.Items {
+		// peers missing from the wg device, e.g. disabled ones, get empty stats
+		peerStats := statsByPublicKey[peer.Spec.PublicKey]
+
+		var lastHandshake *metav1.Time
+		if !peerStats.LastHandshakeTime.IsZero() {
+			// the API server stores times with a precision of seconds
+			lastHandshake = &metav1.Time{Time: peerStats.LastHandshakeTime.Truncate(time.Second)}
+		}
+
+		connected := peerStats.Connected(now)
+
+		// a handshake after the last switch of the server key proves the peer uses the current key
+		serverPublicKey := peer.Status.ServerPublicKey
+		if lastHandshake != nil && (wireguard.Status.KeyLastRotationTime == nil || lastHandshake.After(wireguard.Status.KeyLastRotationTime.Time)) {
+			serverPublicKey = wireguard.Status.PublicKey
+		}
+
+		if peer.Status.LastHandshake.Equal(lastHandshake) && peer.Status.RxBytes == peerStats.ReceiveBytes && peer.Status.TxBytes == peerStats.TransmitBytes && peer.Status.Endpoint == peerStats.Endpoint && peer.Status.Connected == connected && peer.Status.ServerPublicKey == serverPublicKey {
+			continue
+		}
+
+		peer.Status.LastHandshake = lastHandshake
+		peer.Status.RxBytes = peerStats.ReceiveBytes
+		peer.Status.TxBytes = peerStats.TransmitBytes
+		peer.Status.Endpoint = peerStats.Endpoint
+		peer.Status.Connected = connected
+		peer.Status.ServerPublicKey = serverPublicKey
+		if err := r.Status().Update(ctx, &peer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// agentPeer returns the view of peer that goes into the state of the agent: its name, namespace and spec. Updates of
+// the metadata or the status of the peer, e.g. of its stats, then leave the state unchanged and do not trigger a
+// resync of the agent.
+func agentPeer(peer v1alpha1.WireguardPeer) v1alpha1.WireguardPeer {
+	return v1alpha1.WireguardPeer{
+		ObjectMeta: metav1.ObjectMeta{Name: peer.Name, Namespace: peer.Namespace},
+		Spec:       peer.Spec,
+	}
+}
+
 //+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguards,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguards/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguards/finalizers,verbs=update
+//+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguardnetworkpolicies,verbs=get;list;watch
 
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
@@ -241,6 +848,7 @@ Endpoint = %s:%s"`, serverPublicKey, allowIps, serverAddress, wireguard.Status.P
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="apps",resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -258,6 +866,10 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	wireguard := &v1alpha1.Wireguard{}
 	log.Info(req.NamespacedName.Name)
+	if err := r.updateWireguardsMetric(ctx); err != nil {
+		log.Error(err, "Failed to update the metric of the Wireguard instances")
+	}
+
 	err := r.Get(ctx, req.NamespacedName, wireguard)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -265,6 +877,7 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			log.Info("wireguard resource not found. Ignoring since object must be deleted")
+			deleteWireguardMetrics(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -289,9 +902,19 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	// TODO add a label to wireguardpeers and then filter by label here to only get peers of the wg instance we need.
 	if err := r.List(ctx, peers, client.InNamespace(req.Namespace)); err != nil {
 		log.Error(err, "Failed to fetch list of peers")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "ListPeersFailed", "Failed to list the peers: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	networkPolicies, err := r.getNetworkPolicies(ctx, req.Namespace)
+	if err != nil {
+		log.Error(err, "Failed to fetch list of network policies")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "ListNetworkPoliciesFailed", "Failed to list the network policies: %v", err)
 		return ctrl.Result{}, err
 	}
 
+	now := time.Now()
+	var accessScheduleAfter time.Duration
 	var filteredPeers []v1alpha1.WireguardPeer
 	for _, peer := range peers.Items {
 		if peer.Spec.WireguardRef != wireguard.Name {
@@ -305,7 +928,24 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			continue
 		}
 
-		filteredPeers = append(filteredPeers, peer)
+		// the agent enforces the rules of the network policies selecting the peer as if they were rules of the peer
+		mergedPeer, _ := mergeNetworkPolicies(peer, networkPolicies)
+		mergedPeer = agentPeer(mergedPeer)
+
+		// peers outside of their access windows are disabled on the wg server until their next window opens
+		open, next, err := agent.GetAccessWindow(peer.Spec.AccessSchedule, now)
+		if err != nil {
+			log.Error(err, "Failed to evaluate the access schedule of peer", "peer", peer.Name)
+			r.Recorder.Eventf(&peer, corev1.EventTypeWarning, "InvalidAccessSchedule", "Disabled the peer since its access schedule is invalid: %v", err)
+		}
+		if !open {
+			mergedPeer.Spec.Disabled = true
+		}
+		if !next.IsZero() {
+			accessScheduleAfter = shorterRequeue(accessScheduleAfter, next.Sub(now))
+		}
+
+		filteredPeers = append(filteredPeers, mergedPeer)
 	}
 
 	svcFound := &corev1.Service{}
@@ -317,11 +957,13 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		err = r.Create(ctx, svc)
 		if err != nil {
 			log.Error(err, "Failed to create new service", "service.Namespace", svc.Namespace, "service.Name", svc.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "CreateServiceFailed", "Failed to create service %s: %v", svc.Name, err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "ServiceCreated", "Created service %s", svc.Name)
 		// svc created successfully - return and requeue
 
-		err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for metrics service to be created"})
+		err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for metrics service to be created"}, condition(v1alpha1.ConditionServiceReady, false, reasonServiceCreating, "Waiting for metrics service to be created"))
 
 		if err != nil {
 			return ctrl.Result{}, err
@@ -330,6 +972,7 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get service")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GetServiceFailed", "Failed to get service: %v", err)
 		return ctrl.Result{}, err
 	}
 
@@ -340,11 +983,12 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		serviceType = wireguard.Spec.ServiceType
 	}
 
-	dnsAddress := "1.1.1.1"
+	dnsAddress := agent.FallbackDns
 	dnsSearchDomain := ""
 
 	if wireguard.Spec.Dns != "" {
 		dnsAddress = wireguard.Spec.Dns
+		dnsSearchDomain = strings.Join(wireguard.Spec.DnsSearchDomains, ", ")
 	} else {
 		kubeDnsService := &corev1.Service{}
 		err = r.Get(ctx, types.NamespacedName{Name: "kube-dns", Namespace: "kube-system"}, kubeDnsService)
@@ -353,6 +997,7 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			dnsSearchDomain = fmt.Sprintf("%s.svc.cluster.local", wireguard.Namespace)
 		} else {
 			log.Error(err, "Unable to get kube-dns service")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "KubeDnsNotFound", "Unable to get the kube-dns service, peers use %s as DNS server: %v", dnsAddress, err)
 		}
 	}
 
@@ -363,30 +1008,35 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		err = r.Create(ctx, svc)
 		if err != nil {
 			log.Error(err, "Failed to create new service", "service.Namespace", svc.Namespace, "service.Name", svc.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "CreateServiceFailed", "Failed to create service %s: %v", svc.Name, err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "ServiceCreated", "Created service %s", svc.Name)
 		// svc created successfully - return and requeue
 
-		err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service to be created"})
+		err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service to be created"}, condition(v1alpha1.ConditionServiceReady, false, reasonServiceCreating, "Waiting for service to be created"))
 
 		if err != nil {
 			log.Error(err, "Failed to update wireguard status", "service.Namespace", svc.Namespace, "service.Name", svc.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "UpdateStatusFailed", "Failed to update the status: %v", err)
 			return ctrl.Result{}, err
 		}
 
 		return ctrl.Result{}, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get service")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GetServiceFailed", "Failed to get service: %v", err)
 		return ctrl.Result{}, err
 	}
 	address := wireguard.Spec.Address
-	var port = fmt.Sprintf("%d", port)
+	ipv6Address := ""
+	port := strconv.Itoa(int(agent.GetListenPort(*wireguard)))
 
 	if serviceType == corev1.ServiceTypeLoadBalancer {
 		ingressList := svcFound.Status.LoadBalancer.Ingress
 		log.Info("Found ingress", "ingress", ingressList)
 		if len(ingressList) == 0 {
-			err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service to be ready"})
+			err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service to be ready"}, condition(v1alpha1.ConditionServiceReady, false, reasonServicePending, "Waiting for the load balancer of the service to be provisioned"))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
@@ -401,10 +1051,14 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		if address == "" {
 			address = svcFound.Status.LoadBalancer.Ingress[0].IP
 		}
+
+		if agent.IsDualStack(*wireguard) {
+			ipv6Address = loadBalancerIpv6Address(ingressList)
+		}
 	}
 	if serviceType == corev1.ServiceTypeNodePort {
 		if len(svcFound.Spec.Ports) == 0 {
-			err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service with type NodePort to be ready"})
+			err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service with type NodePort to be ready"}, condition(v1alpha1.ConditionServiceReady, false, reasonServicePending, "Waiting for the node port of the service to be allocated"))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
@@ -421,7 +1075,9 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 		if address == "" {
 			if len(ips) == 0 {
-				err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Unable to determine WG address though nodes addresses. Please set Wireguard.Spec.Address if necessary."})
+				err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Unable to determine WG address though nodes addresses. Please set Wireguard.Spec.Address if necessary."},
+					condition(v1alpha1.ConditionServiceReady, true, reasonServiceReady, ""),
+					condition(v1alpha1.ConditionEndpointResolved, false, reasonAddressUnknown, "None of the nodes has an address, set Wireguard.Spec.Address"))
 				if err != nil {
 					return ctrl.Result{}, err
 				}
@@ -434,7 +1090,7 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	if serviceType == corev1.ServiceTypeClusterIP {
 		if len(svcFound.Spec.Ports) == 0 {
-			err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service with type ClusterIP to be ready"})
+			err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for service with type ClusterIP to be ready"}, condition(v1alpha1.ConditionServiceReady, false, reasonServicePending, "Waiting for the ports of the service to be set"))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
@@ -443,9 +1099,10 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 	}
 
-	if wireguard.Status.Address != address || port != wireguard.Status.Port || dnsAddress != wireguard.Status.Dns {
+	if wireguard.Status.Address != address || ipv6Address != wireguard.Status.Ipv6Address || port != wireguard.Status.Port || dnsAddress != wireguard.Status.Dns {
 		updateWireguard := wireguard.DeepCopy()
 		updateWireguard.Status.Address = address
+		updateWireguard.Status.Ipv6Address = ipv6Address
 		updateWireguard.Status.Port = port
 		updateWireguard.Status.Dns = dnsAddress
 
@@ -453,44 +1110,71 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 		if err != nil {
 			log.Error(err, "Failed to update wireguard manifest address, port, and dns")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "UpdateStatusFailed", "Failed to update the address, port and DNS server in the status: %v", err)
 			return ctrl.Result{}, err
 		}
 
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "EndpointChanged", "Peers connect to %s:%s and use %s as DNS server", address, port, dnsAddress)
+
 		return ctrl.Result{}, nil
 	}
 
+	presharedKeys, err := r.getPeerPresharedKeys(ctx, filteredPeers)
+	if err != nil {
+		log.Error(err, "Failed to fetch preshared keys of peers")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GetPresharedKeysFailed", "Failed to get the preshared keys of the peers: %v", err)
+		return ctrl.Result{}, err
+	}
+
 	// fetch secret
 	secret := &corev1.Secret{}
 	err = r.Get(ctx, types.NamespacedName{Name: wireguard.Name, Namespace: wireguard.Namespace}, secret)
 	// secret already created
+	var keyRotationAfter time.Duration
 	if err == nil {
+		updated, after, err := r.reconcileServerKey(ctx, wireguard, secret)
+		if err != nil {
+			log.Error(err, "Failed to rotate the server key")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "KeyRotationFailed", "Failed to rotate the key of the server: %v", err)
+			return ctrl.Result{}, err
+		}
+
+		if updated {
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		keyRotationAfter = after
 		privateKey := string(secret.Data["privateKey"])
 
 		state := agent.State{
 			Server:           *wireguard.DeepCopy(),
 			ServerPrivateKey: privateKey,
 			Peers:            filteredPeers,
+			PresharedKeys:    presharedKeys,
 		}
 
 		b, err := json.Marshal(state)
 		if err != nil {
 			log.Error(err, "Failed to save state to secret")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "MarshalStateFailed", "Failed to serialize the state of the agent: %v", err)
 			return ctrl.Result{}, err
 		}
 
 		if !bytes.Equal(b, secret.Data["state.json"]) {
 			log.Info("Updating secret with new config")
-			publicKey := string(secret.Data["publicKey"])
+			secret.Data["state.json"] = b
 
-			err := r.Update(ctx, r.secretForWireguard(wireguard, b, privateKey, publicKey))
+			err := r.Update(ctx, secret)
 			if err != nil {
 				log.Error(err, "Failed to update secret with new config")
+				r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "UpdateSecretFailed", "Failed to store the new configuration in secret %s: %v", wireguard.Name, err)
 				return ctrl.Result{}, err
 			}
 
 			pods := &corev1.PodList{}
 			if err := r.List(ctx, pods, client.MatchingLabels{"app": "wireguard", "instance": wireguard.Name}); err != nil {
 				log.Error(err, "Failed to fetch list of pods")
+				r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "ListPodsFailed", "Failed to list the pods of the agent: %v", err)
 				return ctrl.Result{}, err
 			}
 
@@ -502,12 +1186,16 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				pod.Annotations["wgConfigLastUpdated"] = time.Now().Format("2006-01-02T15-04-05")
 				if err := r.Update(ctx, &pod); err != nil {
 					log.Error(err, "Failed to update pod")
+					r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "UpdatePodFailed", "Failed to notify pod %s of the new configuration: %v", pod.Name, err)
 					return ctrl.Result{}, err
 				}
+				configUpdatesMetric.WithLabelValues(wireguard.Namespace, wireguard.Name).Inc()
 
 				log.Info("updated pod")
 			}
 
+			r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "ConfigUpdated", "Pushed the new configuration to %d agent pods", len(pods.Items))
+
 		}
 
 	}
@@ -521,17 +1209,20 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 		if err != nil {
 			log.Error(err, "Failed to generate private key")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GenerateKeysFailed", "Failed to generate the keys of the server: %v", err)
 			return ctrl.Result{}, err
 		}
 		state := agent.State{
 			Server:           *wireguard.DeepCopy(),
 			ServerPrivateKey: privateKey,
 			Peers:            filteredPeers,
+			PresharedKeys:    presharedKeys,
 		}
 
 		b, err := json.Marshal(state)
 		if err != nil {
 			log.Error(err, "Failed to save state to secret")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "MarshalStateFailed", "Failed to serialize the state of the agent: %v", err)
 			return ctrl.Result{}, err
 		}
 
@@ -541,11 +1232,14 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 		if err := r.Create(ctx, secret); err != nil {
 			log.Error(err, "Failed to create new secret", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "CreateSecretFailed", "Failed to create secret %s: %v", secret.Name, err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "KeysGenerated", "Generated the keys of the server and stored them in secret %s", secret.Name)
 		return ctrl.Result{}, err
 	} else if err != nil {
 		log.Error(err, "Failed to get secret")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GetSecretFailed", "Failed to get secret %s: %v", wireguard.Name, err)
 		return ctrl.Result{}, err
 	}
 
@@ -559,14 +1253,17 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		err = r.Create(ctx, config)
 		if err != nil {
 			log.Error(err, "Failed to create new dep", "dep.Namespace", config.Namespace, "dep.Name", config.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "CreateConfigMapFailed", "Failed to create configmap %s: %v", config.Name, err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "ConfigMapCreated", "Created configmap %s", config.Name)
 
 		err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Pending, Message: "Waiting for configmap to be created"})
 
 		return ctrl.Result{}, err
 	} else if err != nil {
 		log.Error(err, "Failed to get config")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GetConfigMapFailed", "Failed to get configmap: %v", err)
 		return ctrl.Result{}, err
 	}
 
@@ -580,37 +1277,61 @@ func (r *WireguardReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		err = r.Create(ctx, dep)
 		if err != nil {
 			log.Error(err, "Failed to create new dep", "dep.Namespace", dep.Namespace, "dep.Name", dep.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "CreateDeploymentFailed", "Failed to create deployment %s: %v", dep.Name, err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "DeploymentCreated", "Created deployment %s", dep.Name)
 		// Deployment created successfully - return and requeue
 		return ctrl.Result{}, err
 	} else if err != nil {
 		log.Error(err, "Failed to get dep")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "GetDeploymentFailed", "Failed to get deployment: %v", err)
 		return ctrl.Result{}, err
 	}
 
-	if deploymentFound.Spec.Template.Spec.Containers[0].Image != r.AgentImage {
-		dep := r.deploymentForWireguard(wireguard)
+	dep := r.deploymentForWireguard(wireguard)
+	if deploymentChanged(deploymentFound, dep) {
 		err = r.Update(ctx, dep)
 		if err != nil {
-			log.Error(err, "unable to update deployment image", "dep.Namespace", dep.Namespace, "dep.Name", dep.Name)
+			log.Error(err, "unable to update deployment", "dep.Namespace", dep.Namespace, "dep.Name", dep.Name)
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "UpdateDeploymentFailed", "Failed to update deployment %s: %v", dep.Name, err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(wireguard, corev1.EventTypeNormal, "DeploymentUpdated", "Updated deployment %s with agent image %s", dep.Name, r.AgentImage)
 	}
 
 	if err := r.updateWireguardPeers(ctx, req, wireguard, address, dnsAddress, dnsSearchDomain, string(secret.Data["publicKey"]), wireguard.Spec.Mtu); err != nil {
+		log.Error(err, "Failed to update peers")
+		r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "UpdatePeersFailed", "Failed to configure the peers: %v", err)
 		return ctrl.Result{}, err
 	}
 
 	log.Info("Updated related peers", "wireguard.Namespace", wireguard.Namespace, "wireguard.Name", wireguard.Name)
 
-	err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Ready, Message: "VPN is active!"})
+	err = r.updateStatus(ctx, req, wireguard, v1alpha1.WgStatusReport{Status: v1alpha1.Ready, Message: "VPN is active!"},
+		condition(v1alpha1.ConditionServiceReady, true, reasonServiceReady, ""),
+		condition(v1alpha1.ConditionEndpointResolved, true, reasonEndpointResolved, fmt.Sprintf("Peers connect to %s:%s", address, port)),
+		condition(v1alpha1.ConditionKeysGenerated, true, reasonKeysGenerated, ""),
+		condition(v1alpha1.ConditionAgentSynced, true, reasonStatePushed, "The agent was sent the current state of the server and its peers"))
 
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	requeueAfter := shorterRequeue(keyRotationAfter, accessScheduleAfter)
+	if r.PeerStatsInterval <= 0 {
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+
+	if r.peerStatsDue(req.NamespacedName, time.Now()) {
+		// the agent may not be running yet, the stats are updated again after PeerStatsInterval
+		if err := r.updatePeerStats(ctx, req, wireguard); err != nil {
+			log.Error(err, "Failed to update the stats of the peers")
+			r.Recorder.Eventf(wireguard, corev1.EventTypeWarning, "PeerStatsFailed", "Failed to collect the stats of the peers from the agent: %v", err)
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: shorterRequeue(requeueAfter, r.PeerStatsInterval)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -622,9 +1343,27 @@ func (r *WireguardReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&corev1.ConfigMap{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Secret{}).
+		Watches(&v1alpha1.WireguardNetworkPolicy{}, handler.EnqueueRequestsFromMapFunc(r.wireguardsForNetworkPolicy)).
 		Complete(r)
 }
 
+// wireguardsForNetworkPolicy returns a request for every Wireguard in the namespace of policy, as the policy may select
+// peers of any of them.
+func (r *WireguardReconciler) wireguardsForNetworkPolicy(ctx context.Context, policy client.Object) []reconcile.Request {
+	wireguards := &v1alpha1.WireguardList{}
+	if err := r.List(ctx, wireguards, client.InNamespace(policy.GetNamespace())); err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Failed to fetch list of wireguards")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, wireguard := range wireguards.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: wireguard.Name, Namespace: wireguard.Namespace}})
+	}
+
+	return requests
+}
+
 func (r *WireguardReconciler) serviceForWireguard(m *v1alpha1.Wireguard, serviceType corev1.ServiceType) *corev1.Service {
 	labels := labelsForWireguard(m.Name)
 
@@ -640,8 +1379,8 @@ func (r *WireguardReconciler) serviceForWireguard(m *v1alpha1.Wireguard, service
 			Ports: []corev1.ServicePort{{
 				Protocol:   corev1.ProtocolUDP,
 				NodePort:   m.Spec.NodePort,
-				Port:       port,
-				TargetPort: intstr.FromInt(port),
+				Port:       agent.GetListenPort(*m),
+				TargetPort: intstr.FromInt(int(agent.GetListenPort(*m))),
 			}},
 			Type: serviceType,
 		},
@@ -651,6 +1390,11 @@ func (r *WireguardReconciler) serviceForWireguard(m *v1alpha1.Wireguard, service
 		dep.Spec.LoadBalancerIP = m.Spec.Address
 	}
 
+	if agent.IsDualStack(*m) {
+		ipFamilyPolicy := corev1.IPFamilyPolicyPreferDualStack
+		dep.Spec.IPFamilyPolicy = &ipFamilyPolicy
+	}
+
 	ctrl.SetControllerReference(m, dep, r.Scheme)
 	return dep
 }
@@ -698,13 +1442,46 @@ func (r *WireguardReconciler) secretForWireguard(m *v1alpha1.Wireguard, state []
 
 }
 
+// deploymentChanged reports whether the pod template of the deployment found in the cluster differs from the desired
+// one in a field the reconciler sets, e.g. after the agent image, the listen port or the dual-stack setup changed, or
+// because the deployment was created before the agent served the metrics and still has the exporter sidecar. Fields
+// defaulted by the API server are not compared.
+func deploymentChanged(found *appsv1.Deployment, desired *appsv1.Deployment) bool {
+	foundSpec := found.Spec.Template.Spec
+	desiredSpec := desired.Spec.Template.Spec
+
+	if !equality.Semantic.DeepEqual(foundSpec.NodeSelector, desiredSpec.NodeSelector) {
+		return true
+	}
+
+	return containersChanged(foundSpec.InitContainers, desiredSpec.InitContainers) || containersChanged(foundSpec.Containers, desiredSpec.Containers)
+}
+
+// containersChanged reports whether the containers found in a pod template differ from the desired ones in a field the
+// reconciler sets.
+func containersChanged(found []corev1.Container, desired []corev1.Container) bool {
+	if len(found) != len(desired) {
+		return true
+	}
+
+	for i := range desired {
+		if found[i].Name != desired[i].Name ||
+			found[i].Image != desired[i].Image ||
+			!equality.Semantic.DeepEqual(found[i].Command, desired[i].Command) ||
+			!equality.Semantic.DeepEqual(found[i].Args, desired[i].Args) ||
+			!equality.Semantic.DeepEqual(found[i].Ports, desired[i].Ports) ||
+			!equality.Semantic.DeepEqual(found[i].Resources, desired[i].Resources) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (r *WireguardReconciler) deploymentForWireguard(m *v1alpha1.Wireguard) *appsv1.Deployment {
 	ls := labelsForWireguard(m.Name)
 	replicas := int32(1)
 
-	runAsNonRoot := true
-	runAsUser := int64(65534)
-	runAsGroup := int64(65534)
 	readOnlyRootFilesystem := true
 	allowPrivilegeEscalation := false
 	automountServiceAccountToken := false
@@ -751,36 +1528,6 @@ func (r *WireguardReconciler) deploymentForWireguard(m *v1alpha1.Wireguard) *app
 						}},
 					InitContainers: []corev1.Container{},
 					Containers: []corev1.Container{
-						{
-							SecurityContext: &corev1.SecurityContext{
-								RunAsUser:                &runAsUser,
-								RunAsGroup:               &runAsGroup,
-								RunAsNonRoot:             &runAsNonRoot,
-								ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
-								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
-								Capabilities: &corev1.Capabilities{
-									Drop: []corev1.Capability{"ALL"},
-									Add:  []corev1.Capability{"NET_ADMIN"},
-								},
-							},
-							Image:           r.AgentImage,
-							ImagePullPolicy: r.AgentImagePullPolicy,
-							Name:            "metrics",
-							Command:         []string{"/usr/local/bin/prometheus_wireguard_exporter"},
-							Ports: []corev1.ContainerPort{
-								{
-									ContainerPort: metricsPort,
-									Name:          "metrics",
-									Protocol:      corev1.ProtocolTCP,
-								}},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "socket",
-									MountPath: "/var/run/wireguard/",
-								},
-							},
-							Resources: m.Spec.Metric.Resources,
-						},
 						{
 							SecurityContext: &corev1.SecurityContext{
 								ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
@@ -790,18 +1537,23 @@ func (r *WireguardReconciler) deploymentForWireguard(m *v1alpha1.Wireguard) *app
 							Image:           r.AgentImage,
 							ImagePullPolicy: r.AgentImagePullPolicy,
 							Name:            "agent",
-							Command:         []string{"agent", "--v", "11", "--wg-iface", "wg0", "--wg-listen-port", fmt.Sprintf("%d", port), "--state", "/tmp/wireguard/state.json", "--wg-userspace-implementation-fallback", "wireguard-go"},
+							Command:         []string{"agent", "--v", "11", "--wg-iface", "wg0", "--wg-listen-port", fmt.Sprintf("%d", agent.GetListenPort(*m)), "--state", "/tmp/wireguard/state.json", "--wg-userspace-implementation-fallback", "wireguard-go"},
 							Ports: []corev1.ContainerPort{
 								{
-									ContainerPort: port,
+									ContainerPort: agent.GetListenPort(*m),
 									Name:          "wireguard",
 									Protocol:      corev1.ProtocolUDP,
 								},
 								{
-									ContainerPort: port,
+									ContainerPort: agent.GetListenPort(*m),
 									Name:          "http",
 									Protocol:      corev1.ProtocolTCP,
 								},
+								{
+									ContainerPort: metricsPort,
+									Name:          "metrics",
+									Protocol:      corev1.ProtocolTCP,
+								},
 							},
 							EnvFrom: []corev1.EnvFromSource{{
 								ConfigMapRef: &corev1.ConfigMapEnvSource{
@@ -842,6 +1594,12 @@ func (r *WireguardReconciler) deploymentForWireguard(m *v1alpha1.Wireguard) *app
 
 	if m.Spec.EnableIpForwardOnPodInit {
 		privileged := true
+		sysctlCommand := "echo 1 > /proc/sys/net/ipv4/ip_forward"
+
+		if agent.IsDualStack(*m) {
+			sysctlCommand = sysctlCommand + " && echo 1 > /proc/sys/net/ipv6/conf/all/forwarding"
+		}
+
 		dep.Spec.Template.Spec.InitContainers = append(dep.Spec.Template.Spec.InitContainers,
 			corev1.Container{
 				SecurityContext: &corev1.SecurityContext{
@@ -851,7 +1609,7 @@ func (r *WireguardReconciler) deploymentForWireguard(m *v1alpha1.Wireguard) *app
 				ImagePullPolicy: r.AgentImagePullPolicy,
 				Name:            "sysctl",
 				Command:         []string{"/bin/sh"},
-				Args:            []string{"-c", "echo 1 > /proc/sys/net/ipv4/ip_forward"},
+				Args:            []string{"-c", sysctlCommand},
 			})
 	}
 
@@ -2,16 +2,22 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
 	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	wgtypes "golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -65,6 +71,19 @@ func reconcileServiceWithTypeLoadBalancer(svcKey client.ObjectKey, hostname stri
 	return k8sClient.Status().Update(context.Background(), svc)
 }
 
+// getPeerConfig returns the configuration of the peer from the secret referenced by its status.
+func getPeerConfig(peerKey client.ObjectKey) string {
+	peer := &v1alpha1.WireguardPeer{}
+	Expect(k8sClient.Get(context.Background(), peerKey, peer)).Should(Succeed())
+	if peer.Status.ConfigRef == nil {
+		return ""
+	}
+
+	secret := &corev1.Secret{}
+	Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: peer.Status.ConfigRef.Name, Namespace: peer.Namespace}, secret)).Should(Succeed())
+	return string(secret.Data[peer.Status.ConfigRef.Key])
+}
+
 func reconcileServiceWithClusterIP(svcKey client.ObjectKey, port int32) error {
 	svc := &corev1.Service{}
 	Expect(k8sClient.Get(context.Background(), svcKey, svc)).Should(Succeed())
@@ -80,94 +99,1140 @@ func reconcileServiceWithClusterIP(svcKey client.ObjectKey, port int32) error {
 	return k8sClient.Status().Update(context.Background(), svc)
 }
 
-var _ = Describe("wireguard controller", func() {
+var _ = Describe("wireguard controller", func() {
+
+	// Define utility constants for object names and testing timeouts/durations and intervals.
+	const (
+		wgName       = "vpn"
+		wgNamespace  = "default"
+		Timeout      = time.Second * 2
+		Interval     = time.Second * 1
+		dnsServiceIp = "10.0.0.42"
+	)
+
+	wgKey := types.NamespacedName{
+		Name:      wgName,
+		Namespace: wgNamespace,
+	}
+
+	BeforeEach(func() {
+		var listOpts []client.ListOption
+
+		// delete all wg resources
+		wgList := &v1alpha1.WireguardList{}
+		Expect(k8sClient.List(context.Background(), wgList, listOpts...)).Should(Succeed())
+		for _, wg := range wgList.Items {
+			Expect(k8sClient.Delete(context.Background(), &wg)).Should(Succeed())
+		}
+		// delete all wg-peer resources
+		peerList := &v1alpha1.WireguardPeerList{}
+		Expect(k8sClient.List(context.Background(), peerList, listOpts...)).Should(Succeed())
+		for _, peer := range peerList.Items {
+			Expect(k8sClient.Delete(context.Background(), &peer)).Should(Succeed())
+		}
+
+		// delete all network policies
+		policyList := &v1alpha1.WireguardNetworkPolicyList{}
+		Expect(k8sClient.List(context.Background(), policyList, listOpts...)).Should(Succeed())
+		for _, policy := range policyList.Items {
+			Expect(k8sClient.Delete(context.Background(), &policy)).Should(Succeed())
+		}
+
+		// delete all wg-peer services
+		svcList := &corev1.ServiceList{}
+		Expect(k8sClient.List(context.Background(), svcList, listOpts...)).Should(Succeed())
+		for _, svc := range svcList.Items {
+			Expect(k8sClient.Delete(context.Background(), &svc)).Should(Succeed())
+		}
+
+		// delete all nodes
+		nodeList := &corev1.NodeList{}
+		Expect(k8sClient.List(context.Background(), nodeList, listOpts...)).Should(Succeed())
+		for _, node := range nodeList.Items {
+			Expect(k8sClient.Delete(context.Background(), &node)).Should(Succeed())
+		}
+
+		// delete all secrets
+		secretList := &corev1.SecretList{}
+		Expect(k8sClient.List(context.Background(), secretList, listOpts...)).Should(Succeed())
+		for _, secret := range secretList.Items {
+			Expect(k8sClient.Delete(context.Background(), &secret)).Should(Succeed())
+		}
+
+		// delete all configmaps
+		cList := &corev1.ConfigMapList{}
+		Expect(k8sClient.List(context.Background(), cList, listOpts...)).Should(Succeed())
+		for _, c := range cList.Items {
+			Expect(k8sClient.Delete(context.Background(), &c)).Should(Succeed())
+		}
+
+		// create kube-dns service
+		dnsService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kube-dns",
+				Namespace: "kube-system",
+			},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: dnsServiceIp,
+				Ports:     []corev1.ServicePort{{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53}},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), dnsService)).Should(Succeed())
+
+	})
+	Context("Wireguard", func() {
+		It("sets Custom address for peers through Wireguard.Spec.Address", func() {
+			expectedAddress := "test-address"
+			var expectedPort = "30000"
+
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					ServiceType: corev1.ServiceTypeNodePort,
+					Address:     expectedAddress,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			// service created
+			serviceName := wgKey.Name + "-svc"
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      serviceName,
+			}
+			expectedLabels := map[string]string{"app": "wireguard", "instance": wgKey.Name}
+			// match labels
+			Eventually(func() map[string]string {
+				svc := &corev1.Service{}
+				Expect(k8sClient.Get(context.Background(), serviceKey, svc)).Should(Succeed())
+				return svc.Spec.Selector
+			}, Timeout, Interval).Should(BeEquivalentTo(expectedLabels))
+
+			Expect(reconcileServiceWithTypeNodePort(serviceKey, expectedPort, 51820)).Should(Succeed())
+
+			Eventually(func() string {
+				for _, line := range strings.Split(getPeerConfig(wgPeerKey), "\n") {
+					if strings.Contains(line, "Endpoint") {
+						return line
+					}
+				}
+				return "Endpoint = CONFIG_NOT_SET_ERROR"
+			}, Timeout, Interval).Should(Equal("Endpoint = " + expectedAddress + ":" + fmt.Sprint(expectedPort)))
+
+		})
+		It("sets Custom DNS through Wireguard.Spec.DNS", func() {
+
+			expectedDNS := "3.3.3.3"
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					Dns: expectedDNS,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			expectedLabels := map[string]string{"app": "wireguard", "instance": wgKey.Name}
+			// service created
+			serviceName := wgKey.Name + "-svc"
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      serviceName,
+			}
+
+			// match labels
+			Eventually(func() map[string]string {
+				svc := &corev1.Service{}
+				Expect(k8sClient.Get(context.Background(), serviceKey, svc)).Should(Succeed())
+				return svc.Spec.Selector
+			}, Timeout, Interval).Should(BeEquivalentTo(expectedLabels))
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() string {
+				for _, line := range strings.Split(getPeerConfig(wgPeerKey), "\n") {
+					if strings.Contains(line, "DNS") {
+						return line
+					}
+				}
+				return "DNS = CONFIG_NOT_SET_ERROR"
+			}, Timeout, Interval).Should(Equal("DNS = " + expectedDNS))
+
+		})
+		It("allocates peer addresses from Wireguard.Spec.Network.Cidr", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					Network: v1alpha1.WireguardNetwork{
+						Cidr:    "172.16.0.0/16",
+						Gateway: "172.16.0.2",
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			expectedLabels := map[string]string{"app": "wireguard", "instance": wgKey.Name}
+			// service created
+			serviceName := wgKey.Name + "-svc"
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      serviceName,
+			}
+
+			// match labels
+			Eventually(func() map[string]string {
+				svc := &corev1.Service{}
+				Expect(k8sClient.Get(context.Background(), serviceKey, svc)).Should(Succeed())
+				return svc.Spec.Selector
+			}, Timeout, Interval).Should(BeEquivalentTo(expectedLabels))
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			// network and gateway addresses are reserved
+			Eventually(func() string {
+				wgPeer := &v1alpha1.WireguardPeer{}
+				Expect(k8sClient.Get(context.Background(), wgPeerKey, wgPeer)).Should(Succeed())
+				return wgPeer.Spec.Address
+			}, Timeout, Interval).Should(Equal("172.16.0.1"))
+
+		})
+		It("exposes the peers and the address pool of the Wireguard as metrics", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					Network: v1alpha1.WireguardNetwork{
+						Cidr: "10.8.0.0/29",
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			for i, disabled := range []bool{false, true} {
+				wgPeer := &v1alpha1.WireguardPeer{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      fmt.Sprintf("%s-peer%d", wgName, i),
+						Namespace: wgNamespace,
+					},
+					Spec: v1alpha1.WireguardPeerSpec{
+						WireguardRef: wgName,
+						Disabled:     disabled,
+					},
+				}
+				Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			}
+
+			serviceKey := types.NamespacedName{Namespace: wgKey.Namespace, Name: wgKey.Name + "-svc"}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() float64 {
+				return testutil.ToFloat64(peersMetric.WithLabelValues(wgNamespace, wgName, peerStateReady))
+			}, Timeout, Interval).Should(Equal(2.0))
+			Expect(testutil.ToFloat64(peersMetric.WithLabelValues(wgNamespace, wgName, peerStateTotal))).To(Equal(2.0))
+			Expect(testutil.ToFloat64(peersMetric.WithLabelValues(wgNamespace, wgName, peerStateDisabled))).To(Equal(1.0))
+
+			// network, gateway, broadcast and the two peers
+			Expect(testutil.ToFloat64(poolAddressesMetric.WithLabelValues(wgNamespace, wgName, "ipv4", poolStateUsed))).To(Equal(5.0))
+			Expect(testutil.ToFloat64(poolAddressesMetric.WithLabelValues(wgNamespace, wgName, "ipv4", poolStateFree))).To(Equal(3.0))
+		})
+
+		It("reports the conditions of the Wireguard and of its peers", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+
+			serviceKey := types.NamespacedName{Namespace: wgKey.Namespace, Name: wgKey.Name + "-svc"}
+			Eventually(func() *metav1.Condition {
+				wg := &v1alpha1.Wireguard{}
+				Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+				return meta.FindStatusCondition(wg.Status.Conditions, v1alpha1.ConditionServiceReady)
+			}, Timeout, Interval).ShouldNot(BeNil())
+
+			wg := &v1alpha1.Wireguard{}
+			Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+			Expect(meta.IsStatusConditionFalse(wg.Status.Conditions, v1alpha1.ConditionServiceReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(wg.Status.Conditions, v1alpha1.ConditionReady)).To(BeTrue())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() bool {
+				wg := &v1alpha1.Wireguard{}
+				Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+				return meta.IsStatusConditionTrue(wg.Status.Conditions, v1alpha1.ConditionReady)
+			}, Timeout, Interval).Should(BeTrue())
+
+			Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+			Expect(wg.Status.ObservedGeneration).To(Equal(wg.Generation))
+			for _, conditionType := range []string{v1alpha1.ConditionServiceReady, v1alpha1.ConditionEndpointResolved, v1alpha1.ConditionKeysGenerated, v1alpha1.ConditionAgentSynced} {
+				Expect(meta.IsStatusConditionTrue(wg.Status.Conditions, conditionType)).To(BeTrue(), conditionType)
+			}
+
+			Eventually(func() bool {
+				peer := &v1alpha1.WireguardPeer{}
+				Expect(k8sClient.Get(context.Background(), wgPeerKey, peer)).Should(Succeed())
+				return meta.IsStatusConditionTrue(peer.Status.Conditions, v1alpha1.ConditionReady) && meta.IsStatusConditionTrue(peer.Status.Conditions, v1alpha1.ConditionKeysGenerated)
+			}, Timeout, Interval).Should(BeTrue())
+		})
+
+		It("records events on the Wireguard and its peers", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgName + "-peer1",
+					Namespace: wgNamespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+
+			serviceKey := types.NamespacedName{Namespace: wgKey.Namespace, Name: wgKey.Name + "-svc"}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			// reasons of the events of each object
+			eventReasons := func() map[string][]string {
+				events := &corev1.EventList{}
+				Expect(k8sClient.List(context.Background(), events, client.InNamespace(wgNamespace))).Should(Succeed())
+
+				reasons := make(map[string][]string)
+				for _, event := range events.Items {
+					key := event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name
+					reasons[key] = append(reasons[key], event.Reason)
+				}
+				return reasons
+			}
+
+			Eventually(eventReasons, Timeout, Interval).Should(And(
+				HaveKeyWithValue("Wireguard/"+wgName, ContainElements("ServiceCreated", "KeysGenerated", "EndpointChanged", "DeploymentCreated")),
+				HaveKeyWithValue("WireguardPeer/"+wgPeer.Name, ContainElements("KeysGenerated", "AddressAllocated", "ConfigRendered")),
+			))
+		})
+
+		It("allocates IPv4 and IPv6 peer addresses for dual-stack Wireguard instances", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					Network: v1alpha1.WireguardNetwork{
+						Ipv6Cidr: "fd00:8::/64",
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			expectedLabels := map[string]string{"app": "wireguard", "instance": wgKey.Name}
+			// service created
+			serviceName := wgKey.Name + "-svc"
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      serviceName,
+			}
+
+			// match labels
+			Eventually(func() map[string]string {
+				svc := &corev1.Service{}
+				Expect(k8sClient.Get(context.Background(), serviceKey, svc)).Should(Succeed())
+				return svc.Spec.Selector
+			}, Timeout, Interval).Should(BeEquivalentTo(expectedLabels))
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() []string {
+				wgPeer := &v1alpha1.WireguardPeer{}
+				Expect(k8sClient.Get(context.Background(), wgPeerKey, wgPeer)).Should(Succeed())
+				return []string{wgPeer.Spec.Address, wgPeer.Spec.Ipv6Address}
+			}, Timeout, Interval).Should(Equal([]string{"10.8.0.2", "fd00:8::2"}))
+
+			Eventually(func() []string {
+				var lines []string
+				for _, line := range strings.Split(getPeerConfig(wgPeerKey), "\n") {
+					if strings.HasPrefix(line, "Address") || strings.HasPrefix(line, "AllowedIPs") {
+						lines = append(lines, line)
+					}
+				}
+				return lines
+			}, Timeout, Interval).Should(Equal([]string{"Address = 10.8.0.2, fd00:8::2", "AllowedIPs = 0.0.0.0/0, ::/0"}))
+
+		})
+		It("renders an IPv6 endpoint variant of the peer config for dual-stack load balancers", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					Network: v1alpha1.WireguardNetwork{
+						Ipv6Cidr: "fd00:8::/64",
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			svc := &corev1.Service{}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, svc)
+			}, Timeout, Interval).Should(Succeed())
+
+			svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "32.121.45.102"}, {IP: "2001:db8::10"}}
+			Expect(k8sClient.Status().Update(context.Background(), svc)).Should(Succeed())
+
+			Eventually(func() []string {
+				wg := &v1alpha1.Wireguard{}
+				Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+				return []string{wg.Status.Address, wg.Status.Ipv6Address}
+			}, Timeout, Interval).Should(Equal([]string{"32.121.45.102", "2001:db8::10"}))
+
+			Eventually(func() string {
+				return getPeerConfig(wgPeerKey)
+			}, Timeout, Interval).Should(ContainSubstring("Endpoint = 32.121.45.102:51820\n"))
+
+			Eventually(func() string {
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-config", Namespace: wgNamespace}, secret)
+				return string(secret.Data["wg0.ipv6.conf"])
+			}, Timeout, Interval).Should(ContainSubstring("Endpoint = [2001:db8::10]:51820\n"))
+		})
+		It("generates a preshared key for peers with Spec.PresharedKey set", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+					PresharedKey: &v1alpha1.PresharedKey{},
+				},
+			}
+
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			presharedKey := ""
+			Eventually(func() []byte {
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-peer", Namespace: wgNamespace}, secret)
+				presharedKey = string(secret.Data["presharedKey"])
+				return secret.Data["presharedKey"]
+			}, Timeout, Interval).Should(HaveLen(44))
+
+			Eventually(func() string {
+				for _, line := range strings.Split(getPeerConfig(wgPeerKey), "\n") {
+					if strings.Contains(line, "PresharedKey") {
+						return line
+					}
+				}
+				return "PresharedKey = CONFIG_NOT_SET_ERROR"
+			}, Timeout, Interval).Should(Equal("PresharedKey = " + presharedKey))
+
+		})
+		It("derives the public key of peers from the private key secret referenced by Spec.PrivateKey", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+					PrivateKey: v1alpha1.PrivateKey{
+						SecretKeyRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "vault-keys"}, Key: "peer1"},
+					},
+					PresharedKey: &v1alpha1.PresharedKey{},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+
+			peer := &v1alpha1.WireguardPeer{}
+			Eventually(func() string {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				return peer.Status.Message
+			}, Timeout, Interval).Should(Equal("Secret vault-keys holding the private key of the peer does not exist"))
+			Expect(peer.Status.Status).Should(Equal(v1alpha1.Error))
+
+			key, err := wgtypes.GeneratePrivateKey()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(k8sClient.Create(context.Background(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "vault-keys", Namespace: wgNamespace},
+				Data:       map[string][]byte{"peer1": []byte(key.String() + "\n")},
+			})).Should(Succeed())
+
+			Eventually(func() string {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				return peer.Spec.PublicKey
+			}, Timeout, Interval).Should(Equal(key.PublicKey().String()))
+
+			// the operator neither generates a key nor overrides the reference to the secret of the user
+			Expect(peer.Spec.PrivateKey.SecretKeyRef.Name).Should(Equal("vault-keys"))
+			err = k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-peer", Namespace: wgNamespace}, &corev1.Secret{})
+			Expect(errors.IsNotFound(err)).Should(BeTrue())
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() string {
+				return getPeerConfig(wgPeerKey)
+			}, Timeout, Interval).Should(ContainSubstring("PrivateKey = " + key.String() + "\n"))
+
+			// the preshared key and the QR codes are kept in the config secret, no peer secret holds the private key
+			configSecret := &corev1.Secret{}
+			Eventually(func() []byte {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-config", Namespace: wgNamespace}, configSecret)
+				return configSecret.Data["configQRCode.png"]
+			}, Timeout, Interval).ShouldNot(BeEmpty())
+			Expect(configSecret.Data["presharedKey"]).Should(HaveLen(44))
+			Expect(configSecret.Data).Should(HaveKey("wg0.conf"))
+			err = k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-peer", Namespace: wgNamespace}, &corev1.Secret{})
+			Expect(errors.IsNotFound(err)).Should(BeTrue())
+		})
+		It("warns about expiring peers and disables or deletes expired peers according to Spec.ExpiryPolicy", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			expiringKey := types.NamespacedName{Name: wgName + "-expiring", Namespace: wgNamespace}
+			disabledKey := types.NamespacedName{Name: wgName + "-disabled", Namespace: wgNamespace}
+			deletedKey := types.NamespacedName{Name: wgName + "-deleted", Namespace: wgNamespace}
+
+			expiresAt := metav1.NewTime(time.Now().Add(30 * time.Minute))
+			expired := metav1.NewTime(time.Now().Add(-time.Minute))
+			for _, peer := range []*v1alpha1.WireguardPeer{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: expiringKey.Name, Namespace: wgNamespace},
+					Spec:       v1alpha1.WireguardPeerSpec{WireguardRef: wgName, ExpiresAt: &expiresAt, ValidFor: &metav1.Duration{Duration: 24 * time.Hour}},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: disabledKey.Name, Namespace: wgNamespace},
+					Spec:       v1alpha1.WireguardPeerSpec{WireguardRef: wgName, ExpiresAt: &expired},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: deletedKey.Name, Namespace: wgNamespace},
+					Spec:       v1alpha1.WireguardPeerSpec{WireguardRef: wgName, ExpiresAt: &expired, ExpiryPolicy: v1alpha1.ExpiryPolicyDelete},
+				},
+			} {
+				Expect(k8sClient.Create(context.Background(), peer)).Should(Succeed())
+			}
+
+			peer := &v1alpha1.WireguardPeer{}
+			Eventually(func() *metav1.Time {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), expiringKey, peer)
+				return peer.Status.ExpiryWarningTime
+			}, Timeout, Interval).ShouldNot(BeNil())
+
+			Expect(peer.Status.ExpiryTime.Unix()).Should(Equal(expiresAt.Unix()))
+			Expect(peer.Spec.Disabled).Should(BeFalse())
+
+			Eventually(func() bool {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), disabledKey, peer)
+				return peer.Spec.Disabled
+			}, Timeout, Interval).Should(BeTrue())
+
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(context.Background(), deletedKey, &v1alpha1.WireguardPeer{}))
+			}, Timeout, Interval).Should(BeTrue())
+		})
+		It("rotates the peer key on request and reports the next scheduled rotation", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef:      wgName,
+					KeyRotationPeriod: &metav1.Duration{Duration: 24 * time.Hour},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			peer := &v1alpha1.WireguardPeer{}
+			Eventually(func() *metav1.Time {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				return peer.Status.KeyNextRotationTime
+			}, Timeout, Interval).ShouldNot(BeNil())
+
+			Expect(peer.Status.KeyNextRotationTime.Time).Should(Equal(peer.CreationTimestamp.Add(24 * time.Hour)))
+			publicKey := peer.Spec.PublicKey
+			Expect(publicKey).Should(HaveLen(44))
+
+			peer.Annotations = map[string]string{v1alpha1.RotateKeyAnnotation: "1"}
+			Expect(k8sClient.Update(context.Background(), peer)).Should(Succeed())
+
+			Eventually(func() string {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				return peer.Status.KeyRotationRequest
+			}, Timeout, Interval).Should(Equal("1"))
+
+			Expect(peer.Spec.PublicKey).ShouldNot(Equal(publicKey))
+			Expect(peer.Status.KeyLastRotationTime).ShouldNot(BeNil())
+			Expect(peer.Status.KeyNextRotationTime.Time).Should(Equal(peer.Status.KeyLastRotationTime.Add(24 * time.Hour)))
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-peer", Namespace: wgNamespace}, secret)).Should(Succeed())
+			Expect(string(secret.Data["publicKey"])).Should(Equal(peer.Spec.PublicKey))
+
+			// the configuration of the peer is rendered with the new private key
+			Eventually(func() string {
+				return getPeerConfig(wgPeerKey)
+			}, Timeout, Interval).Should(ContainSubstring("PrivateKey = " + string(secret.Data["privateKey"])))
+		})
+		It("rotates the server key on request and exposes the next peer configuration until the switch", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					KeyRotation: &v1alpha1.KeyRotation{RotationGracePeriod: &metav1.Duration{Duration: time.Hour}},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			publicKey := ""
+			Eventually(func() string {
+				wg := &v1alpha1.Wireguard{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgKey, wg)
+				publicKey = wg.Status.PublicKey
+				return publicKey
+			}, Timeout, Interval).Should(HaveLen(44))
+
+			Eventually(func() string {
+				return getPeerConfig(wgPeerKey)
+			}, Timeout, Interval).Should(ContainSubstring("PublicKey = " + publicKey))
+
+			wg := &v1alpha1.Wireguard{}
+			Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+			wg.Annotations = map[string]string{v1alpha1.RotateKeyAnnotation: "1"}
+			Expect(k8sClient.Update(context.Background(), wg)).Should(Succeed())
+
+			Eventually(func() []v1alpha1.KeyRotationRecord {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgKey, wg)
+				return wg.Status.KeyRotations
+			}, Timeout, Interval).Should(HaveLen(1))
+
+			nextPublicKey := wg.Status.NextPublicKey
+			Expect(nextPublicKey).Should(HaveLen(44))
+			Expect(nextPublicKey).ShouldNot(Equal(publicKey))
+			Expect(wg.Status.KeyRotations[0].Reason).Should(Equal(v1alpha1.KeyRotationRequested))
+			Expect(wg.Status.KeySwitchTime).ShouldNot(BeNil())
+
+			// the current configuration keeps the current key while the next one already uses the next key
+			Eventually(func() string {
+				peer := &v1alpha1.WireguardPeer{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				if peer.Status.NextConfigRef == nil {
+					return ""
+				}
+
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: peer.Status.NextConfigRef.Name, Namespace: wgNamespace}, secret)
+				return string(secret.Data[peer.Status.NextConfigRef.Key])
+			}, Timeout, Interval).Should(ContainSubstring("PublicKey = " + nextPublicKey))
+			Expect(getPeerConfig(wgPeerKey)).Should(ContainSubstring("PublicKey = " + publicKey))
+
+			// switch now instead of after the grace period
+			wg.Status.KeySwitchTime = &metav1.Time{Time: time.Now().Add(-time.Second)}
+			Expect(k8sClient.Status().Update(context.Background(), wg)).Should(Succeed())
+
+			Eventually(func() string {
+				return getPeerConfig(wgPeerKey)
+			}, Timeout, Interval).Should(ContainSubstring("PublicKey = " + nextPublicKey))
+
+			Expect(k8sClient.Get(context.Background(), wgKey, wg)).Should(Succeed())
+			Expect(wg.Status.PublicKey).Should(Equal(nextPublicKey))
+			Expect(wg.Status.NextPublicKey).Should(BeEmpty())
+			Expect(wg.Status.KeyRotations[0].SwitchTime).ShouldNot(BeNil())
+
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), wgKey, secret)).Should(Succeed())
+			Expect(string(secret.Data["publicKey"])).Should(Equal(nextPublicKey))
+			Expect(secret.Data).ShouldNot(HaveKey("nextPrivateKey"))
+		})
+		It("overrides DNS, MTU and endpoint and sets PersistentKeepalive through WireguardPeer.Spec", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardSpec{
+					Mtu: "1380",
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+
+			wgPeer := &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef:        wgName,
+					Dns:                 "3.3.3.3",
+					DnsSearchDomains:    []string{"corp.example.com"},
+					Mtu:                 "1280",
+					Endpoint:            "vpn.internal.example.com",
+					PersistentKeepalive: 25,
+				},
+			}
+
+			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() []string {
+				var lines []string
+				for _, line := range strings.Split(getPeerConfig(wgPeerKey), "\n") {
+					for _, prefix := range []string{"DNS", "MTU", "Endpoint", "PersistentKeepalive"} {
+						if strings.HasPrefix(line, prefix) {
+							lines = append(lines, line)
+						}
+					}
+				}
+				return lines
+			}, Timeout, Interval).Should(Equal([]string{
+				"DNS = 3.3.3.3, corp.example.com",
+				"MTU = 1280",
+				"Endpoint = vpn.internal.example.com:51820",
+				"PersistentKeepalive = 25",
+			}))
+
+		})
+		It("renders the peer configuration into a secret and leaves the private key out with Spec.OmitPrivateKey", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+			wgPeerWithoutKeyKey := types.NamespacedName{
+				Name:      wgName + "-peer2",
+				Namespace: wgNamespace,
+			}
+
+			Expect(k8sClient.Create(context.Background(), &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			})).Should(Succeed())
+
+			Expect(k8sClient.Create(context.Background(), &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerWithoutKeyKey.Name,
+					Namespace: wgPeerWithoutKeyKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef:   wgName,
+					OmitPrivateKey: true,
+				},
+			})).Should(Succeed())
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() *corev1.SecretKeySelector {
+				wgPeer := &v1alpha1.WireguardPeer{}
+				Expect(k8sClient.Get(context.Background(), wgPeerKey, wgPeer)).Should(Succeed())
+				return wgPeer.Status.ConfigRef
+			}, Timeout, Interval).Should(Equal(&corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: wgPeerKey.Name + "-config"},
+				Key:                  "wg0.conf",
+			}))
+
+			privateKeySecret := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-peer", Namespace: wgNamespace}, privateKeySecret)).Should(Succeed())
+
+			Eventually(func() string {
+				return strings.Split(getPeerConfig(wgPeerKey), "\n")[1]
+			}, Timeout, Interval).Should(Equal("PrivateKey = " + string(privateKeySecret.Data["privateKey"])))
+
+			Eventually(func() string {
+				return getPeerConfig(wgPeerWithoutKeyKey)
+			}, Timeout, Interval).Should(And(HavePrefix("[Interface]\nAddress = "), Not(ContainSubstring("PrivateKey"))))
+
+		})
+		It("labels the config secret of a peer and leaves a secret of the same name it does not own untouched", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
+			wgPeerWithSecretKey := types.NamespacedName{
+				Name:      wgName + "-peer2",
+				Namespace: wgNamespace,
+			}
+
+			userSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerWithSecretKey.Name + "-config",
+					Namespace: wgNamespace,
+				},
+				Data: map[string][]byte{"wg0.conf": []byte("user config")},
+			}
+			Expect(k8sClient.Create(context.Background(), userSecret)).Should(Succeed())
+
+			for _, key := range []types.NamespacedName{wgPeerKey, wgPeerWithSecretKey} {
+				Expect(k8sClient.Create(context.Background(), &v1alpha1.WireguardPeer{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      key.Name,
+						Namespace: key.Namespace,
+					},
+					Spec: v1alpha1.WireguardPeerSpec{
+						WireguardRef: wgName,
+					},
+				})).Should(Succeed())
+			}
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
 
-	// Define utility constants for object names and testing timeouts/durations and intervals.
-	const (
-		wgName       = "vpn"
-		wgNamespace  = "default"
-		Timeout      = time.Second * 2
-		Interval     = time.Second * 1
-		dnsServiceIp = "10.0.0.42"
-	)
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
 
-	wgKey := types.NamespacedName{
-		Name:      wgName,
-		Namespace: wgNamespace,
-	}
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
 
-	BeforeEach(func() {
-		var listOpts []client.ListOption
+			Eventually(func() map[string]string {
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-config", Namespace: wgNamespace}, secret)
+				return secret.Labels
+			}, Timeout, Interval).Should(Equal(map[string]string{"app": "wireguard-peer", "peer": wgPeerKey.Name}))
+
+			Consistently(func() map[string][]byte {
+				secret := &corev1.Secret{}
+				Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(userSecret), secret)).Should(Succeed())
+				return secret.Data
+			}, Timeout, Interval).Should(Equal(userSecret.Data))
+		})
+		It("stores a QR code of the peer configuration in the peer secret", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
 
-		// delete all wg resources
-		wgList := &v1alpha1.WireguardList{}
-		Expect(k8sClient.List(context.Background(), wgList, listOpts...)).Should(Succeed())
-		for _, wg := range wgList.Items {
-			Expect(k8sClient.Delete(context.Background(), &wg)).Should(Succeed())
-		}
-		// delete all wg-peer resources
-		peerList := &v1alpha1.WireguardPeerList{}
-		Expect(k8sClient.List(context.Background(), peerList, listOpts...)).Should(Succeed())
-		for _, peer := range peerList.Items {
-			Expect(k8sClient.Delete(context.Background(), &peer)).Should(Succeed())
-		}
+			wgPeerKey := types.NamespacedName{
+				Name:      wgName + "-peer1",
+				Namespace: wgNamespace,
+			}
 
-		// delete all wg-peer services
-		svcList := &corev1.ServiceList{}
-		Expect(k8sClient.List(context.Background(), svcList, listOpts...)).Should(Succeed())
-		for _, svc := range svcList.Items {
-			Expect(k8sClient.Delete(context.Background(), &svc)).Should(Succeed())
-		}
+			Expect(k8sClient.Create(context.Background(), &v1alpha1.WireguardPeer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgPeerKey.Name,
+					Namespace: wgPeerKey.Namespace,
+				},
+				Spec: v1alpha1.WireguardPeerSpec{
+					WireguardRef: wgName,
+				},
+			})).Should(Succeed())
 
-		// delete all nodes
-		nodeList := &corev1.NodeList{}
-		Expect(k8sClient.List(context.Background(), nodeList, listOpts...)).Should(Succeed())
-		for _, node := range nodeList.Items {
-			Expect(k8sClient.Delete(context.Background(), &node)).Should(Succeed())
-		}
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
 
-		// delete all secrets
-		secretList := &corev1.SecretList{}
-		Expect(k8sClient.List(context.Background(), secretList, listOpts...)).Should(Succeed())
-		for _, secret := range secretList.Items {
-			Expect(k8sClient.Delete(context.Background(), &secret)).Should(Succeed())
-		}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
 
-		// delete all configmaps
-		cList := &corev1.ConfigMapList{}
-		Expect(k8sClient.List(context.Background(), cList, listOpts...)).Should(Succeed())
-		for _, c := range cList.Items {
-			Expect(k8sClient.Delete(context.Background(), &c)).Should(Succeed())
-		}
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
 
-		// create kube-dns service
-		dnsService := &corev1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "kube-dns",
-				Namespace: "kube-system",
-			},
-			Spec: corev1.ServiceSpec{
-				ClusterIP: dnsServiceIp,
-				Ports:     []corev1.ServicePort{{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53}},
-			},
-		}
-		Expect(k8sClient.Create(context.Background(), dnsService)).Should(Succeed())
+			peerSecret := &corev1.Secret{}
+			Eventually(func() []byte {
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: wgPeerKey.Name + "-peer", Namespace: wgNamespace}, peerSecret)
+				return peerSecret.Data["configQRCode.png"]
+			}, Timeout, Interval).Should(HavePrefix("\x89PNG"))
 
-	})
-	Context("Wireguard", func() {
-		It("sets Custom address for peers through Wireguard.Spec.Address", func() {
-			expectedAddress := "test-address"
-			var expectedPort = "30000"
+			Expect(peerSecret.Data["configQRCode.txt"]).ShouldNot(BeEmpty())
 
+		})
+		It("merges the rules of the WireguardNetworkPolicies selecting a peer into the agent state", func() {
 			wgServer := &v1alpha1.Wireguard{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      wgKey.Name,
 					Namespace: wgKey.Namespace,
 				},
-				Spec: v1alpha1.WireguardSpec{
-					ServiceType: corev1.ServiceTypeNodePort,
-					Address:     expectedAddress,
-				},
 			}
 			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
 
@@ -176,55 +1241,147 @@ var _ = Describe("wireguard controller", func() {
 				Namespace: wgNamespace,
 			}
 
-			wgPeer := &v1alpha1.WireguardPeer{
+			peerPolicy := v1alpha1.EgressNetworkPolicy{Action: v1alpha1.EgressNetworkPolicyActionAccept, To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.1"}}
+			databasePolicy := v1alpha1.EgressNetworkPolicy{Action: v1alpha1.EgressNetworkPolicyActionAccept, To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.2", Port: 5432}}
+			defaultPolicy := v1alpha1.EgressNetworkPolicy{Action: v1alpha1.EgressNetworkPolicyActionDeny, To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.0/8"}}
+
+			Expect(k8sClient.Create(context.Background(), &v1alpha1.WireguardPeer{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      wgPeerKey.Name,
 					Namespace: wgPeerKey.Namespace,
+					Labels:    map[string]string{"group": "engineering"},
 				},
 				Spec: v1alpha1.WireguardPeerSpec{
-					WireguardRef: wgName,
+					WireguardRef:          wgName,
+					PublicKey:             "PeerPublicKey",
+					EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{peerPolicy},
+				},
+			})).Should(Succeed())
+
+			for _, policy := range []*v1alpha1.WireguardNetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: wgNamespace},
+					Spec:       v1alpha1.WireguardNetworkPolicySpec{Priority: 100, Egress: v1alpha1.EgressNetworkPolicies{defaultPolicy}},
 				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "engineering", Namespace: wgNamespace},
+					Spec: v1alpha1.WireguardNetworkPolicySpec{
+						PeerSelector: metav1.LabelSelector{MatchLabels: map[string]string{"group": "engineering"}},
+						Egress:       v1alpha1.EgressNetworkPolicies{databasePolicy},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "sales", Namespace: wgNamespace},
+					Spec: v1alpha1.WireguardNetworkPolicySpec{
+						PeerSelector: metav1.LabelSelector{MatchLabels: map[string]string{"group": "sales"}},
+						Egress:       v1alpha1.EgressNetworkPolicies{defaultPolicy},
+					},
+				},
+			} {
+				Expect(k8sClient.Create(context.Background(), policy)).Should(Succeed())
 			}
 
-			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
-			// service created
-			serviceName := wgKey.Name + "-svc"
 			serviceKey := types.NamespacedName{
 				Namespace: wgKey.Namespace,
-				Name:      serviceName,
+				Name:      wgKey.Name + "-svc",
 			}
-			expectedLabels := map[string]string{"app": "wireguard", "instance": wgKey.Name}
-			// match labels
-			Eventually(func() map[string]string {
-				svc := &corev1.Service{}
-				Expect(k8sClient.Get(context.Background(), serviceKey, svc)).Should(Succeed())
-				return svc.Spec.Selector
-			}, Timeout, Interval).Should(BeEquivalentTo(expectedLabels))
 
-			Expect(reconcileServiceWithTypeNodePort(serviceKey, expectedPort, 51820)).Should(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
 
-			Eventually(func() string {
-				wgPeer := &v1alpha1.WireguardPeer{}
-				Expect(k8sClient.Get(context.Background(), wgPeerKey, wgPeer)).Should(Succeed())
-				for _, line := range strings.Split(wgPeer.Status.Config, "\n") {
-					if strings.Contains(line, "Endpoint") {
-						return line
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() []string {
+				peer := &v1alpha1.WireguardPeer{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				return peer.Status.AppliedNetworkPolicies
+			}, Timeout, Interval).Should(Equal([]string{"engineering", "default"}))
+
+			Eventually(func() v1alpha1.EgressNetworkPolicies {
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgKey, secret)
+
+				var state agent.State
+				//nolint:errcheck
+				json.Unmarshal(secret.Data["state.json"], &state)
+				for _, peer := range state.Peers {
+					if peer.Name == wgPeerKey.Name {
+						return peer.Spec.EgressNetworkPolicies
 					}
 				}
-				return "Endpoint = CONFIG_NOT_SET_ERROR"
-			}, Timeout, Interval).Should(Equal("Endpoint = " + expectedAddress + ":" + fmt.Sprint(expectedPort) + "\""))
-
+				return nil
+			}, Timeout, Interval).Should(Equal(v1alpha1.EgressNetworkPolicies{peerPolicy, databasePolicy, defaultPolicy}))
 		})
-		It("sets Custom DNS through Wireguard.Spec.DNS", func() {
-
-			expectedDNS := "3.3.3.3"
+		It("disables peers outside of the windows of their Spec.AccessSchedule in the agent state", func() {
 			wgServer := &v1alpha1.Wireguard{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      wgKey.Name,
 					Namespace: wgKey.Namespace,
 				},
-				Spec: v1alpha1.WireguardSpec{
-					Dns: expectedDNS,
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			openKey := types.NamespacedName{Name: wgName + "-open", Namespace: wgNamespace}
+			closedKey := types.NamespacedName{Name: wgName + "-closed", Namespace: wgNamespace}
+
+			for _, peer := range []*v1alpha1.WireguardPeer{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: openKey.Name, Namespace: wgNamespace},
+					Spec: v1alpha1.WireguardPeerSpec{
+						WireguardRef:   wgName,
+						AccessSchedule: &v1alpha1.AccessSchedule{Windows: []v1alpha1.AccessWindow{{Start: "* * * * *", Duration: metav1.Duration{Duration: 2 * time.Minute}}}},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: closedKey.Name, Namespace: wgNamespace},
+					Spec: v1alpha1.WireguardPeerSpec{
+						WireguardRef:   wgName,
+						AccessSchedule: &v1alpha1.AccessSchedule{Windows: []v1alpha1.AccessWindow{{Start: "0 0 30 2 *", Duration: metav1.Duration{Duration: time.Hour}}}},
+					},
+				},
+			} {
+				Expect(k8sClient.Create(context.Background(), peer)).Should(Succeed())
+			}
+
+			serviceKey := types.NamespacedName{
+				Namespace: wgKey.Namespace,
+				Name:      wgKey.Name + "-svc",
+			}
+
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
+
+			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
+
+			Eventually(func() map[string]bool {
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgKey, secret)
+
+				var state agent.State
+				//nolint:errcheck
+				json.Unmarshal(secret.Data["state.json"], &state)
+				disabled := map[string]bool{}
+				for _, peer := range state.Peers {
+					disabled[peer.Name] = peer.Spec.Disabled
+				}
+				return disabled
+			}, Timeout, Interval).Should(Equal(map[string]bool{openKey.Name: false, closedKey.Name: true}))
+
+			// the schedule only applies to the state of the agent
+			peer := &v1alpha1.WireguardPeer{}
+			Expect(k8sClient.Get(context.Background(), closedKey, peer)).Should(Succeed())
+			Expect(peer.Spec.Disabled).Should(BeFalse())
+		})
+		It("leaves the state of the agent unchanged when only the status of a peer changes", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
 				},
 			}
 			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
@@ -234,7 +1391,7 @@ var _ = Describe("wireguard controller", func() {
 				Namespace: wgNamespace,
 			}
 
-			wgPeer := &v1alpha1.WireguardPeer{
+			Expect(k8sClient.Create(context.Background(), &v1alpha1.WireguardPeer{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      wgPeerKey.Name,
 					Namespace: wgPeerKey.Namespace,
@@ -242,37 +1399,45 @@ var _ = Describe("wireguard controller", func() {
 				Spec: v1alpha1.WireguardPeerSpec{
 					WireguardRef: wgName,
 				},
-			}
+			})).Should(Succeed())
 
-			Expect(k8sClient.Create(context.Background(), wgPeer)).Should(Succeed())
-			expectedLabels := map[string]string{"app": "wireguard", "instance": wgKey.Name}
-			// service created
-			serviceName := wgKey.Name + "-svc"
 			serviceKey := types.NamespacedName{
 				Namespace: wgKey.Namespace,
-				Name:      serviceName,
+				Name:      wgKey.Name + "-svc",
 			}
 
-			// match labels
-			Eventually(func() map[string]string {
-				svc := &corev1.Service{}
-				Expect(k8sClient.Get(context.Background(), serviceKey, svc)).Should(Succeed())
-				return svc.Spec.Selector
-			}, Timeout, Interval).Should(BeEquivalentTo(expectedLabels))
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), serviceKey, &corev1.Service{})
+			}, Timeout, Interval).Should(Succeed())
 
 			Expect(reconcileServiceWithTypeLoadBalancer(serviceKey, "test-address")).Should(Succeed())
 
 			Eventually(func() string {
-				wgPeer := &v1alpha1.WireguardPeer{}
-				Expect(k8sClient.Get(context.Background(), wgPeerKey, wgPeer)).Should(Succeed())
-				for _, line := range strings.Split(wgPeer.Status.Config, "\n") {
-					if strings.Contains(line, "DNS") {
-						return line
-					}
-				}
-				return "DNS = CONFIG_NOT_SET_ERROR"
-			}, Timeout, Interval).Should(Equal("DNS = " + expectedDNS))
+				peer := &v1alpha1.WireguardPeer{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgPeerKey, peer)
+				return peer.Status.Status
+			}, Timeout, Interval).Should(Equal(v1alpha1.Ready))
+
+			getState := func() string {
+				secret := &corev1.Secret{}
+				//nolint:errcheck
+				k8sClient.Get(context.Background(), wgKey, secret)
+				return string(secret.Data["state.json"])
+			}
+
+			Eventually(getState, Timeout, Interval).Should(ContainSubstring(wgPeerKey.Name))
+			state := getState()
 
+			// the stats of the peer change with every update by the agent
+			peer := &v1alpha1.WireguardPeer{}
+			Expect(k8sClient.Get(context.Background(), wgPeerKey, peer)).Should(Succeed())
+			peer.Status.RxBytes = 1024
+			peer.Status.TxBytes = 2048
+			peer.Status.Endpoint = "1.2.3.4:51820"
+			Expect(k8sClient.Status().Update(context.Background(), peer)).Should(Succeed())
+
+			Consistently(getState, Timeout, Interval).Should(Equal(state))
 		})
 		It("Should create a WG with ServiceType NodePort and WG peer successfully", func() {
 			var expectedNodePort = "30000"
@@ -339,8 +1504,7 @@ var _ = Describe("wireguard controller", func() {
 				}
 				deployment := &appsv1.Deployment{}
 				Expect(k8sClient.Get(context.Background(), deploymentKey, deployment)).Should(Succeed())
-				Expect(len(deployment.Spec.Template.Spec.Containers)).Should(Equal(2))
-				Expect(deployment.Spec.Template.Spec.Containers[0].Image).Should(Equal(deployment.Spec.Template.Spec.Containers[1].Image))
+				Expect(len(deployment.Spec.Template.Spec.Containers)).Should(Equal(1))
 				return deployment.Spec.Template.Spec.Containers[0].Image
 			}, Timeout, Interval).Should(Equal(wgTestImage))
 
@@ -380,20 +1544,27 @@ var _ = Describe("wireguard controller", func() {
 				Expect(k8sClient.Get(context.Background(), peerKey, peer)).Should(Succeed())
 				return peer.Status
 			}, Timeout, Interval).Should(Equal(v1alpha1.WireguardPeerStatus{
-				Config: fmt.Sprintf(`
-echo "
-[Interface]
-PrivateKey = $(kubectl get secret %s-peer --template={{.data.privateKey}} -n default | base64 -d)
+				ConfigRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: peerKey.Name + "-config"},
+					Key:                  "wg0.conf",
+				},
+				Status:  "ready",
+				Message: "Peer configured",
+			}))
+
+			peerSecret := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: peerKey.Name + "-peer", Namespace: peerKey.Namespace}, peerSecret)).Should(Succeed())
+
+			Expect(getPeerConfig(peerKey)).Should(Equal(fmt.Sprintf(`[Interface]
+PrivateKey = %s
 Address = %s
 DNS = %s, %s.svc.cluster.local
 
 [Peer]
 PublicKey = %s
-AllowedIPs = %s
-Endpoint = %s:%s"`, peerKey.Name, peer.Spec.AllowedIPs, peer.Spec.Address, dnsServiceIp, peer.Namespace, wgPublicKey, expectedAddress, expectedNodePort),
-				Status:  "ready",
-				Message: "Peer configured",
-			}))
+AllowedIPs = 0.0.0.0/0
+Endpoint = %s:%s
+`, peerSecret.Data["privateKey"], peer.Spec.Address, dnsServiceIp, peer.Namespace, wgPublicKey, expectedAddress, expectedNodePort)))
 
 		})
 		It("Should create a WG with ServiceType LoadBalancer and WG peer successfully", func() {
@@ -467,8 +1638,7 @@ Endpoint = %s:%s"`, peerKey.Name, peer.Spec.AllowedIPs, peer.Spec.Address, dnsSe
 				}
 				deployment := &appsv1.Deployment{}
 				Expect(k8sClient.Get(context.Background(), deploymentKey, deployment)).Should(Succeed())
-				Expect(len(deployment.Spec.Template.Spec.Containers)).Should(Equal(2))
-				Expect(deployment.Spec.Template.Spec.Containers[0].Image).Should(Equal(deployment.Spec.Template.Spec.Containers[1].Image))
+				Expect(len(deployment.Spec.Template.Spec.Containers)).Should(Equal(1))
 				return deployment.Spec.Template.Spec.Containers[0].Image
 			}, Timeout, Interval).Should(Equal(wgTestImage))
 
@@ -509,20 +1679,27 @@ Endpoint = %s:%s"`, peerKey.Name, peer.Spec.AllowedIPs, peer.Spec.Address, dnsSe
 				Expect(k8sClient.Get(context.Background(), peerKey, peer)).Should(Succeed())
 				return peer.Status
 			}, Timeout, Interval).Should(Equal(v1alpha1.WireguardPeerStatus{
-				Config: fmt.Sprintf(`
-echo "
-[Interface]
-PrivateKey = $(kubectl get secret %s-peer --template={{.data.privateKey}} -n default | base64 -d)
+				ConfigRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: peerKey.Name + "-config"},
+					Key:                  "wg0.conf",
+				},
+				Status:  "ready",
+				Message: "Peer configured",
+			}))
+
+			peerSecret := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: peerKey.Name + "-peer", Namespace: peerKey.Namespace}, peerSecret)).Should(Succeed())
+
+			Expect(getPeerConfig(peerKey)).Should(Equal(fmt.Sprintf(`[Interface]
+PrivateKey = %s
 Address = %s
 DNS = %s, %s.svc.cluster.local
 
 [Peer]
 PublicKey = %s
-AllowedIPs = %s
-Endpoint = %s:%s"`, peerKey.Name, peer.Spec.AllowedIPs, peer.Spec.Address, dnsServiceIp, peer.Namespace, wgPublicKey, expectedExternalHostName, wg.Status.Port),
-				Status:  "ready",
-				Message: "Peer configured",
-			}))
+AllowedIPs = 0.0.0.0/0
+Endpoint = %s:%s
+`, peerSecret.Data["privateKey"], peer.Spec.Address, dnsServiceIp, peer.Namespace, wgPublicKey, expectedExternalHostName, wg.Status.Port)))
 
 			Eventually(func() error {
 				return k8sClient.Get(context.Background(), wgSecretKeyName, wgSecret)
@@ -607,8 +1784,7 @@ Endpoint = %s:%s"`, peerKey.Name, peer.Spec.AllowedIPs, peer.Spec.Address, dnsSe
 				}
 				deployment := &appsv1.Deployment{}
 				Expect(k8sClient.Get(context.Background(), deploymentKey, deployment)).Should(Succeed())
-				Expect(len(deployment.Spec.Template.Spec.Containers)).Should(Equal(2))
-				Expect(deployment.Spec.Template.Spec.Containers[0].Image).Should(Equal(deployment.Spec.Template.Spec.Containers[1].Image))
+				Expect(len(deployment.Spec.Template.Spec.Containers)).Should(Equal(1))
 				return deployment.Spec.Template.Spec.Containers[0].Image
 			}, Timeout, Interval).Should(Equal(wgTestImage))
 
@@ -649,27 +1825,75 @@ Endpoint = %s:%s"`, peerKey.Name, peer.Spec.AllowedIPs, peer.Spec.Address, dnsSe
 				Expect(k8sClient.Get(context.Background(), peerKey, peer)).Should(Succeed())
 				return peer.Status
 			}, Timeout, Interval).Should(Equal(v1alpha1.WireguardPeerStatus{
-				Config: fmt.Sprintf(`
-			echo "
-			[Interface]
-			PrivateKey = $(kubectl get secret %s-peer --template={{.data.privateKey}} -n default | base64 -d)
-			Address = %s
-			DNS = %s, %s.svc.cluster.local
-
-			[Peer]
-			PublicKey = %s
-			AllowedIPs = 0.0.0.0/0
-			Endpoint = %s:%s"`, peerKey.Name, peer.Spec.Address, dnsServiceIp, peer.Namespace, wgPublicKey, expectedAddress, wg.Status.Port),
+				ConfigRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: peerKey.Name + "-config"},
+					Key:                  "wg0.conf",
+				},
 				Status:  "ready",
 				Message: "Peer configured",
 			}))
 
+			peerSecret := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: peerKey.Name + "-peer", Namespace: peerKey.Namespace}, peerSecret)).Should(Succeed())
+
+			Expect(getPeerConfig(peerKey)).Should(Equal(fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = %s
+DNS = %s, %s.svc.cluster.local
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 0.0.0.0/0
+Endpoint = %s:%s
+`, peerSecret.Data["privateKey"], peer.Spec.Address, dnsServiceIp, peer.Namespace, wgPublicKey, expectedAddress, wg.Status.Port)))
+
 			Eventually(func() error {
 				return k8sClient.Get(context.Background(), wgSecretKeyName, wgSecret)
 			}, Timeout, Interval).Should(Succeed())
 
 		})
 
+		It("updates the deployment when Spec.ListenPort changes", func() {
+			wgServer := &v1alpha1.Wireguard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      wgKey.Name,
+					Namespace: wgKey.Namespace,
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), wgServer)).Should(Succeed())
+
+			depKey := types.NamespacedName{Namespace: wgKey.Namespace, Name: wgKey.Name + "-dep"}
+
+			agentPorts := func() []int32 {
+				dep := &appsv1.Deployment{}
+				if err := k8sClient.Get(context.Background(), depKey, dep); err != nil {
+					return nil
+				}
+				var ports []int32
+				for _, c := range dep.Spec.Template.Spec.Containers {
+					if c.Name == "agent" {
+						for _, port := range c.Ports {
+							ports = append(ports, port.ContainerPort)
+						}
+					}
+				}
+				return ports
+			}
+
+			Eventually(agentPorts, Timeout, Interval).Should(ContainElement(int32(51820)))
+
+			Eventually(func() error {
+				wg := &v1alpha1.Wireguard{}
+				if err := k8sClient.Get(context.Background(), wgKey, wg); err != nil {
+					return err
+				}
+				wg.Spec.ListenPort = 51821
+				return k8sClient.Update(context.Background(), wg)
+			}, Timeout, Interval).Should(Succeed())
+
+			Eventually(agentPorts, Timeout, Interval).Should(And(ContainElement(int32(51821)), Not(ContainElement(int32(51820)))))
+		})
+
 		for _, useWgUserspace := range []bool{true, false} {
 			testTextPrefix := "uses"
 			if !useWgUserspace {
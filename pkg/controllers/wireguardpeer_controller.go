@@ -17,33 +17,61 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
 
+	"github.com/skip2/go-qrcode"
 	wgtypes "golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+const presharedKeySecretKey = "presharedKey"
+const nextPresharedKeySecretKey = "nextPresharedKey"
+
+const defaultPresharedKeyRotationGracePeriod = 24 * time.Hour
+
+// peerExpiryWarningPeriod is how long before the expiry of a peer a warning event is recorded
+const peerExpiryWarningPeriod = time.Hour
+
+const configQRCodePNGSecretKey = "configQRCode.png"
+const configQRCodeTextSecretKey = "configQRCode.txt"
+
+// configQRCodePNGSize is the width and height in pixels of the QR code image
+const configQRCodePNGSize = 512
+
 // WireguardPeerReconciler reconciles a WireguardPeer object
 type WireguardPeerReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder emits the events of the peers.
+	Recorder record.EventRecorder
 }
 
+// updateStatus sets the status and message of the peer together with its conditions. The status is only updated if
+// it changed.
 func (r *WireguardPeerReconciler) updateStatus(ctx context.Context, peer *v1alpha1.WireguardPeer, status string, message string) error {
 	newPeer := peer.DeepCopy()
-	if newPeer.Status.Status != status || newPeer.Status.Message != message {
-		newPeer.Status.Status = status
-		newPeer.Status.Message = message
+	newPeer.Status.Status = status
+	newPeer.Status.Message = message
+	setPeerConditions(newPeer)
 
+	if !equality.Semantic.DeepEqual(peer.Status, newPeer.Status) {
 		if err := r.Status().Update(ctx, newPeer); err != nil {
 			return err
 		}
@@ -51,15 +79,29 @@ func (r *WireguardPeerReconciler) updateStatus(ctx context.Context, peer *v1alph
 	return nil
 }
 
-func (r *WireguardPeerReconciler) secretForPeer(m *v1alpha1.WireguardPeer, privateKey string, publicKey string) *corev1.Secret {
-	ls := labelsForWireguard(m.Name)
+func peerSecretName(peer *v1alpha1.WireguardPeer) string {
+	return peer.Name + "-peer"
+}
+
+// peerDataSecretName returns the name of the secret holding the generated preshared key and the QR codes of the peer.
+// Peers with a private key of the user get no secret of their own, their data is kept next to their configuration.
+func peerDataSecretName(peer *v1alpha1.WireguardPeer) string {
+	if hasUserPrivateKey(peer) {
+		return peerConfigSecretName(peer)
+	}
+
+	return peerSecretName(peer)
+}
+
+func (r *WireguardPeerReconciler) secretForPeer(m *v1alpha1.WireguardPeer, name string, data map[string][]byte) *corev1.Secret {
+	ls := labelsForPeer(m.Name)
 	dep := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name + "-peer",
+			Name:      name,
 			Namespace: m.Namespace,
 			Labels:    ls,
 		},
-		Data: map[string][]byte{"privateKey": []byte(privateKey), "publicKey": []byte(publicKey)},
+		Data: data,
 	}
 	// Set Nodered instance as the owner and controller
 	ctrl.SetControllerReference(m, dep, r.Scheme)
@@ -68,9 +110,374 @@ func (r *WireguardPeerReconciler) secretForPeer(m *v1alpha1.WireguardPeer, priva
 
 }
 
+// setPeerSecretData sets the given keys in the data secret of the peer and removes the keys listed in remove. The
+// secret is created if it does not exist yet, a secret of the same name that is not owned by the peer is left as is.
+func (r *WireguardPeerReconciler) setPeerSecretData(ctx context.Context, peer *v1alpha1.WireguardPeer, data map[string][]byte, remove ...string) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: peerDataSecretName(peer), Namespace: peer.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return r.Create(ctx, r.secretForPeer(peer, peerDataSecretName(peer), data))
+	} else if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(secret, peer) {
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "SecretConflict", "Secret %s exists and is not owned by the peer", secret.Name)
+		return fmt.Errorf("secret %s/%s is not owned by peer %s", secret.Namespace, secret.Name, peer.Name)
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	for key, value := range data {
+		secret.Data[key] = value
+	}
+
+	for _, key := range remove {
+		delete(secret.Data, key)
+	}
+
+	return r.Update(ctx, secret)
+}
+
+// hasUserPrivateKey returns true if the private key of the peer is provided by the user through a secret, instead of
+// being generated by the operator into the peer secret.
+func hasUserPrivateKey(peer *v1alpha1.WireguardPeer) bool {
+	name := peer.Spec.PrivateKey.SecretKeyRef.Name
+	return name != "" && name != peerSecretName(peer)
+}
+
+// reconcileUserPrivateKey derives the public key of the peer from the private key in the secret provided by the user.
+// It returns true if the public key of the peer was updated, and otherwise a message describing why the private key
+// cannot be used, if it cannot.
+func (r *WireguardPeerReconciler) reconcileUserPrivateKey(ctx context.Context, peer *v1alpha1.WireguardPeer) (bool, string, error) {
+	log := ctrllog.FromContext(ctx)
+	secretKeyRef := peer.Spec.PrivateKey.SecretKeyRef
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretKeyRef.Name, Namespace: peer.Namespace}, secret)
+	if err != nil && errors.IsNotFound(err) {
+		return false, fmt.Sprintf("Secret %s holding the private key of the peer does not exist", secretKeyRef.Name), nil
+	} else if err != nil {
+		return false, "", err
+	}
+
+	value, ok := secret.Data[secretKeyRef.Key]
+	if !ok {
+		return false, fmt.Sprintf("Secret %s has no key %s holding the private key of the peer", secretKeyRef.Name, secretKeyRef.Key), nil
+	}
+
+	key, err := wgtypes.ParseKey(strings.TrimSpace(string(value)))
+	if err != nil {
+		return false, fmt.Sprintf("Key %s of secret %s does not hold a valid private key: %v", secretKeyRef.Key, secretKeyRef.Name, err), nil
+	}
+
+	publicKey := key.PublicKey().String()
+	if peer.Spec.PublicKey == publicKey {
+		return false, "", nil
+	}
+
+	log.Info("Deriving public key from the private key secret", "secret.Namespace", peer.Namespace, "secret.Name", secretKeyRef.Name)
+	peer.Spec.PublicKey = publicKey
+	if err := r.Update(ctx, peer); err != nil {
+		return false, "", err
+	}
+	r.Recorder.Eventf(peer, corev1.EventTypeNormal, "PublicKeyDerived", "Derived the public key %s from the private key in secret %s", publicKey, secretKeyRef.Name)
+
+	return true, "", nil
+}
+
+// reconcilePresharedKey generates the preshared key of the peer and rotates it when it is due. It returns true if
+// the peer was updated, and otherwise the duration after which the next rotation step is due.
+func (r *WireguardPeerReconciler) reconcilePresharedKey(ctx context.Context, peer *v1alpha1.WireguardPeer) (bool, time.Duration, error) {
+	log := ctrllog.FromContext(ctx)
+	presharedKey := peer.Spec.PresharedKey
+
+	if presharedKey.SecretKeyRef.Name == "" {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			return false, 0, err
+		}
+
+		log.Info("Generating preshared key", "secret.Namespace", peer.Namespace, "secret.Name", peerDataSecretName(peer))
+		if err := r.setPeerSecretData(ctx, peer, map[string][]byte{presharedKeySecretKey: []byte(key.String())}); err != nil {
+			return false, 0, err
+		}
+		r.Recorder.Eventf(peer, corev1.EventTypeNormal, "PresharedKeyGenerated", "Generated the preshared key and stored it in secret %s", peerDataSecretName(peer))
+
+		peer.Spec.PresharedKey.SecretKeyRef = corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: peerDataSecretName(peer)},
+			Key:                  presharedKeySecretKey,
+		}
+		if err := r.Update(ctx, peer); err != nil {
+			return false, 0, err
+		}
+
+		peer.Status.PresharedKeyLastRotationTime = &metav1.Time{Time: time.Now()}
+		return true, 0, r.Status().Update(ctx, peer)
+	}
+
+	// preshared keys provided by the user are never rotated
+	if presharedKey.SecretKeyRef.Name != peerDataSecretName(peer) {
+		return false, 0, nil
+	}
+
+	now := time.Now()
+
+	if switchTime := peer.Status.PresharedKeySwitchTime; switchTime != nil {
+		if now.Before(switchTime.Time) {
+			return false, switchTime.Sub(now), nil
+		}
+
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: peerDataSecretName(peer), Namespace: peer.Namespace}, secret); err != nil {
+			return false, 0, err
+		}
+
+		if nextKey, ok := secret.Data[nextPresharedKeySecretKey]; ok {
+			log.Info("Switching to the next preshared key", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
+			if err := r.setPeerSecretData(ctx, peer, map[string][]byte{presharedKey.SecretKeyRef.Key: nextKey}, nextPresharedKeySecretKey); err != nil {
+				return false, 0, err
+			}
+			r.Recorder.Event(peer, corev1.EventTypeNormal, "PresharedKeySwitched", "Switched to the next preshared key")
+		}
+
+		peer.Status.PresharedKeySwitchTime = nil
+		peer.Status.PresharedKeyLastRotationTime = &metav1.Time{Time: now}
+		peer.Status.NextConfigRef = nil
+		return true, 0, r.Status().Update(ctx, peer)
+	}
+
+	if presharedKey.RotationPeriod == nil {
+		return false, 0, nil
+	}
+
+	lastRotation := peer.CreationTimestamp.Time
+	if peer.Status.PresharedKeyLastRotationTime != nil {
+		lastRotation = peer.Status.PresharedKeyLastRotationTime.Time
+	}
+
+	nextRotation := lastRotation.Add(presharedKey.RotationPeriod.Duration)
+	if now.Before(nextRotation) {
+		return false, nextRotation.Sub(now), nil
+	}
+
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return false, 0, err
+	}
+
+	log.Info("Rotating preshared key", "secret.Namespace", peer.Namespace, "secret.Name", peerDataSecretName(peer))
+	if err := r.setPeerSecretData(ctx, peer, map[string][]byte{nextPresharedKeySecretKey: []byte(key.String())}); err != nil {
+		return false, 0, err
+	}
+
+	gracePeriod := defaultPresharedKeyRotationGracePeriod
+	if presharedKey.RotationGracePeriod != nil {
+		gracePeriod = presharedKey.RotationGracePeriod.Duration
+	}
+
+	r.Recorder.Eventf(peer, corev1.EventTypeNormal, "PresharedKeyRotated", "Generated the next preshared key, the server switches to it in %s", gracePeriod)
+	peer.Status.PresharedKeySwitchTime = &metav1.Time{Time: now.Add(gracePeriod)}
+	return true, 0, r.Status().Update(ctx, peer)
+}
+
+// reconcilePeerKey rotates the private key of the peer when it is requested through the RotateKeyAnnotation or due
+// according to Spec.KeyRotationPeriod. The new public key replaces the old one on the wg server right away. It returns
+// true if the peer was updated, and otherwise the duration after which the next rotation is due.
+func (r *WireguardPeerReconciler) reconcilePeerKey(ctx context.Context, peer *v1alpha1.WireguardPeer) (bool, time.Duration, error) {
+	log := ctrllog.FromContext(ctx)
+
+	// keys provided by the user are never rotated
+	if peer.Spec.PrivateKey.SecretKeyRef.Name != peerSecretName(peer) {
+		return false, 0, nil
+	}
+
+	now := time.Now()
+
+	reason := ""
+	request := peer.Annotations[v1alpha1.RotateKeyAnnotation]
+	if request != "" && request != peer.Status.KeyRotationRequest {
+		reason = v1alpha1.KeyRotationRequested
+	}
+
+	var nextRotation *metav1.Time
+	if period := peer.Spec.KeyRotationPeriod; period != nil {
+		lastRotation := peer.CreationTimestamp.Time
+		if peer.Status.KeyLastRotationTime != nil {
+			lastRotation = peer.Status.KeyLastRotationTime.Time
+		}
+
+		nextRotation = &metav1.Time{Time: lastRotation.Add(period.Duration)}
+		if reason == "" && !now.Before(nextRotation.Time) {
+			reason = v1alpha1.KeyRotationScheduled
+		}
+	}
+
+	if reason == "" {
+		if !peer.Status.KeyNextRotationTime.Equal(nextRotation) {
+			peer.Status.KeyNextRotationTime = nextRotation
+			return true, 0, r.Status().Update(ctx, peer)
+		}
+
+		if nextRotation == nil {
+			return false, 0, nil
+		}
+
+		return false, nextRotation.Sub(now), nil
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return false, 0, err
+	}
+	publicKey := key.PublicKey().String()
+
+	log.Info("Rotating peer key", "secret.Namespace", peer.Namespace, "secret.Name", peerSecretName(peer), "reason", reason)
+	if err := r.setPeerSecretData(ctx, peer, map[string][]byte{peer.Spec.PrivateKey.SecretKeyRef.Key: []byte(key.String()), "publicKey": []byte(publicKey)}); err != nil {
+		return false, 0, err
+	}
+
+	peer.Spec.PublicKey = publicKey
+	if err := r.Update(ctx, peer); err != nil {
+		return false, 0, err
+	}
+	r.Recorder.Eventf(peer, corev1.EventTypeNormal, "KeyRotated", "Rotated the key of the peer, the new public key is %s", publicKey)
+
+	peer.Status.KeyLastRotationTime = &metav1.Time{Time: now}
+	peer.Status.KeyNextRotationTime = nil
+	if period := peer.Spec.KeyRotationPeriod; period != nil {
+		peer.Status.KeyNextRotationTime = &metav1.Time{Time: now.Add(period.Duration)}
+	}
+	peer.Status.KeyRotationRequest = request
+	return true, 0, r.Status().Update(ctx, peer)
+}
+
+// peerExpiryTime returns the time the peer expires at according to Spec.ExpiresAt and Spec.ValidFor, or nil if it
+// never expires.
+func peerExpiryTime(peer *v1alpha1.WireguardPeer) *metav1.Time {
+	expiryTime := peer.Spec.ExpiresAt
+	if peer.Spec.ValidFor != nil {
+		validUntil := &metav1.Time{Time: peer.CreationTimestamp.Add(peer.Spec.ValidFor.Duration)}
+		if expiryTime == nil || validUntil.Before(expiryTime) {
+			expiryTime = validUntil
+		}
+	}
+
+	return expiryTime
+}
+
+// reconcileExpiry records a warning event shortly before the peer expires, and disables or deletes the peer according
+// to Spec.ExpiryPolicy once it expired. It returns true if the peer was updated or deleted, and otherwise the duration
+// after which the next expiry step is due.
+func (r *WireguardPeerReconciler) reconcileExpiry(ctx context.Context, peer *v1alpha1.WireguardPeer) (bool, time.Duration, error) {
+	log := ctrllog.FromContext(ctx)
+
+	expiryTime := peerExpiryTime(peer)
+	if !peer.Status.ExpiryTime.Equal(expiryTime) {
+		peer.Status.ExpiryTime = expiryTime
+		peer.Status.ExpiryWarningTime = nil
+		return true, 0, r.Status().Update(ctx, peer)
+	}
+
+	if expiryTime == nil {
+		return false, 0, nil
+	}
+
+	now := time.Now()
+	expiry := expiryTime.UTC().Format(time.RFC3339)
+
+	if now.Before(expiryTime.Time) {
+		warningTime := expiryTime.Add(-peerExpiryWarningPeriod)
+		if now.Before(warningTime) {
+			return false, warningTime.Sub(now), nil
+		}
+
+		if peer.Status.ExpiryWarningTime == nil {
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "ExpiresSoon", "The peer expires at %s", expiry)
+			peer.Status.ExpiryWarningTime = &metav1.Time{Time: now}
+			return true, 0, r.Status().Update(ctx, peer)
+		}
+
+		return false, expiryTime.Sub(now), nil
+	}
+
+	if peer.Spec.ExpiryPolicy == v1alpha1.ExpiryPolicyDelete {
+		log.Info("Deleting expired peer", "expiryTime", expiry)
+		r.Recorder.Eventf(peer, corev1.EventTypeNormal, "Expired", "Deleting the peer since it expired at %s", expiry)
+		return true, 0, client.IgnoreNotFound(r.Delete(ctx, peer))
+	}
+
+	if peer.Spec.Disabled {
+		return false, 0, nil
+	}
+
+	log.Info("Disabling expired peer", "expiryTime", expiry)
+	peer.Spec.Disabled = true
+	if err := r.Update(ctx, peer); err != nil {
+		return false, 0, err
+	}
+	r.Recorder.Eventf(peer, corev1.EventTypeNormal, "Expired", "Disabled the peer since it expired at %s", expiry)
+
+	return true, 0, nil
+}
+
+// shorterRequeue returns the shorter of two requeue durations, ignoring durations of zero.
+func shorterRequeue(a time.Duration, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+// syncConfigQRCode renders the configuration of the peer as a QR code, both as PNG image and as text for terminals,
+// and stores it in the peer secret so that mobile clients can import the configuration directly.
+func (r *WireguardPeerReconciler) syncConfigQRCode(ctx context.Context, peer *v1alpha1.WireguardPeer) error {
+	configRef := peer.Status.ConfigRef
+	configSecret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: configRef.Name, Namespace: peer.Namespace}, configSecret)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	config, ok := configSecret.Data[configRef.Key]
+	if !ok {
+		return nil
+	}
+
+	qr, err := qrcode.New(string(config), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+
+	png, err := qr.PNG(configQRCodePNGSize)
+	if err != nil {
+		return err
+	}
+
+	text := []byte(qr.ToSmallString(false))
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: peerDataSecretName(peer), Namespace: peer.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if bytes.Equal(secret.Data[configQRCodePNGSecretKey], png) && bytes.Equal(secret.Data[configQRCodeTextSecretKey], text) {
+		return nil
+	}
+
+	return r.setPeerSecretData(ctx, peer, map[string][]byte{configQRCodePNGSecretKey: png, configQRCodeTextSecretKey: text})
+}
+
 //+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguardpeers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguardpeers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=vpn.wireguard-operator.io,resources=wireguardpeers/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -102,6 +509,7 @@ func (r *WireguardPeerReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	key, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
 		log.Error(err, "Failed to generate private key")
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "GenerateKeysFailed", "Failed to generate the keys of the peer: %v", err)
 		return ctrl.Result{}, err
 	}
 
@@ -116,16 +524,45 @@ func (r *WireguardPeerReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	if peer.Spec.PublicKey == "" {
+	updated, expiryAfter, err := r.reconcileExpiry(ctx, newPeer)
+	if err != nil {
+		log.Error(err, "Failed to reconcile the expiry of the peer")
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "ExpiryFailed", "Failed to disable or delete the expired peer: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	if updated {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if hasUserPrivateKey(newPeer) {
+		updated, message, err := r.reconcileUserPrivateKey(ctx, newPeer)
+		if err != nil {
+			log.Error(err, "Failed to read the private key of the peer")
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "GetPrivateKeyFailed", "Failed to read the private key of the peer: %v", err)
+			return ctrl.Result{}, err
+		}
+
+		if message != "" {
+			log.Info("Waiting for a valid private key", "reason", message)
+			r.Recorder.Event(peer, corev1.EventTypeWarning, "PrivateKeyInvalid", message)
+			return ctrl.Result{}, r.updateStatus(ctx, newPeer, v1alpha1.Error, message)
+		}
+
+		if updated {
+			return ctrl.Result{Requeue: true}, nil
+		}
+	} else if peer.Spec.PublicKey == "" {
 		privateKey := key.String()
 		publicKey := key.PublicKey().String()
 
-		secret := r.secretForPeer(peer, privateKey, publicKey)
+		secret := r.secretForPeer(peer, peerSecretName(peer), map[string][]byte{"privateKey": []byte(privateKey), "publicKey": []byte(publicKey)})
 
 		log.Info("Creating a new secret", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
 		err = r.Create(ctx, secret)
 		if err != nil {
 			log.Error(err, "Failed to create new secret", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "CreateSecretFailed", "Failed to create secret %s: %v", secret.Name, err)
 			return ctrl.Result{}, err
 		}
 
@@ -136,28 +573,59 @@ func (r *WireguardPeerReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 
 		if err != nil {
 			log.Error(err, "Failed to create new peer", "secret.Namespace", secret.Namespace, "secret.Name", secret.Name)
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "UpdateFailed", "Failed to store the public key of the peer: %v", err)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(peer, corev1.EventTypeNormal, "KeysGenerated", "Generated the keys of the peer and stored the private key in secret %s", secret.Name)
 
 		return ctrl.Result{Requeue: true}, nil
 
 	}
 
+	updated, keyRotationAfter, err := r.reconcilePeerKey(ctx, newPeer)
+	if err != nil {
+		log.Error(err, "Failed to rotate the peer key")
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "KeyRotationFailed", "Failed to rotate the key of the peer: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	if updated {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	requeueAfter := shorterRequeue(expiryAfter, keyRotationAfter)
+	if newPeer.Spec.PresharedKey != nil {
+		updated, after, err := r.reconcilePresharedKey(ctx, newPeer)
+		if err != nil {
+			log.Error(err, "Failed to reconcile preshared key")
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "PresharedKeyFailed", "Failed to generate or rotate the preshared key: %v", err)
+			return ctrl.Result{}, err
+		}
+
+		if updated {
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		requeueAfter = shorterRequeue(requeueAfter, after)
+	}
+
 	wireguard := &v1alpha1.Wireguard{}
 	err = r.Get(ctx, types.NamespacedName{Name: newPeer.Spec.WireguardRef, Namespace: newPeer.Namespace}, wireguard)
 
 	if err != nil {
 		if errors.IsNotFound(err) {
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "WireguardNotFound", "Wireguard %s does not exist", newPeer.Spec.WireguardRef)
 			err = r.updateStatus(ctx, newPeer, v1alpha1.Error, fmt.Sprintf("Waiting for wireguard resource '%s' to be created", newPeer.Spec.WireguardRef))
 
 			if err != nil {
 				return ctrl.Result{}, err
 			}
 
-			return ctrl.Result{}, nil
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
 		}
 
 		log.Error(err, "Failed to get wireguard")
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "GetWireguardFailed", "Failed to get wireguard %s: %v", newPeer.Spec.WireguardRef, err)
 
 		return ctrl.Result{}, err
 
@@ -172,7 +640,7 @@ func (r *WireguardPeerReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 			return ctrl.Result{}, err
 		}
 
-		return ctrl.Result{}, nil
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
 	}
 
 	wireguardSecret := &corev1.Secret{}
@@ -184,6 +652,7 @@ func (r *WireguardPeerReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 
 		if err != nil {
 			log.Error(err, "Failed to update peer with controller reference")
+			r.Recorder.Eventf(peer, corev1.EventTypeWarning, "UpdateFailed", "Failed to set the owner of the peer: %v", err)
 			return ctrl.Result{}, err
 		}
 
@@ -192,21 +661,62 @@ func (r *WireguardPeerReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	if newPeer.Status.Config == "" {
+	if newPeer.Status.ConfigRef == nil {
 		err = r.updateStatus(ctx, newPeer, v1alpha1.Pending, "Waiting config to be updated")
 
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
+	} else if err := r.syncConfigQRCode(ctx, newPeer); err != nil {
+		log.Error(err, "Failed to render the QR code of the peer configuration")
+		r.Recorder.Eventf(peer, corev1.EventTypeWarning, "RenderQRCodeFailed", "Failed to render the QR code of the configuration: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// privateKeySecretField indexes peers by the name of the secret holding the private key provided by the user.
+const privateKeySecretField = ".spec.privateKeyRef.secretKeyRef.name"
+
+// indexPrivateKeySecret returns the name of the secret holding the private key of a peer, unless the operator
+// generated the key.
+func indexPrivateKeySecret(object client.Object) []string {
+	peer, ok := object.(*v1alpha1.WireguardPeer)
+	if !ok || !hasUserPrivateKey(peer) {
+		return nil
+	}
+
+	return []string{peer.Spec.PrivateKey.SecretKeyRef.Name}
+}
+
+// peersForSecret maps a secret to the peers of its namespace whose private key it holds, so that their public keys are
+// derived again when it changes.
+func (r *WireguardPeerReconciler) peersForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	peers := &v1alpha1.WireguardPeerList{}
+	if err := r.List(ctx, peers, client.InNamespace(secret.GetNamespace()), client.MatchingFields{privateKeySecretField: secret.GetName()}); err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Failed to fetch list of peers")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, peer := range peers.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: peer.Name, Namespace: peer.Namespace}})
 	}
 
-	return ctrl.Result{}, nil
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *WireguardPeerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.WireguardPeer{}, privateKeySecretField, indexPrivateKeySecret); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.WireguardPeer{}).
+		Owns(&corev1.Secret{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.peersForSecret)).
 		Complete(r)
 }
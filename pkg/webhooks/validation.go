@@ -0,0 +1,203 @@
+package webhooks
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/robfig/cron/v3"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validateKey validates a base64 encoded wireguard key.
+func validateKey(path *field.Path, key string) field.ErrorList {
+	if _, err := wgtypes.ParseKey(key); err != nil {
+		return field.ErrorList{field.Invalid(path, key, err.Error())}
+	}
+
+	return nil
+}
+
+// validateMtu validates an MTU given as string. An empty MTU is valid.
+func validateMtu(path *field.Path, mtu string) field.ErrorList {
+	if mtu == "" {
+		return nil
+	}
+
+	value, err := strconv.Atoi(mtu)
+	if err != nil || value < 1 || value > 65535 {
+		return field.ErrorList{field.Invalid(path, mtu, "must be a number between 1 and 65535")}
+	}
+
+	return nil
+}
+
+// validateRotation validates that the rotation period and the grace period of a key are positive and that the grace
+// period ends before the next rotation is due.
+func validateRotation(path *field.Path, period *metav1.Duration, gracePeriod *metav1.Duration) field.ErrorList {
+	var errs field.ErrorList
+	if period != nil && period.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("rotationPeriod"), period.Duration.String(), "must be positive"))
+	}
+
+	if gracePeriod != nil && gracePeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("rotationGracePeriod"), gracePeriod.Duration.String(), "must not be negative"))
+	}
+
+	if period != nil && gracePeriod != nil && gracePeriod.Duration >= period.Duration {
+		errs = append(errs, field.Invalid(path.Child("rotationGracePeriod"), gracePeriod.Duration.String(), "must be shorter than rotationPeriod"))
+	}
+
+	return errs
+}
+
+// validateAccessSchedule validates the time zone of an access schedule and the start and duration of its windows.
+func validateAccessSchedule(path *field.Path, schedule *v1alpha1.AccessSchedule) field.ErrorList {
+	if schedule == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+		errs = append(errs, field.Invalid(path.Child("timeZone"), schedule.TimeZone, err.Error()))
+	}
+
+	for i, window := range schedule.Windows {
+		if _, err := cron.ParseStandard(window.Start); err != nil {
+			errs = append(errs, field.Invalid(path.Child("windows").Index(i).Child("start"), window.Start, err.Error()))
+		}
+
+		if window.Duration.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child("windows").Index(i).Child("duration"), window.Duration.Duration.String(), "must be positive"))
+		}
+	}
+
+	return errs
+}
+
+// validateAddressOrCidr validates an IP address or a CIDR.
+func validateAddressOrCidr(path *field.Path, address string) field.ErrorList {
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(address); err != nil {
+		return field.ErrorList{field.Invalid(path, address, "must be an IP address or a CIDR")}
+	}
+
+	return nil
+}
+
+// validateDestination validates the destination of an egress network policy: an IP address, a CIDR or a hostname,
+// which the agent resolves when it applies the rules.
+func validateDestination(path *field.Path, destination string) field.ErrorList {
+	if net.ParseIP(destination) != nil {
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(destination); err == nil {
+		return nil
+	}
+
+	// strings of digits and dots are malformed IPv4 addresses rather than hostnames
+	if strings.Trim(destination, "0123456789.") == "" || len(validation.IsDNS1123Subdomain(destination)) != 0 {
+		return field.ErrorList{field.Invalid(path, destination, "must be an IP address, a CIDR or a hostname")}
+	}
+
+	return nil
+}
+
+// validateCidr validates a CIDR.
+func validateCidr(path *field.Path, cidr string) field.ErrorList {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return field.ErrorList{field.Invalid(path, cidr, "must be a CIDR")}
+	}
+
+	return nil
+}
+
+// validateAllowedIPs validates a comma separated list of IP addresses and CIDRs as used by wg-quick.
+func validateAllowedIPs(path *field.Path, allowedIPs string) field.ErrorList {
+	if allowedIPs == "" {
+		return nil
+	}
+
+	var errs field.ErrorList
+	for _, address := range strings.Split(allowedIPs, ",") {
+		if address = strings.TrimSpace(address); net.ParseIP(address) == nil {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				errs = append(errs, field.Invalid(path, allowedIPs, address+" is not an IP address or a CIDR"))
+			}
+		}
+	}
+
+	return errs
+}
+
+// validatePortRange validates that the end of a port range does not come before its start.
+func validatePortRange(path *field.Path, port int32, endPort int32) field.ErrorList {
+	if endPort != 0 && endPort < port {
+		return field.ErrorList{field.Invalid(path.Child("endPort"), endPort, "must not be lower than port")}
+	}
+
+	return nil
+}
+
+// networkPolicyActions are the actions of network policies the agent translates into rules. An empty action rejects
+// the traffic.
+var networkPolicyActions = []string{"", "Accept", "ACCEPT", "Reject", "REJECT"}
+
+// validateAction validates the action of a network policy.
+func validateAction(path *field.Path, action v1alpha1.EgressNetworkPolicyAction) field.ErrorList {
+	for _, supported := range networkPolicyActions {
+		if string(action) == supported {
+			return nil
+		}
+	}
+
+	return field.ErrorList{field.NotSupported(path, action, networkPolicyActions[1:])}
+}
+
+// validateEgressNetworkPolicies validates the actions, addresses and ports of egress network policies.
+func validateEgressNetworkPolicies(path *field.Path, policies v1alpha1.EgressNetworkPolicies) field.ErrorList {
+	var errs field.ErrorList
+	for i, policy := range policies {
+		errs = append(errs, validateAction(path.Index(i).Child("action"), policy.Action)...)
+		to := path.Index(i).Child("to")
+		if policy.To.Ip != "" {
+			errs = append(errs, validateDestination(to.Child("ip"), policy.To.Ip)...)
+		}
+		for j, ip := range policy.To.Ips {
+			errs = append(errs, validateDestination(to.Child("ips").Index(j), ip)...)
+		}
+		for j, cidr := range policy.To.Except {
+			errs = append(errs, validateCidr(to.Child("except").Index(j), cidr)...)
+		}
+		errs = append(errs, validatePortRange(to, policy.To.Port, policy.To.EndPort)...)
+	}
+
+	return errs
+}
+
+// validateIngressNetworkPolicies validates the actions, addresses and ports of ingress network policies.
+func validateIngressNetworkPolicies(path *field.Path, policies v1alpha1.IngressNetworkPolicies) field.ErrorList {
+	var errs field.ErrorList
+	for i, policy := range policies {
+		errs = append(errs, validateAction(path.Index(i).Child("action"), policy.Action)...)
+		from := path.Index(i).Child("from")
+		if policy.From.Ip != "" {
+			errs = append(errs, validateAddressOrCidr(from.Child("ip"), policy.From.Ip)...)
+		}
+		for j, ip := range policy.From.Ips {
+			errs = append(errs, validateAddressOrCidr(from.Child("ips").Index(j), ip)...)
+		}
+		errs = append(errs, validatePortRange(path.Index(i).Child("to"), policy.To.Port, policy.To.EndPort)...)
+	}
+
+	return errs
+}
@@ -0,0 +1,316 @@
+package webhooks
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testPublicKey      = "hRgJ0d9l7s+u2nqT0CV7QlUOmZbN7BPTbEEQXwXkqWY="
+	otherTestPublicKey = "U6nCpGFzdMYB2WXuWDHjBtzZ/XvHbYWXfzUWDZpiEyY="
+)
+
+func TestValidateWireguard(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    v1alpha1.WireguardSpec
+		old     *v1alpha1.WireguardSpec
+		invalid []string
+	}{
+		{name: "defaults"},
+		{name: "dual-stack", spec: v1alpha1.WireguardSpec{Mtu: "1420", Network: v1alpha1.WireguardNetwork{Cidr: "10.0.0.0/16", Ipv6Cidr: "fd00::/64"}}},
+		{name: "invalid cidr", spec: v1alpha1.WireguardSpec{Network: v1alpha1.WireguardNetwork{Cidr: "10.0.0.0/33"}}, invalid: []string{"spec.network"}},
+		{name: "gateway outside of network", spec: v1alpha1.WireguardSpec{Network: v1alpha1.WireguardNetwork{Cidr: "10.0.0.0/24", Gateway: "10.0.1.1"}}, invalid: []string{"spec.network"}},
+		{name: "IPv4 ipv6Cidr", spec: v1alpha1.WireguardSpec{Network: v1alpha1.WireguardNetwork{Ipv6Cidr: "10.0.0.0/24"}}, invalid: []string{"spec.network"}},
+		{name: "non-numeric mtu", spec: v1alpha1.WireguardSpec{Mtu: "1420 "}, invalid: []string{"spec.mtu"}},
+		{name: "key rotation", spec: v1alpha1.WireguardSpec{KeyRotation: &v1alpha1.KeyRotation{RotationPeriod: &metav1.Duration{Duration: 90 * 24 * time.Hour}, RotationGracePeriod: &metav1.Duration{Duration: 24 * time.Hour}}}},
+		{name: "grace period longer than rotation period", spec: v1alpha1.WireguardSpec{KeyRotation: &v1alpha1.KeyRotation{RotationPeriod: &metav1.Duration{Duration: time.Hour}, RotationGracePeriod: &metav1.Duration{Duration: 24 * time.Hour}}}, invalid: []string{"spec.keyRotation.rotationGracePeriod"}},
+		{name: "negative rotation period", spec: v1alpha1.WireguardSpec{KeyRotation: &v1alpha1.KeyRotation{RotationPeriod: &metav1.Duration{Duration: -time.Hour}}}, invalid: []string{"spec.keyRotation.rotationPeriod"}},
+		{name: "unchanged invalid mtu", spec: v1alpha1.WireguardSpec{Mtu: "auto", Dns: "1.1.1.1"}, old: &v1alpha1.WireguardSpec{Mtu: "auto"}},
+		{name: "changed invalid cidr", spec: v1alpha1.WireguardSpec{Network: v1alpha1.WireguardNetwork{Cidr: "10.0.0.0/33"}}, old: &v1alpha1.WireguardSpec{}, invalid: []string{"spec.network"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var old *v1alpha1.Wireguard
+			if test.old != nil {
+				old = &v1alpha1.Wireguard{Spec: *test.old}
+			}
+
+			errs := validateWireguard(&v1alpha1.Wireguard{Spec: test.spec}, old)
+			if fields := invalidFields(errs); !reflect.DeepEqual(fields, test.invalid) {
+				t.Errorf("got invalid fields %v, want %v", fields, test.invalid)
+			}
+		})
+	}
+}
+
+func TestWarnWireguard(t *testing.T) {
+	metric := v1alpha1.WireguardPodSpec{Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}}}
+
+	tests := []struct {
+		name string
+		spec v1alpha1.WireguardSpec
+		old  *v1alpha1.WireguardSpec
+		want int
+	}{
+		{name: "no metric"},
+		{name: "metric", spec: v1alpha1.WireguardSpec{Metric: metric}, want: 1},
+		{name: "unchanged metric", spec: v1alpha1.WireguardSpec{Metric: metric, Mtu: "1380"}, old: &v1alpha1.WireguardSpec{Metric: metric}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var old *v1alpha1.Wireguard
+			if test.old != nil {
+				old = &v1alpha1.Wireguard{Spec: *test.old}
+			}
+
+			if warnings := warnWireguard(&v1alpha1.Wireguard{Spec: test.spec}, old); len(warnings) != test.want {
+				t.Errorf("got warnings %v, want %d", warnings, test.want)
+			}
+		})
+	}
+}
+
+func TestValidatePeer(t *testing.T) {
+	wireguard := &v1alpha1.Wireguard{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpn"},
+		Spec: v1alpha1.WireguardSpec{
+			Network: v1alpha1.WireguardNetwork{Cidr: "10.8.0.0/24", Ipv6Cidr: "fd00::/64"},
+		},
+	}
+
+	existing := v1alpha1.WireguardPeer{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing"},
+		Spec:       v1alpha1.WireguardPeerSpec{WireguardRef: "vpn", PublicKey: otherTestPublicKey, Address: "10.8.0.2", Ipv6Address: "fd00::2"},
+	}
+
+	otherWireguardPeer := v1alpha1.WireguardPeer{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Spec:       v1alpha1.WireguardPeerSpec{WireguardRef: "other-vpn", PublicKey: testPublicKey, Address: "10.8.0.3"},
+	}
+
+	tests := []struct {
+		name string
+		spec v1alpha1.WireguardPeerSpec
+		old  *v1alpha1.WireguardPeerSpec
+		// the Wireguard of the peer does not exist
+		missingWireguard bool
+		invalid          []string
+	}{
+		{name: "generated keys and addresses", spec: v1alpha1.WireguardPeerSpec{}},
+		{name: "valid peer", spec: v1alpha1.WireguardPeerSpec{PublicKey: testPublicKey, Address: "10.8.0.3", Ipv6Address: "fd00::3", Mtu: "1280", AllowedIPs: "0.0.0.0/0, ::/0, 192.168.1.1"}},
+		{name: "invalid public key", spec: v1alpha1.WireguardPeerSpec{PublicKey: "key"}, invalid: []string{"spec.publicKey"}},
+		{name: "duplicate public key", spec: v1alpha1.WireguardPeerSpec{PublicKey: otherTestPublicKey}, invalid: []string{"spec.publicKey"}},
+		{name: "public key of a peer of another Wireguard", spec: v1alpha1.WireguardPeerSpec{PublicKey: testPublicKey}},
+		{name: "key rotation", spec: v1alpha1.WireguardPeerSpec{KeyRotationPeriod: &metav1.Duration{Duration: time.Hour}}},
+		{name: "zero key rotation period", spec: v1alpha1.WireguardPeerSpec{KeyRotationPeriod: &metav1.Duration{}}, invalid: []string{"spec.keyRotationPeriod"}},
+		{name: "negative validity", spec: v1alpha1.WireguardPeerSpec{ValidFor: &metav1.Duration{Duration: -time.Hour}}, invalid: []string{"spec.validFor"}},
+		{
+			name: "access schedule",
+			spec: v1alpha1.WireguardPeerSpec{AccessSchedule: &v1alpha1.AccessSchedule{TimeZone: "Europe/Berlin", Windows: []v1alpha1.AccessWindow{{Start: "0 9 * * 1-5", Duration: metav1.Duration{Duration: 8 * time.Hour}}}}},
+		},
+		{
+			name:    "invalid access schedule",
+			spec:    v1alpha1.WireguardPeerSpec{AccessSchedule: &v1alpha1.AccessSchedule{TimeZone: "Mars/Olympus", Windows: []v1alpha1.AccessWindow{{Start: "at nine"}}}},
+			invalid: []string{"spec.accessSchedule.timeZone", "spec.accessSchedule.windows[0].start", "spec.accessSchedule.windows[0].duration"},
+		},
+		{name: "duplicate addresses", spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.2", Ipv6Address: "fd00::2"}, invalid: []string{"spec.address", "spec.ipv6Address"}},
+		{name: "address outside of network", spec: v1alpha1.WireguardPeerSpec{Address: "10.9.0.2"}, invalid: []string{"spec.address"}},
+		{name: "gateway address", spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.1"}, invalid: []string{"spec.address"}},
+		{name: "broadcast address", spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.255"}, invalid: []string{"spec.address"}},
+		{name: "IPv6 address as address", spec: v1alpha1.WireguardPeerSpec{Address: "fd00::3"}, invalid: []string{"spec.address"}},
+		{name: "address of a missing Wireguard", spec: v1alpha1.WireguardPeerSpec{Address: "10.9.0.2"}, missingWireguard: true},
+		{name: "unchanged duplicate address", spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.2", Mtu: "1420"}, old: &v1alpha1.WireguardPeerSpec{Address: "10.8.0.2"}},
+		{name: "non-numeric mtu", spec: v1alpha1.WireguardPeerSpec{Mtu: "auto"}, invalid: []string{"spec.mtu"}},
+		{
+			name: "unchanged invalid fields",
+			spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.4", Mtu: "auto", AllowedIPs: "internet", RoutedSubnets: []string{"192.168.2.1"}, EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{{To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.0/33"}}}},
+			old:  &v1alpha1.WireguardPeerSpec{Mtu: "auto", AllowedIPs: "internet", RoutedSubnets: []string{"192.168.2.1"}, EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{{To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.0/33"}}}},
+		},
+		{name: "changed invalid mtu", spec: v1alpha1.WireguardPeerSpec{Mtu: "auto"}, old: &v1alpha1.WireguardPeerSpec{Mtu: "1420 "}, invalid: []string{"spec.mtu"}},
+		{
+			name: "hostname destinations",
+			spec: v1alpha1.WireguardPeerSpec{EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{{To: v1alpha1.EgressNetworkPolicyTo{Ip: "example.com", Ips: []string{"kubernetes.default.svc"}}}}},
+		},
+		{
+			name:    "malformed IPv4 destination",
+			spec:    v1alpha1.WireguardPeerSpec{EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{{To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.300", Ips: []string{"Not a hostname"}}}}},
+			invalid: []string{"spec.egressNetworkPolicies[0].to.ip", "spec.egressNetworkPolicies[0].to.ips[0]"},
+		},
+		{
+			name: "unknown actions",
+			spec: v1alpha1.WireguardPeerSpec{
+				EgressNetworkPolicies:  v1alpha1.EgressNetworkPolicies{{Action: "Drop"}, {Action: v1alpha1.EgressNetworkPolicyActionAccept}},
+				IngressNetworkPolicies: v1alpha1.IngressNetworkPolicies{{Action: "LOG"}, {Action: "REJECT"}},
+			},
+			invalid: []string{"spec.egressNetworkPolicies[0].action", "spec.ingressNetworkPolicies[0].action"},
+		},
+		{name: "invalid allowed IPs", spec: v1alpha1.WireguardPeerSpec{AllowedIPs: "0.0.0.0/0, internet"}, invalid: []string{"spec.allowedIPs"}},
+		{name: "invalid routed subnet", spec: v1alpha1.WireguardPeerSpec{RoutedSubnets: []string{"192.168.1.0/24", "192.168.2.1"}}, invalid: []string{"spec.routedSubnets[1]"}},
+		{
+			name: "invalid network policies",
+			spec: v1alpha1.WireguardPeerSpec{
+				EgressNetworkPolicies: v1alpha1.EgressNetworkPolicies{
+					{To: v1alpha1.EgressNetworkPolicyTo{Ip: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}},
+					{To: v1alpha1.EgressNetworkPolicyTo{Ips: []string{"10.0.0.0/33"}, Except: []string{"10.1.0.1"}, Port: 100, EndPort: 10}},
+				},
+				IngressNetworkPolicies: v1alpha1.IngressNetworkPolicies{
+					{From: v1alpha1.IngressNetworkPolicyFrom{Ip: "cluster"}},
+				},
+			},
+			invalid: []string{
+				"spec.egressNetworkPolicies[1].to.ips[0]",
+				"spec.egressNetworkPolicies[1].to.except[0]",
+				"spec.egressNetworkPolicies[1].to.endPort",
+				"spec.ingressNetworkPolicies[0].from.ip",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer := &v1alpha1.WireguardPeer{ObjectMeta: metav1.ObjectMeta{Name: "peer"}, Spec: test.spec}
+			peer.Spec.WireguardRef = "vpn"
+
+			var old *v1alpha1.WireguardPeer
+			if test.old != nil {
+				old = &v1alpha1.WireguardPeer{ObjectMeta: peer.ObjectMeta, Spec: *test.old}
+				old.Spec.WireguardRef = "vpn"
+			}
+
+			instance := wireguard
+			if test.missingWireguard {
+				instance = nil
+			}
+
+			errs := validatePeer(peer, old, instance, []v1alpha1.WireguardPeer{existing, otherWireguardPeer, *peer})
+			if fields := invalidFields(errs); !reflect.DeepEqual(fields, test.invalid) {
+				t.Errorf("got invalid fields %v, want %v", fields, test.invalid)
+			}
+		})
+	}
+}
+
+// invalidFields returns the paths of the fields of errs.
+func invalidFields(errs field.ErrorList) []string {
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+
+	return fields
+}
+
+func TestDefaultWireguard(t *testing.T) {
+	kubeDns := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.10"},
+	}
+
+	tests := []struct {
+		name    string
+		spec    v1alpha1.WireguardSpec
+		objects []client.Object
+		want    v1alpha1.WireguardSpec
+	}{
+		{
+			name:    "cluster dns",
+			objects: []client.Object{kubeDns},
+			want:    v1alpha1.WireguardSpec{Mtu: "1420", Dns: "10.96.0.10", DnsSearchDomains: []string{"vpn.svc.cluster.local"}, ServiceType: corev1.ServiceTypeLoadBalancer, ListenPort: 51820},
+		},
+		{
+			name: "missing cluster dns",
+			want: v1alpha1.WireguardSpec{Mtu: "1420", Dns: "1.1.1.1", ServiceType: corev1.ServiceTypeLoadBalancer, ListenPort: 51820},
+		},
+		{
+			name:    "explicit values",
+			spec:    v1alpha1.WireguardSpec{Mtu: "1380", Dns: "9.9.9.9", ServiceType: corev1.ServiceTypeNodePort, ListenPort: 443},
+			objects: []client.Object{kubeDns},
+			want:    v1alpha1.WireguardSpec{Mtu: "1380", Dns: "9.9.9.9", ServiceType: corev1.ServiceTypeNodePort, ListenPort: 443},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defaulter := &WireguardDefaulter{Client: fake.NewClientBuilder().WithObjects(test.objects...).Build()}
+			wireguard := &v1alpha1.Wireguard{ObjectMeta: metav1.ObjectMeta{Name: "vpn", Namespace: "vpn"}, Spec: test.spec}
+
+			if err := defaulter.Default(context.TODO(), wireguard); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(wireguard.Spec, test.want) {
+				t.Errorf("got %+v, want %+v", wireguard.Spec, test.want)
+			}
+		})
+	}
+}
+
+func TestDefaultPeer(t *testing.T) {
+	wireguard := &v1alpha1.Wireguard{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpn", Namespace: "vpn"},
+		Spec: v1alpha1.WireguardSpec{
+			Network: v1alpha1.WireguardNetwork{Cidr: "10.8.0.0/29", Ipv6Cidr: "fd00::/64"},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		spec             v1alpha1.WireguardPeerSpec
+		missingWireguard bool
+		want             v1alpha1.WireguardPeerSpec
+	}{
+		{
+			// addresses are allocated by the reconciler only
+			name: "addresses left to the reconciler",
+			want: v1alpha1.WireguardPeerSpec{WireguardRef: "vpn", AllowedIPs: "0.0.0.0/0, ::/0"},
+		},
+		{
+			name: "explicit values",
+			spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.6", Ipv6Address: "fd00::6", AllowedIPs: "10.0.0.0/8"},
+			want: v1alpha1.WireguardPeerSpec{WireguardRef: "vpn", Address: "10.8.0.6", Ipv6Address: "fd00::6", AllowedIPs: "10.0.0.0/8"},
+		},
+		{
+			name:             "missing wireguard",
+			missingWireguard: true,
+			want:             v1alpha1.WireguardPeerSpec{WireguardRef: "vpn", AllowedIPs: "0.0.0.0/0"},
+		},
+		{
+			name: "expiry policy of expiring peers",
+			spec: v1alpha1.WireguardPeerSpec{Address: "10.8.0.6", Ipv6Address: "fd00::6", ValidFor: &metav1.Duration{Duration: time.Hour}},
+			want: v1alpha1.WireguardPeerSpec{WireguardRef: "vpn", Address: "10.8.0.6", Ipv6Address: "fd00::6", AllowedIPs: "0.0.0.0/0, ::/0", ValidFor: &metav1.Duration{Duration: time.Hour}, ExpiryPolicy: v1alpha1.ExpiryPolicyDisable},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer := &v1alpha1.WireguardPeer{ObjectMeta: metav1.ObjectMeta{Name: "peer", Namespace: "vpn"}, Spec: test.spec}
+			peer.Spec.WireguardRef = "vpn"
+
+			instance := wireguard
+			if test.missingWireguard {
+				instance = nil
+			}
+
+			if err := defaultPeer(peer, instance); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(peer.Spec, test.want) {
+				t.Errorf("got %+v, want %+v", peer.Spec, test.want)
+			}
+		})
+	}
+}
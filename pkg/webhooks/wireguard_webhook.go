@@ -0,0 +1,169 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/mutate-vpn-wireguard-operator-io-v1alpha1-wireguard,mutating=true,failurePolicy=fail,sideEffects=None,groups=vpn.wireguard-operator.io,resources=wireguards,verbs=create;update,versions=v1alpha1,name=mwireguard.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-vpn-wireguard-operator-io-v1alpha1-wireguard,mutating=false,failurePolicy=fail,sideEffects=None,groups=vpn.wireguard-operator.io,resources=wireguards,verbs=create;update,versions=v1alpha1,name=vwireguard.kb.io,admissionReviewVersions=v1
+
+// WireguardDefaulter sets the service type, listen port, MTU and DNS server of Wireguard instances to the values the
+// reconciler would otherwise use implicitly.
+type WireguardDefaulter struct {
+	Client client.Reader
+}
+
+// WireguardValidator rejects Wireguard instances with an invalid network, key rotation or MTU.
+type WireguardValidator struct{}
+
+// SetupWireguardWebhookWithManager registers the defaulting and validating webhooks of Wireguard instances.
+func SetupWireguardWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.Wireguard{}).
+		WithDefaulter(&WireguardDefaulter{Client: mgr.GetClient()}).
+		WithValidator(&WireguardValidator{}).
+		Complete()
+}
+
+// Default sets the defaults of a Wireguard instance.
+func (d *WireguardDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	wireguard, ok := obj.(*v1alpha1.Wireguard)
+	if !ok {
+		return fmt.Errorf("expected a Wireguard but got a %T", obj)
+	}
+
+	if wireguard.Spec.Dns == "" {
+		kubeDnsService := &corev1.Service{}
+		err := d.Client.Get(ctx, types.NamespacedName{Name: "kube-dns", Namespace: "kube-system"}, kubeDnsService)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		if err == nil && kubeDnsService.Spec.ClusterIP != "" {
+			wireguard.Spec.Dns = kubeDnsService.Spec.ClusterIP
+			if wireguard.Spec.DnsSearchDomains == nil {
+				wireguard.Spec.DnsSearchDomains = []string{fmt.Sprintf("%s.svc.cluster.local", wireguard.Namespace)}
+			}
+		}
+	}
+
+	defaultWireguard(wireguard)
+
+	return nil
+}
+
+// defaultWireguard sets the defaults of a Wireguard instance that do not depend on the cluster.
+func defaultWireguard(wireguard *v1alpha1.Wireguard) {
+	if wireguard.Spec.ServiceType == "" {
+		wireguard.Spec.ServiceType = corev1.ServiceTypeLoadBalancer
+	}
+
+	if wireguard.Spec.ListenPort == 0 {
+		wireguard.Spec.ListenPort = agent.DefaultListenPort
+	}
+
+	if wireguard.Spec.Mtu == "" {
+		wireguard.Spec.Mtu = strconv.Itoa(agent.DefaultMtu)
+	}
+
+	if wireguard.Spec.Dns == "" {
+		wireguard.Spec.Dns = agent.FallbackDns
+	}
+}
+
+// ValidateCreate validates a new Wireguard instance.
+func (v *WireguardValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(obj, nil)
+}
+
+// ValidateUpdate validates an updated Wireguard instance.
+func (v *WireguardValidator) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	old, ok := oldObj.(*v1alpha1.Wireguard)
+	if !ok {
+		return nil, fmt.Errorf("expected a Wireguard but got a %T", oldObj)
+	}
+
+	return v.validate(newObj, old)
+}
+
+// ValidateDelete allows the deletion of Wireguard instances.
+func (v *WireguardValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *WireguardValidator) validate(obj runtime.Object, old *v1alpha1.Wireguard) (admission.Warnings, error) {
+	wireguard, ok := obj.(*v1alpha1.Wireguard)
+	if !ok {
+		return nil, fmt.Errorf("expected a Wireguard but got a %T", obj)
+	}
+
+	warnings := warnWireguard(wireguard, old)
+
+	errs := validateWireguard(wireguard, old)
+	if len(errs) == 0 {
+		return warnings, nil
+	}
+
+	return warnings, apierrors.NewInvalid(v1alpha1.GroupVersion.WithKind("Wireguard").GroupKind(), wireguard.Name, errs)
+}
+
+// warnWireguard warns about deprecated fields that are set by the create or update of the Wireguard instance.
+func warnWireguard(wireguard *v1alpha1.Wireguard, old *v1alpha1.Wireguard) admission.Warnings {
+	var warnings admission.Warnings
+	if !equality.Semantic.DeepEqual(wireguard.Spec.Metric, v1alpha1.WireguardPodSpec{}) && (old == nil || !equality.Semantic.DeepEqual(wireguard.Spec.Metric, old.Spec.Metric)) {
+		warnings = append(warnings, "spec.metric is deprecated and ignored, the metrics are served by the agent whose resources are set through spec.agent")
+	}
+
+	return warnings
+}
+
+// validateWireguard validates the tunnel networks, the key rotation and the MTU of the Wireguard instance. Fields are
+// only checked when they are new or changed, so that instances accepted before the webhook existed can still be
+// updated.
+func validateWireguard(wireguard *v1alpha1.Wireguard, old *v1alpha1.Wireguard) field.ErrorList {
+	spec := field.NewPath("spec")
+
+	var oldSpec v1alpha1.WireguardSpec
+	if old != nil {
+		oldSpec = old.Spec
+	}
+
+	modified := func(value interface{}, oldValue interface{}) bool {
+		return old == nil || !equality.Semantic.DeepEqual(value, oldValue)
+	}
+
+	var errs field.ErrorList
+	if modified(wireguard.Spec.Network, oldSpec.Network) {
+		if _, _, err := agent.GetNetwork(*wireguard); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("network"), wireguard.Spec.Network, err.Error()))
+		}
+
+		if _, _, err := agent.GetIpv6Network(*wireguard); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("network"), wireguard.Spec.Network, err.Error()))
+		}
+	}
+
+	if rotation := wireguard.Spec.KeyRotation; rotation != nil && modified(rotation, oldSpec.KeyRotation) {
+		errs = append(errs, validateRotation(spec.Child("keyRotation"), rotation.RotationPeriod, rotation.RotationGracePeriod)...)
+	}
+
+	if modified(wireguard.Spec.Mtu, oldSpec.Mtu) {
+		errs = append(errs, validateMtu(spec.Child("mtu"), wireguard.Spec.Mtu)...)
+	}
+
+	return errs
+}
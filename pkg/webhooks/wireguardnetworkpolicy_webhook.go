@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/validate-vpn-wireguard-operator-io-v1alpha1-wireguardnetworkpolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=vpn.wireguard-operator.io,resources=wireguardnetworkpolicies,verbs=create;update,versions=v1alpha1,name=vwireguardnetworkpolicy.kb.io,admissionReviewVersions=v1
+
+// WireguardNetworkPolicyValidator rejects network policies with an invalid peer selector, addresses or ports.
+type WireguardNetworkPolicyValidator struct{}
+
+// SetupWireguardNetworkPolicyWebhookWithManager registers the validating webhook of Wireguard network policies.
+func SetupWireguardNetworkPolicyWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.WireguardNetworkPolicy{}).
+		WithValidator(&WireguardNetworkPolicyValidator{}).
+		Complete()
+}
+
+// ValidateCreate validates a new network policy.
+func (v *WireguardNetworkPolicyValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+// ValidateUpdate validates an updated network policy. Updates leaving the spec unchanged are allowed, so that policies
+// accepted before the webhook existed can still be updated.
+func (v *WireguardNetworkPolicyValidator) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	old, ok := oldObj.(*v1alpha1.WireguardNetworkPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardNetworkPolicy but got a %T", oldObj)
+	}
+
+	if policy, ok := newObj.(*v1alpha1.WireguardNetworkPolicy); ok && equality.Semantic.DeepEqual(policy.Spec, old.Spec) {
+		return nil, nil
+	}
+
+	return nil, v.validate(newObj)
+}
+
+// ValidateDelete allows the deletion of network policies.
+func (v *WireguardNetworkPolicyValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *WireguardNetworkPolicyValidator) validate(obj runtime.Object) error {
+	policy, ok := obj.(*v1alpha1.WireguardNetworkPolicy)
+	if !ok {
+		return fmt.Errorf("expected a WireguardNetworkPolicy but got a %T", obj)
+	}
+
+	spec := field.NewPath("spec")
+
+	var errs field.ErrorList
+	if _, err := metav1.LabelSelectorAsSelector(&policy.Spec.PeerSelector); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("peerSelector"), policy.Spec.PeerSelector, err.Error()))
+	}
+	errs = append(errs, validateEgressNetworkPolicies(spec.Child("egress"), policy.Spec.Egress)...)
+	errs = append(errs, validateIngressNetworkPolicies(spec.Child("ingress"), policy.Spec.Ingress)...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(v1alpha1.GroupVersion.WithKind("WireguardNetworkPolicy").GroupKind(), policy.Name, errs)
+}
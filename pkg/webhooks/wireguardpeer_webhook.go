@@ -0,0 +1,263 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/jodevsa/wireguard-operator/pkg/agent"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/mutate-vpn-wireguard-operator-io-v1alpha1-wireguardpeer,mutating=true,failurePolicy=fail,sideEffects=None,groups=vpn.wireguard-operator.io,resources=wireguardpeers,verbs=create;update,versions=v1alpha1,name=mwireguardpeer.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-vpn-wireguard-operator-io-v1alpha1-wireguardpeer,mutating=false,failurePolicy=fail,sideEffects=None,groups=vpn.wireguard-operator.io,resources=wireguardpeers,verbs=create;update,versions=v1alpha1,name=vwireguardpeer.kb.io,admissionReviewVersions=v1
+
+// WireguardPeerDefaulter sets the AllowedIPs of peers to the routes the reconciler would otherwise use implicitly.
+// IPv6 routes are only added when the Wireguard instance of the peer exists and is dual-stack. Addresses are not
+// allocated here: the defaulting webhook can't reserve an address until the peer is stored, so concurrent requests
+// would race each other and the reconciler for the same address. The reconciler allocates the addresses of all peers.
+type WireguardPeerDefaulter struct {
+	Client client.Reader
+}
+
+// WireguardPeerValidator rejects peers with invalid keys, addresses, MTU or network policies, and peers whose public
+// key or addresses are already used by another peer of the same Wireguard instance.
+type WireguardPeerValidator struct {
+	Client client.Reader
+}
+
+// SetupWireguardPeerWebhookWithManager registers the defaulting and validating webhooks of Wireguard peers.
+func SetupWireguardPeerWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.WireguardPeer{}).
+		WithDefaulter(&WireguardPeerDefaulter{Client: mgr.GetAPIReader()}).
+		WithValidator(&WireguardPeerValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// Default sets the defaults of a peer.
+func (d *WireguardPeerDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	peer, ok := obj.(*v1alpha1.WireguardPeer)
+	if !ok {
+		return fmt.Errorf("expected a WireguardPeer but got a %T", obj)
+	}
+
+	wireguard := &v1alpha1.Wireguard{}
+	err := d.Client.Get(ctx, types.NamespacedName{Name: peer.Spec.WireguardRef, Namespace: peer.Namespace}, wireguard)
+	if apierrors.IsNotFound(err) {
+		return defaultPeer(peer, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	return defaultPeer(peer, wireguard)
+}
+
+// defaultPeer sets the AllowedIPs and the expiry policy of the peer. wireguard is nil if it does not exist yet.
+func defaultPeer(peer *v1alpha1.WireguardPeer, wireguard *v1alpha1.Wireguard) error {
+	if peer.Spec.ExpiryPolicy == "" && (peer.Spec.ExpiresAt != nil || peer.Spec.ValidFor != nil) {
+		peer.Spec.ExpiryPolicy = v1alpha1.ExpiryPolicyDisable
+	}
+
+	var ipv6Network *net.IPNet
+	if wireguard != nil {
+		var err error
+		if ipv6Network, _, err = agent.GetIpv6Network(*wireguard); err != nil {
+			return err
+		}
+	}
+
+	if peer.Spec.AllowedIPs == "" {
+		peer.Spec.AllowedIPs = "0.0.0.0/0"
+		if ipv6Network != nil {
+			peer.Spec.AllowedIPs = peer.Spec.AllowedIPs + ", ::/0"
+		}
+	}
+
+	return nil
+}
+
+// ValidateCreate validates a new peer.
+func (v *WireguardPeerValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(ctx, obj, nil)
+}
+
+// ValidateUpdate validates an updated peer.
+func (v *WireguardPeerValidator) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	old, ok := oldObj.(*v1alpha1.WireguardPeer)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardPeer but got a %T", oldObj)
+	}
+
+	return nil, v.validate(ctx, newObj, old)
+}
+
+// ValidateDelete allows the deletion of peers.
+func (v *WireguardPeerValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *WireguardPeerValidator) validate(ctx context.Context, obj runtime.Object, old *v1alpha1.WireguardPeer) error {
+	peer, ok := obj.(*v1alpha1.WireguardPeer)
+	if !ok {
+		return fmt.Errorf("expected a WireguardPeer but got a %T", obj)
+	}
+
+	// peers may be created before their Wireguard instance, their addresses are then checked once they change
+	var wireguard *v1alpha1.Wireguard
+	instance := &v1alpha1.Wireguard{}
+	err := v.Client.Get(ctx, types.NamespacedName{Name: peer.Spec.WireguardRef, Namespace: peer.Namespace}, instance)
+	if err == nil {
+		wireguard = instance
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	peers := &v1alpha1.WireguardPeerList{}
+	if err := v.Client.List(ctx, peers, client.InNamespace(peer.Namespace)); err != nil {
+		return err
+	}
+
+	errs := validatePeer(peer, old, wireguard, peers.Items)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(v1alpha1.GroupVersion.WithKind("WireguardPeer").GroupKind(), peer.Name, errs)
+}
+
+// validatePeer validates the spec of the peer. Fields are only checked when they are new or changed, so that peers
+// accepted before the webhook existed can still be updated, e.g. by the reconciler.
+func validatePeer(peer *v1alpha1.WireguardPeer, old *v1alpha1.WireguardPeer, wireguard *v1alpha1.Wireguard, peers []v1alpha1.WireguardPeer) field.ErrorList {
+	spec := field.NewPath("spec")
+
+	var oldSpec v1alpha1.WireguardPeerSpec
+	if old != nil {
+		oldSpec = old.Spec
+	}
+
+	modified := func(value interface{}, oldValue interface{}) bool {
+		return old == nil || !equality.Semantic.DeepEqual(value, oldValue)
+	}
+
+	var errs field.ErrorList
+	if modified(peer.Spec.Mtu, oldSpec.Mtu) {
+		errs = append(errs, validateMtu(spec.Child("mtu"), peer.Spec.Mtu)...)
+	}
+	if modified(peer.Spec.AllowedIPs, oldSpec.AllowedIPs) {
+		errs = append(errs, validateAllowedIPs(spec.Child("allowedIPs"), peer.Spec.AllowedIPs)...)
+	}
+	if modified(peer.Spec.RoutedSubnets, oldSpec.RoutedSubnets) {
+		for i, subnet := range peer.Spec.RoutedSubnets {
+			errs = append(errs, validateCidr(spec.Child("routedSubnets").Index(i), subnet)...)
+		}
+	}
+	if modified(peer.Spec.EgressNetworkPolicies, oldSpec.EgressNetworkPolicies) {
+		errs = append(errs, validateEgressNetworkPolicies(spec.Child("egressNetworkPolicies"), peer.Spec.EgressNetworkPolicies)...)
+	}
+	if modified(peer.Spec.IngressNetworkPolicies, oldSpec.IngressNetworkPolicies) {
+		errs = append(errs, validateIngressNetworkPolicies(spec.Child("ingressNetworkPolicies"), peer.Spec.IngressNetworkPolicies)...)
+	}
+	if period := peer.Spec.KeyRotationPeriod; period != nil && period.Duration <= 0 && modified(period, oldSpec.KeyRotationPeriod) {
+		errs = append(errs, field.Invalid(spec.Child("keyRotationPeriod"), period.Duration.String(), "must be positive"))
+	}
+	if validFor := peer.Spec.ValidFor; validFor != nil && validFor.Duration <= 0 && modified(validFor, oldSpec.ValidFor) {
+		errs = append(errs, field.Invalid(spec.Child("validFor"), validFor.Duration.String(), "must be positive"))
+	}
+	if modified(peer.Spec.AccessSchedule, oldSpec.AccessSchedule) {
+		errs = append(errs, validateAccessSchedule(spec.Child("accessSchedule"), peer.Spec.AccessSchedule)...)
+	}
+
+	changed := func(value string, oldValue string) bool {
+		return value != "" && (old == nil || value != oldValue || peer.Spec.WireguardRef != oldSpec.WireguardRef)
+	}
+	publicKeyChanged := changed(peer.Spec.PublicKey, oldSpec.PublicKey)
+	addressChanged := changed(peer.Spec.Address, oldSpec.Address)
+	ipv6AddressChanged := changed(peer.Spec.Ipv6Address, oldSpec.Ipv6Address)
+
+	if publicKeyChanged {
+		errs = append(errs, validateKey(spec.Child("publicKey"), peer.Spec.PublicKey)...)
+	}
+
+	if addressChanged {
+		errs = append(errs, validatePeerAddress(spec.Child("address"), peer.Spec.Address, wireguard, false)...)
+	}
+
+	if ipv6AddressChanged {
+		errs = append(errs, validatePeerAddress(spec.Child("ipv6Address"), peer.Spec.Ipv6Address, wireguard, true)...)
+	}
+
+	for _, other := range peers {
+		if other.Name == peer.Name || other.Spec.WireguardRef != peer.Spec.WireguardRef {
+			continue
+		}
+
+		if publicKeyChanged && other.Spec.PublicKey == peer.Spec.PublicKey {
+			errs = append(errs, field.Duplicate(spec.Child("publicKey"), peer.Spec.PublicKey))
+		}
+
+		if addressChanged && other.Spec.Address == peer.Spec.Address {
+			errs = append(errs, field.Duplicate(spec.Child("address"), peer.Spec.Address))
+		}
+
+		if ipv6AddressChanged && other.Spec.Ipv6Address == peer.Spec.Ipv6Address {
+			errs = append(errs, field.Duplicate(spec.Child("ipv6Address"), peer.Spec.Ipv6Address))
+		}
+	}
+
+	return errs
+}
+
+// validatePeerAddress validates that address is an IPv4 or IPv6 address inside the matching network of wireguard
+// that is not reserved for the network, the gateway or broadcasts. Only the format is checked if wireguard is nil.
+func validatePeerAddress(path *field.Path, address string, wireguard *v1alpha1.Wireguard, ipv6 bool) field.ErrorList {
+	ip := net.ParseIP(address)
+	if ip == nil || (ip.To4() == nil) != ipv6 {
+		family := "IPv4"
+		if ipv6 {
+			family = "IPv6"
+		}
+		return field.ErrorList{field.Invalid(path, address, "must be an "+family+" address")}
+	}
+
+	if wireguard == nil {
+		return nil
+	}
+
+	network, gateway, err := agent.GetNetwork(*wireguard)
+	if ipv6 {
+		network, gateway, err = agent.GetIpv6Network(*wireguard)
+	}
+
+	if err != nil || network == nil {
+		// invalid networks are rejected by the webhook of the Wireguard instance, and IPv6 addresses are ignored by
+		// instances that are not dual-stack
+		return nil
+	}
+
+	if !network.Contains(ip) {
+		return field.ErrorList{field.Invalid(path, address, fmt.Sprintf("must be part of the network %s of Wireguard %s", network, wireguard.Name))}
+	}
+
+	reserved := []net.IP{network.IP, gateway}
+	if !ipv6 {
+		reserved = append(reserved, agent.GetBroadcastAddress(network))
+	}
+
+	for _, reservedIp := range reserved {
+		if ip.Equal(reservedIp) {
+			return field.ErrorList{field.Invalid(path, address, "is reserved by Wireguard "+wireguard.Name)}
+		}
+	}
+
+	return nil
+}
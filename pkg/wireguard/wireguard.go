@@ -5,6 +5,7 @@ import (
 	"net"
 	"os/exec"
 	"syscall"
+	"time"
 
 	"github.com/go-logr/logr"
 
@@ -15,65 +16,238 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-const MTU = 1420
+const MTU = agent.DefaultMtu
 
-func syncRoute(_ agent.State, iface string) error {
-	link, err := netlink.LinkByName(iface)
+type tunnelNetwork struct {
+	family  int
+	network *net.IPNet
+	gateway net.IP
+}
+
+// getTunnelNetworks returns the IPv4 tunnel network and, for dual-stack instances, the IPv6 one.
+func getTunnelNetworks(state agent.State) ([]tunnelNetwork, error) {
+	network, gateway, err := agent.GetNetwork(state.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := []tunnelNetwork{{family: syscall.AF_INET, network: network, gateway: gateway}}
+
+	ipv6Network, ipv6Gateway, err := agent.GetIpv6Network(state.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	if ipv6Network != nil {
+		networks = append(networks, tunnelNetwork{family: syscall.AF_INET6, network: ipv6Network, gateway: ipv6Gateway})
+	}
+
+	return networks, nil
+}
+
+func syncRoute(state agent.State, iface string) error {
+	networks, err := getTunnelNetworks(state)
 	if err != nil {
 		return err
 	}
 
-	routes, err := netlink.RouteList(link, syscall.AF_INET)
+	link, err := netlink.LinkByName(iface)
 	if err != nil {
 		return err
 	}
 
-	for _, route := range routes {
-		if route.LinkIndex == link.Attrs().Index {
-			return nil
+	for _, tunnel := range networks {
+		routes, err := netlink.RouteList(link, tunnel.family)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, route := range routes {
+			if route.LinkIndex == link.Attrs().Index && route.Dst != nil && route.Dst.String() == tunnel.network.String() {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		route := netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       tunnel.network,
+		}
+
+		// IPv6 routes cannot use an address of the link itself as gateway
+		if tunnel.family == syscall.AF_INET {
+			route.Gw = tunnel.gateway
+		}
+
+		err = netlink.RouteAdd(&route)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncRoutedSubnets(state, link, networks)
+}
+
+// syncRoutedSubnets routes the subnets of site-to-site peers through the link and removes the routes of subnets that
+// are no longer routed through any peer.
+func syncRoutedSubnets(state agent.State, link netlink.Link, networks []tunnelNetwork) error {
+	desired := map[string]*net.IPNet{}
+	for _, peer := range state.Peers {
+		if peer.Spec.Disabled {
+			continue
+		}
+		for _, subnet := range agent.GetRoutedSubnets(peer) {
+			desired[subnet.String()] = subnet
 		}
 	}
-	route := netlink.Route{
-		LinkIndex: link.Attrs().Index,
-		Dst:       &getIP("10.8.0.0/24")[0],
-		Gw:        net.ParseIP("10.8.0.1"),
+
+	for _, tunnel := range networks {
+		delete(desired, tunnel.network.String())
 	}
 
-	err = netlink.RouteAdd(&route)
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return err
 	}
 
+	for _, route := range routes {
+		if route.Dst == nil || route.Protocol != syscall.RTPROT_BOOT {
+			continue
+		}
+
+		if _, ok := desired[route.Dst.String()]; ok {
+			delete(desired, route.Dst.String())
+			continue
+		}
+
+		isTunnelNetwork := false
+		for _, tunnel := range networks {
+			if route.Dst.String() == tunnel.network.String() {
+				isTunnelNetwork = true
+				break
+			}
+		}
+
+		if isTunnelNetwork {
+			continue
+		}
+
+		route := route
+		err = netlink.RouteDel(&route)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, subnet := range desired {
+		err = netlink.RouteAdd(&netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       subnet,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func syncAddress(_ agent.State, iface string) error {
+func syncAddress(state agent.State, iface string) error {
+	networks, err := getTunnelNetworks(state)
+	if err != nil {
+		return err
+	}
+
 	link, err := netlink.LinkByName(iface)
 	if err != nil {
 		return err
 	}
 
-	addresses, err := netlink.AddrList(link, syscall.AF_INET)
+	if err := syncGatewayAddresses(link, networks); err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+	return nil
+}
+
+// syncGatewayAddresses assigns the gateways of the tunnel networks to the link, and removes the addresses of the
+// families without tunnel network.
+func syncGatewayAddresses(link netlink.Link, networks []tunnelNetwork) error {
+	gateways := map[int]net.IP{syscall.AF_INET: nil, syscall.AF_INET6: nil}
+	for _, tunnel := range networks {
+		gateways[tunnel.family] = tunnel.gateway
+	}
+
+	for _, family := range []int{syscall.AF_INET, syscall.AF_INET6} {
+		if err := syncGatewayAddress(link, family, gateways[family]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncGatewayAddress makes the gateway the only address of the family assigned to the link, removing the gateways of
+// tunnel networks used before. A nil gateway removes all addresses of the family.
+func syncGatewayAddress(link netlink.Link, family int, gateway net.IP) error {
+	addresses, err := netlink.AddrList(link, family)
 	if err != nil {
-		return nil
+		return fmt.Errorf("netlink addr list: %w", err)
+	}
+
+	var desired *net.IPNet
+	if gateway != nil {
+		desired = hostNetwork(gateway)
+	}
+
+	stale, assigned := staleAddresses(addresses, desired)
+	for _, address := range stale {
+		address := address
+		if err := netlink.AddrDel(link, &address); err != nil {
+			return fmt.Errorf("netlink addr del: %w", err)
+		}
 	}
 
-	if len(addresses) != 0 {
+	if desired == nil || assigned {
 		return nil
 	}
 
-	if err := netlink.AddrAdd(link, &netlink.Addr{
-		IPNet: &net.IPNet{IP: net.ParseIP("10.8.0.1")},
-	}); err != nil {
+	if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: desired}); err != nil {
 		return fmt.Errorf("netlink addr add: %w", err)
 	}
 
-	if err := netlink.LinkSetUp(link); err != nil {
-		return err
-	}
 	return nil
 }
 
+// staleAddresses returns the addresses other than desired, and whether desired is among the addresses. Link-local
+// addresses the kernel assigns to IPv6 enabled links are neither stale nor desired.
+func staleAddresses(addresses []netlink.Addr, desired *net.IPNet) ([]netlink.Addr, bool) {
+	var stale []netlink.Addr
+	assigned := false
+	for _, address := range addresses {
+		if address.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if desired != nil && address.IPNet.String() == desired.String() {
+			assigned = true
+			continue
+		}
+
+		stale = append(stale, address)
+	}
+
+	return stale, assigned
+}
+
 func createLinkUsingUserspaceImpl(iface string, wgUserspaceImplementationFallback string) error {
 
 	bashCommand := fmt.Sprintf("mkdir -p /dev/net && if [ ! -c /dev/net/tun ]; then\n    mknod /dev/net/tun c 10 200\nfi && %s %s", wgUserspaceImplementationFallback, iface)
@@ -104,8 +278,13 @@ func createLinkUsingKernalModule(iface string) error {
 	return nil
 }
 
-func SyncLink(_ agent.State, iface string, wgUserspaceImplementationFallback string, wgUseUserspaceImpl bool) error {
-	_, err := netlink.LinkByName(iface)
+func SyncLink(state agent.State, iface string, wgUserspaceImplementationFallback string, wgUseUserspaceImpl bool) error {
+	networks, err := getTunnelNetworks(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = netlink.LinkByName(iface)
 	if err != nil {
 		if _, ok := err.(netlink.LinkNotFoundError); !ok {
 			return err
@@ -149,19 +328,8 @@ func SyncLink(_ agent.State, iface string, wgUserspaceImplementationFallback str
 		}
 	}
 
-	addresses, err := netlink.AddrList(link, syscall.AF_INET)
-	if err != nil {
-		return nil
-	}
-
-	if len(addresses) != 0 {
-		return nil
-	}
-
-	if err := netlink.AddrAdd(link, &netlink.Addr{
-		IPNet: &getIP("10.8.0.1/32")[0],
-	}); err != nil {
-		return fmt.Errorf("netlink addr add: %w", err)
+	if err := syncGatewayAddresses(link, networks); err != nil {
+		return err
 	}
 
 	if err := netlink.LinkSetUp(link); err != nil {
@@ -172,7 +340,7 @@ func SyncLink(_ agent.State, iface string, wgUserspaceImplementationFallback str
 
 func (wg *Wireguard) syncWireguard(state agent.State, iface string, listenPort int) error {
 	c, _ := wgctrl.New()
-	cfg, err := CreateWireguardConfiguration(state, iface, listenPort)
+	cfg, err := CreateWireguardConfiguration(wg.Logger, state, iface, listenPort)
 	if err != nil {
 		return err
 	}
@@ -211,14 +379,14 @@ func (wg *Wireguard) Sync(state agent.State) error {
 		return err
 	}
 
-	// route all traffic coming from 10.8.0.0/24 via gateway 10.8.0.1 on wg0
+	// route all traffic of the tunnel networks via wg0
 	err = syncRoute(state, wg.Iface)
 
 	if err != nil {
 		return err
 	}
 
-	// set wg0 gateway as 10.8.0.1/32
+	// set the tunnel network gateways as the wg0 addresses
 	err = syncAddress(state, wg.Iface)
 	if err != nil {
 		return err
@@ -233,13 +401,117 @@ func (wg *Wireguard) Sync(state agent.State) error {
 	return nil
 }
 
+// Stats returns the statistics of the peers of the wg device.
+func (wg *Wireguard) Stats() ([]agent.PeerStats, error) {
+	c, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	device, err := c.Device(wg.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]agent.PeerStats, 0, len(device.Peers))
+	for _, peer := range device.Peers {
+		peerStats := agent.PeerStats{
+			PublicKey:         peer.PublicKey.String(),
+			LastHandshakeTime: peer.LastHandshakeTime,
+			ReceiveBytes:      peer.ReceiveBytes,
+			TransmitBytes:     peer.TransmitBytes,
+		}
+
+		if peer.Endpoint != nil {
+			peerStats.Endpoint = peer.Endpoint.String()
+		}
+
+		stats = append(stats, peerStats)
+	}
+
+	return stats, nil
+}
+
+// hostNetwork returns a single address network (/32 or /128) for ip.
+func hostNetwork(ip net.IP) *net.IPNet {
+	bits := 8 * len(ip)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
 func getIP(ip string) []net.IPNet {
 	_, ipnet, _ := net.ParseCIDR(ip)
 
 	return []net.IPNet{*ipnet}
 }
 
-func createPeersConfiguration(state agent.State, iface string) ([]wgtypes.PeerConfig, error) {
+// getPeerAllowedIPs returns the tunnel addresses of the peer: its IPv4 address and, for dual-stack instances, its
+// IPv6 address, followed by the subnets routed through the peer.
+func getPeerAllowedIPs(state agent.State, peer v1alpha1.WireguardPeer) []net.IPNet {
+	allowedIPs := getIP(peer.Spec.Address + "/32")
+
+	if agent.IsDualStack(state.Server) && peer.Spec.Ipv6Address != "" {
+		allowedIPs = append(allowedIPs, getIP(peer.Spec.Ipv6Address+"/128")...)
+	}
+
+	for _, subnet := range agent.GetRoutedSubnets(peer) {
+		allowedIPs = append(allowedIPs, *subnet)
+	}
+
+	return allowedIPs
+}
+
+// getPeerStaticEndpoint resolves the static endpoint of the peer. It returns nil if the peer does not have one, or if
+// it does not resolve: the peer is configured without an endpoint then, so that it can still connect to the server.
+func getPeerStaticEndpoint(log logr.Logger, peer v1alpha1.WireguardPeer) *net.UDPAddr {
+	if peer.Spec.StaticEndpoint == "" {
+		return nil
+	}
+
+	endpoint, err := net.ResolveUDPAddr("udp", peer.Spec.StaticEndpoint)
+	if err != nil {
+		log.Error(err, "Skipping the static endpoint of peer, it does not resolve", "peer", peer.Name, "staticEndpoint", peer.Spec.StaticEndpoint)
+		return nil
+	}
+
+	return endpoint
+}
+
+// getPeerPresharedKey returns the preshared key of the peer, or the zero key if the peer does not use one.
+func getPeerPresharedKey(state agent.State, peer v1alpha1.WireguardPeer) (wgtypes.Key, error) {
+	presharedKey, ok := state.PresharedKeys[peer.Spec.PublicKey]
+	if !ok {
+		return wgtypes.Key{}, nil
+	}
+
+	return wgtypes.ParseKey(presharedKey)
+}
+
+// getPeerPersistentKeepalive returns the keepalive interval of the peer. Zero disables keepalive packets.
+func getPeerPersistentKeepalive(peer v1alpha1.WireguardPeer) time.Duration {
+	return time.Duration(peer.Spec.PersistentKeepalive) * time.Second
+}
+
+func equalAllowedIPs(a []net.IPNet, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	ips := make(map[string]bool)
+	for _, ip := range a {
+		ips[ip.String()] = true
+	}
+
+	for _, ip := range b {
+		if !ips[ip.String()] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func createPeersConfiguration(log logr.Logger, state agent.State, iface string) ([]wgtypes.PeerConfig, error) {
 	var peersState = make(map[string]v1alpha1.WireguardPeer)
 	for _, peer := range state.Peers {
 		peersState[peer.Spec.PublicKey] = peer
@@ -283,15 +555,31 @@ func createPeersConfiguration(state agent.State, iface string) ([]wgtypes.PeerCo
 					PublicKey:  peer.PublicKey,
 				}
 				peerConfigurationByPublicKey[p.PublicKey.String()] = p
-			} else if peer.AllowedIPs[0].IP.String() != peerState.Spec.Address {
-				// update peer
-				p := wgtypes.PeerConfig{
-					UpdateOnly:        true,
-					AllowedIPs:        getIP(peerState.Spec.Address + "/32"),
-					PublicKey:         peer.PublicKey,
-					ReplaceAllowedIPs: true,
+			} else {
+				allowedIPs := getPeerAllowedIPs(state, peerState)
+				presharedKey, err := getPeerPresharedKey(state, peerState)
+				if err != nil {
+					return []wgtypes.PeerConfig{}, err
+				}
+
+				persistentKeepalive := getPeerPersistentKeepalive(peerState)
+				endpoint := getPeerStaticEndpoint(log, peerState)
+
+				endpointChanged := endpoint != nil && (peer.Endpoint == nil || peer.Endpoint.String() != endpoint.String())
+
+				if !equalAllowedIPs(peer.AllowedIPs, allowedIPs) || peer.PresharedKey != presharedKey || peer.PersistentKeepaliveInterval != persistentKeepalive || endpointChanged {
+					// update peer
+					p := wgtypes.PeerConfig{
+						UpdateOnly:                  true,
+						AllowedIPs:                  allowedIPs,
+						PublicKey:                   peer.PublicKey,
+						PresharedKey:                &presharedKey,
+						PersistentKeepaliveInterval: &persistentKeepalive,
+						Endpoint:                    endpoint,
+						ReplaceAllowedIPs:           true,
+					}
+					peerConfigurationByPublicKey[p.PublicKey.String()] = p
 				}
-				peerConfigurationByPublicKey[p.PublicKey.String()] = p
 			}
 		}
 	}
@@ -318,10 +606,21 @@ func createPeersConfiguration(state agent.State, iface string) ([]wgtypes.PeerCo
 			continue
 		}
 
+		presharedKey, err := getPeerPresharedKey(state, peer)
+		if err != nil {
+			return []wgtypes.PeerConfig{}, err
+		}
+
+		persistentKeepalive := getPeerPersistentKeepalive(peer)
+		endpoint := getPeerStaticEndpoint(log, peer)
+
 		// create peer
 		p := wgtypes.PeerConfig{
-			AllowedIPs: getIP(peer.Spec.Address + "/32"),
-			PublicKey:  key,
+			AllowedIPs:                  getPeerAllowedIPs(state, peer),
+			PublicKey:                   key,
+			PresharedKey:                &presharedKey,
+			PersistentKeepaliveInterval: &persistentKeepalive,
+			Endpoint:                    endpoint,
 		}
 		peerConfigurationByPublicKey[p.PublicKey.String()] = p
 	}
@@ -335,7 +634,7 @@ func createPeersConfiguration(state agent.State, iface string) ([]wgtypes.PeerCo
 	return l, nil
 }
 
-func CreateWireguardConfiguration(state agent.State, iface string, listenPort int) (wgtypes.Config, error) {
+func CreateWireguardConfiguration(log logr.Logger, state agent.State, iface string, listenPort int) (wgtypes.Config, error) {
 	cfg := wgtypes.Config{}
 
 	key, err := wgtypes.ParseKey(state.ServerPrivateKey)
@@ -348,7 +647,7 @@ func CreateWireguardConfiguration(state agent.State, iface string, listenPort in
 	cfg.ReplacePeers = false
 	cfg.ListenPort = &listenPort
 
-	peers, err := createPeersConfiguration(state, iface)
+	peers, err := createPeersConfiguration(log, state, iface)
 	if err != nil {
 		return wgtypes.Config{}, err
 	}
@@ -0,0 +1,98 @@
+package wireguard
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/jodevsa/wireguard-operator/pkg/api/v1alpha1"
+	"github.com/vishvananda/netlink"
+)
+
+func TestGetPeerStaticEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		staticEndpoint string
+		want           string
+		wantLogged     bool
+	}{
+		{name: "no static endpoint"},
+		{name: "static endpoint", staticEndpoint: "32.121.45.102:51820", want: "32.121.45.102:51820"},
+		{name: "IPv6 static endpoint", staticEndpoint: "[2001:db8::10]:51820", want: "[2001:db8::10]:51820"},
+		{name: "static endpoint without port", staticEndpoint: "32.121.45.102", wantLogged: true},
+		{name: "static endpoint with unknown port", staticEndpoint: "32.121.45.102:wireguard-port", wantLogged: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := false
+			log := funcr.New(func(prefix, args string) { logged = true }, funcr.Options{})
+
+			peer := v1alpha1.WireguardPeer{Spec: v1alpha1.WireguardPeerSpec{StaticEndpoint: test.staticEndpoint}}
+			endpoint := getPeerStaticEndpoint(log, peer)
+
+			got := ""
+			if endpoint != nil {
+				got = endpoint.String()
+			}
+
+			if got != test.want {
+				t.Errorf("got endpoint %q, want %q", got, test.want)
+			}
+
+			if logged != test.wantLogged {
+				t.Errorf("got logged %v, want %v", logged, test.wantLogged)
+			}
+		})
+	}
+}
+
+func TestStaleAddresses(t *testing.T) {
+	address := func(cidr string) netlink.Addr {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.IP = ip
+		return netlink.Addr{IPNet: network}
+	}
+
+	tests := []struct {
+		name         string
+		addresses    []netlink.Addr
+		desired      string
+		wantStale    []string
+		wantAssigned bool
+	}{
+		{name: "no addresses", desired: "10.8.0.1/32"},
+		{name: "gateway assigned", addresses: []netlink.Addr{address("10.8.0.1/32")}, desired: "10.8.0.1/32", wantAssigned: true},
+		{name: "gateway of the previous network", addresses: []netlink.Addr{address("10.8.0.1/32")}, desired: "10.9.0.1/32", wantStale: []string{"10.8.0.1/32"}},
+		{name: "link-local address", addresses: []netlink.Addr{address("fe80::1/64"), address("fd00::1/128")}, desired: "fd00::1/128", wantAssigned: true},
+		{name: "family without network", addresses: []netlink.Addr{address("fe80::1/64"), address("fd00::1/128")}, wantStale: []string{"fd00::1/128"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var desired *net.IPNet
+			if test.desired != "" {
+				desired = address(test.desired).IPNet
+			}
+
+			stale, assigned := staleAddresses(test.addresses, desired)
+
+			var got []string
+			for _, address := range stale {
+				got = append(got, address.IPNet.String())
+			}
+
+			if !reflect.DeepEqual(got, test.wantStale) {
+				t.Errorf("got stale addresses %v, want %v", got, test.wantStale)
+			}
+
+			if assigned != test.wantAssigned {
+				t.Errorf("got assigned %v, want %v", assigned, test.wantAssigned)
+			}
+		})
+	}
+}